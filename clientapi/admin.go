@@ -0,0 +1,102 @@
+// Admin API for inspecting and retrying background jobs
+
+package clientapi
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/swayne275/showcal-backend-go/jobs"
+)
+
+const (
+	adminJobsEndpoint     = prefix + "admin/jobs"
+	adminRetryJobEndpoint = prefix + "admin/jobs/retry"
+)
+
+// admin endpoints need "Authorization: Bearer <adminToken>", and are
+// disabled when no token is set
+// TODO stored in environment variables for now, fix this
+var adminToken = os.Getenv("admintoken")
+
+type jobsResponse struct {
+	Jobs []jobs.Job `json:"jobs"`
+}
+
+// checks the admin token, writing an error response if it doesn't match
+func isAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		http.Error(w, "Admin API disabled", http.StatusForbidden)
+		return false
+	}
+
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(adminToken)) != 1 {
+		http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// GET returns job 'id', or the jobs with 'status' (all jobs if not given)
+func handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(w, r) {
+		return
+	}
+
+	if id, err := getQueryParam("id", r); err == nil {
+		job, ok, err := jobs.Get(id)
+		if err != nil {
+			fmt.Println("error in handleAdminJobs()", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "No such job", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, job, adminJobsEndpoint)
+		return
+	}
+
+	status, _ := getQueryParam("status", r)
+	list, err := jobs.List(status)
+	if err != nil {
+		fmt.Println("error in handleAdminJobs()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, jobsResponse{Jobs: list}, adminJobsEndpoint)
+}
+
+// POST queues dead job 'id' to run again
+func handleAdminRetryJob(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := getQueryParam("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := jobs.Retry(id)
+	if err != nil {
+		fmt.Println("error in handleAdminRetryJob()", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, job, adminRetryJobEndpoint)
+}
@@ -0,0 +1,38 @@
+package clientapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminJobsAuth(t *testing.T) {
+	oldToken := adminToken
+	defer func() { adminToken = oldToken }()
+
+	cases := []struct {
+		name       string
+		token      string
+		header     string
+		endpoint   string
+		wantStatus int
+	}{
+		{"disabled", "", "Bearer ", adminJobsEndpoint, http.StatusForbidden},
+		{"wrong token", "secret", "Bearer nope", adminJobsEndpoint, http.StatusUnauthorized},
+		{"list", "secret", "Bearer secret", adminJobsEndpoint, http.StatusOK},
+		{"missing job", "secret", "Bearer secret", adminJobsEndpoint + "?id=nope", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		adminToken = c.token
+		r := httptest.NewRequest(http.MethodGet, c.endpoint, nil)
+		r.Header.Set("Authorization", c.header)
+		w := httptest.NewRecorder()
+		handleAdminJobs(w, r)
+
+		if got := w.Result().StatusCode; got != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'",
+				c.name, c.wantStatus, got)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Client API for show name autocomplete, served from the local show index
+
+package clientapi
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/swayne275/showcal-backend-go/showindex"
+)
+
+const autocompleteEndpoint = prefix + "autocomplete"
+
+type autocompleteResponse struct {
+	Shows []showindex.Match `json:"shows"`
+}
+
+// GET returns shows whose names match the 'query' param as typed so far.
+// 'mode' is fuzzy (the default) or prefix, and 'limit' caps the matches
+func handleAutocomplete(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	query, err := getQueryParam("query", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mode, err := getQueryParam("mode", r)
+	if err != nil {
+		mode = showindex.ModeFuzzy
+	}
+	if mode != showindex.ModeFuzzy && mode != showindex.ModePrefix {
+		msg := fmt.Sprintf("Param 'mode' must be '%s' or '%s'", showindex.ModePrefix, showindex.ModeFuzzy)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	limit, err := getIntQueryParam("limit", r)
+	if err != nil || limit < 0 || limit > showindex.MaxLimit {
+		msg := fmt.Sprintf("Param 'limit' must be between 1 and %d", showindex.MaxLimit)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	response := autocompleteResponse{Shows: showindex.Lookup(query, mode, limit)}
+	writeJSON(w, response, autocompleteEndpoint)
+}
@@ -0,0 +1,55 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/swayne275/showcal-backend-go/showindex"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+func TestHandleAutocomplete(t *testing.T) {
+	showindex.Add(tvshowdata.Show{ID: 990001, Name: "Autocomplete Test Show"})
+
+	cases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCount  int
+	}{
+		{"prefix", "query=autocomplete+te&mode=prefix", http.StatusOK, 1},
+		{"fuzzy by default", "query=autocomplete+tset", http.StatusOK, 1},
+		{"no fuzzy in prefix mode", "query=autocomplete+tset&mode=prefix", http.StatusOK, 0},
+		{"no query", "", http.StatusBadRequest, 0},
+		{"bad mode", "query=a&mode=soundex", http.StatusBadRequest, 0},
+		{"bad limit", "query=a&limit=1000", http.StatusBadRequest, 0},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handleAutocomplete(w, httptest.NewRequest(http.MethodGet, autocompleteEndpoint+"?"+c.query, nil))
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'", c.name, c.wantStatus, w.Code)
+			continue
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got struct {
+			Shows []struct {
+				ID    int64 `json:"id"`
+				Fuzzy bool  `json:"fuzzy"`
+			} `json:"shows"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Shows) != c.wantCount {
+			t.Errorf("incorrect count for '%s': expected '%d', got '%d'", c.name, c.wantCount, len(got.Shows))
+		}
+	}
+}
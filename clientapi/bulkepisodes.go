@@ -0,0 +1,152 @@
+// Client API for fetching the episodes of many shows in one call
+
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+const (
+	bulkEpisodesEndpoint = prefix + "getepisodes/bulk"
+
+	// most shows in one bulk request
+	maxBulkIDs = 100
+	// shows fetched from episodate at once for a bulk request
+	maxBulkFetches = 4
+)
+
+// fetchUpcoming is swapped out in tests
+var fetchUpcoming = tvshowdata.FetchUpcomingEpisodesContext
+
+type bulkEpisodesRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// bulkEpisodesResult is either the episodes for one show, or why they
+// couldn't be fetched. Episodes is null on error, and empty for a show with
+// nothing matching
+type bulkEpisodesResult struct {
+	Episodes []tvshowdata.Episode `json:"episodes"`
+	Error    string               `json:"error,omitempty"`
+}
+
+type bulkEpisodesResponse struct {
+	Results map[int64]bulkEpisodesResult `json:"results"`
+}
+
+// POST takes {"ids": [...]} and returns the episodes for each show by id,
+// with the same filter params as getepisodes. A show that fails has an
+// error in place of its episodes, so the others are still returned
+func handleBulkEpisodes(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := getRequestBody(*r)
+	if err != nil {
+		fmt.Println("error in handleBulkEpisodes()", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request bulkEpisodesRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+		return
+	}
+	if len(request.IDs) == 0 || len(request.IDs) > maxBulkIDs {
+		msg := fmt.Sprintf("Between 1 and %d 'ids' are required", maxBulkIDs)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	for _, id := range request.IDs {
+		if id < 1 {
+			http.Error(w, fmt.Sprintf("Invalid id %d in 'ids'", id), http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, filtered, err := getEpisodeFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := fetchBulkEpisodes(r, request.IDs, filter, filtered)
+	if err := r.Context().Err(); err != nil {
+		// the client has gone, so there's no one to answer
+		fmt.Println("handleBulkEpisodes() request cancelled:", err)
+		return
+	}
+
+	writeJSON(w, bulkEpisodesResponse{Results: results}, bulkEpisodesEndpoint)
+}
+
+// fetchBulkEpisodes gets the episodes for each id a few at a time, and
+// stops starting fetches once the request is cancelled
+func fetchBulkEpisodes(r *http.Request, ids []int64, filter tvshowdata.EpisodeFilter,
+	filtered bool) map[int64]bulkEpisodesResult {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	results := make(map[int64]bulkEpisodesResult, len(ids))
+	slots := make(chan struct{}, maxBulkFetches)
+	ctx := r.Context()
+
+	for _, id := range ids {
+		mu.Lock()
+		_, seen := results[id]
+		results[id] = bulkEpisodesResult{}
+		mu.Unlock()
+		if seen {
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			var episodes tvshowdata.Episodes
+			var err error
+			if filtered {
+				episodes, err = getEpisodes(ctx, id, filter)
+			} else {
+				episodes, err = fetchUpcoming(ctx, id)
+			}
+
+			result := bulkEpisodesResult{Episodes: episodes.Episodes}
+			if err != nil {
+				fmt.Printf("error in handleBulkEpisodes() for show %d: %v\n", id, err)
+				result = bulkEpisodesResult{Error: "Unable to get episodes"}
+			} else if result.Episodes == nil {
+				result.Episodes = []tvshowdata.Episode{}
+			}
+
+			mu.Lock()
+			results[id] = result
+			mu.Unlock()
+		}(id)
+	}
+	wg.Wait()
+
+	return results
+}
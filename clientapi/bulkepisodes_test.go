@@ -0,0 +1,144 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+func TestHandleBulkEpisodes(t *testing.T) {
+	var mu sync.Mutex
+	var upcoming, filteredCalls int
+	oldUpcoming, oldGet := fetchUpcoming, getEpisodes
+	fetchUpcoming = func(ctx context.Context, id int64) (tvshowdata.Episodes, error) {
+		mu.Lock()
+		upcoming++
+		mu.Unlock()
+		switch id {
+		case 13:
+			return tvshowdata.Episodes{}, errors.New("api down")
+		case 7:
+			return tvshowdata.Episodes{}, nil
+		}
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{ShowID: id, Season: 1, Episode: 1}}}, nil
+	}
+	getEpisodes = func(ctx context.Context, id int64, filter tvshowdata.EpisodeFilter) (tvshowdata.Episodes, error) {
+		mu.Lock()
+		filteredCalls++
+		mu.Unlock()
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{ShowID: id, Season: filter.Season, Episode: 2}}}, nil
+	}
+	defer func() { fetchUpcoming, getEpisodes = oldUpcoming, oldGet }()
+
+	cases := []struct {
+		name         string
+		method       string
+		query        string
+		body         string
+		wantStatus   int
+		wantResults  string
+		wantUpcoming int
+		wantFiltered int
+	}{
+		{"upcoming", http.MethodPost, "", `{"ids":[1,13,7,1]}`, http.StatusOK, "1:1 7:0 13:error", 3, 0},
+		{"filtered", http.MethodPost, "?season=4", `{"ids":[2,3]}`, http.StatusOK, "2:1 3:1", 0, 2},
+		{"GET", http.MethodGet, "", "", http.StatusMethodNotAllowed, "", 0, 0},
+		{"bad json", http.MethodPost, "", `{"ids":`, http.StatusBadRequest, "", 0, 0},
+		{"no ids", http.MethodPost, "", `{"ids":[]}`, http.StatusBadRequest, "", 0, 0},
+		{"bad id", http.MethodPost, "", `{"ids":[1,0]}`, http.StatusBadRequest, "", 0, 0},
+		{"too many ids", http.MethodPost, "", `{"ids":[` + strings.Repeat("1,", maxBulkIDs) + `1]}`,
+			http.StatusBadRequest, "", 0, 0},
+		{"bad filter", http.MethodPost, "?season=x", `{"ids":[1]}`, http.StatusBadRequest, "", 0, 0},
+	}
+
+	for _, c := range cases {
+		upcoming, filteredCalls = 0, 0
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, bulkEpisodesEndpoint+c.query, strings.NewReader(c.body))
+		handleBulkEpisodes(w, r)
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'", c.name, c.wantStatus, w.Code)
+			continue
+		}
+		if upcoming != c.wantUpcoming || filteredCalls != c.wantFiltered {
+			t.Errorf("incorrect fetches for '%s': expected '%d/%d', got '%d/%d'", c.name,
+				c.wantUpcoming, c.wantFiltered, upcoming, filteredCalls)
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got bulkEpisodesResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if summary := bulkSummary(got); summary != c.wantResults {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%s'", c.name, c.wantResults, summary)
+		}
+	}
+}
+
+func TestHandleBulkEpisodesCancelled(t *testing.T) {
+	var mu sync.Mutex
+	calls, uncancelled := 0, 0
+	ctx, cancel := context.WithCancel(context.Background())
+	oldUpcoming := fetchUpcoming
+	fetchUpcoming = func(fetchCtx context.Context, id int64) (tvshowdata.Episodes, error) {
+		// the client goes away during the first fetches
+		cancel()
+		mu.Lock()
+		calls++
+		if fetchCtx.Err() == nil {
+			uncancelled++
+		}
+		mu.Unlock()
+		return tvshowdata.Episodes{}, nil
+	}
+	defer func() { fetchUpcoming = oldUpcoming }()
+
+	body := `{"ids":[1,2,3,4,5,6,7,8,9,10,11,12]}`
+	r := httptest.NewRequest(http.MethodPost, bulkEpisodesEndpoint, strings.NewReader(body)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handleBulkEpisodes(w, r)
+
+	if calls > maxBulkFetches {
+		t.Errorf("incorrect fetches after cancel: expected at most '%d', got '%d'", maxBulkFetches, calls)
+	}
+	if uncancelled != 0 {
+		t.Errorf("expected fetches to get the request context, '%d' didn't", uncancelled)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("incorrect output after cancel: expected nothing, got '%s'", w.Body.String())
+	}
+}
+
+// bulkSummary lists each id with its episode count or error, in id order
+func bulkSummary(response bulkEpisodesResponse) string {
+	var ids []int64
+	for id := range response.Results {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var parts []string
+	for _, id := range ids {
+		result := response.Results[id]
+		if result.Error != "" {
+			parts = append(parts, strconv.FormatInt(id, 10)+":error")
+			continue
+		}
+		parts = append(parts, strconv.FormatInt(id, 10)+":"+strconv.Itoa(len(result.Episodes)))
+	}
+
+	return strings.Join(parts, " ")
+}
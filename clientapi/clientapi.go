@@ -9,10 +9,13 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/showindex"
 	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"github.com/swayne275/showcal-backend-go/validation"
 )
 
 /*
@@ -30,8 +33,51 @@ const (
 	getEpisodesEndpoint = prefix + "getepisodes"
 	showSearchEndpoint  = prefix + "showsearch"
 	createEventEndpoint = prefix + "createevent"
+	// followed by the job id
+	jobsEndpoint = prefix + "jobs/"
 )
 
+// dates accepted by the episode filter params
+const dateFormat = "2006-01-02"
+
+// calls out to tvshowdata, swapped out in tests
+var (
+	getEpisodes = tvshowdata.GetEpisodesContext
+	searchShows = tvshowdata.SearchShows
+)
+
+// fills in where to watch and the rest from TVMaze, nil for none
+var episodeEnricher func([]tvshowdata.Episode) []tvshowdata.Episode
+
+// SetEpisodeEnricher sets what fills in the episodes getepisodes returns.
+// There is none by default
+func SetEpisodeEnricher(enricher func([]tvshowdata.Episode) []tvshowdata.Episode) {
+	episodeEnricher = enricher
+}
+
+// enrich runs the episode enricher on episodes, if there is one
+func enrich(episodes tvshowdata.Episodes) tvshowdata.Episodes {
+	if episodeEnricher == nil {
+		return episodes
+	}
+
+	episodes.Episodes = episodeEnricher(episodes.Episodes)
+	return episodes
+}
+
+// dryRunResponse is the createevent response when nothing is written
+type dryRunResponse struct {
+	DryRun bool                       `json:"dry_run"`
+	Events []gcalwrapper.PreviewEvent `json:"events"`
+}
+
+// asyncResponse is the createevent response when events are written in the
+// background
+type asyncResponse struct {
+	JobID     string `json:"job_id"`
+	StatusURL string `json:"status_url"`
+}
+
 func sayHello(w http.ResponseWriter, r *http.Request) {
 	setupCors(w)
 	if (*r).Method == "OPTIONS" {
@@ -72,6 +118,136 @@ func getQueryParam(key string, r *http.Request) (string, error) {
 	return keys[0], nil
 }
 
+// Return the requested boolean key, false if not present, or error if invalid
+func getBoolQueryParam(key string, r *http.Request) (bool, error) {
+	value, err := getQueryParam(key, r)
+	if err != nil {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Invalid value for param '%s'", key))
+		return false, err
+	}
+
+	return b, nil
+}
+
+// Return the requested integer key, 0 if not present, or error if invalid
+func getIntQueryParam(key string, r *http.Request) (int, error) {
+	value, err := getQueryParam(key, r)
+	if err != nil {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Invalid value for param '%s'", key))
+		return 0, err
+	}
+
+	return i, nil
+}
+
+// Return the requested time key, zero if not present, or error if invalid.
+// Accepts RFC 3339 or a date, which is the start of the day (UTC) unless
+// endOfDay
+func getTimeQueryParam(key string, r *http.Request, endOfDay bool) (time.Time, error) {
+	value, err := getQueryParam(key, r)
+	if err != nil {
+		return time.Time{}, nil
+	}
+
+	t, err := parseFilterTime(value, endOfDay)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Invalid value for param '%s'", key))
+		return time.Time{}, err
+	}
+
+	return t, nil
+}
+
+// Parse an RFC 3339 time or a date, which is the start of the day (UTC)
+// unless endOfDay
+func parseFilterTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateFormat, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	return t, nil
+}
+
+// Return the episode filter from the request query, and if any filter was
+// given
+func getEpisodeFilter(r *http.Request) (tvshowdata.EpisodeFilter, bool, error) {
+	var filter tvshowdata.EpisodeFilter
+	var err error
+
+	query := r.URL.Query()
+	filtered := false
+	for _, key := range []string{"from", "to", "season", "include_past"} {
+		if _, ok := query[key]; ok {
+			filtered = true
+		}
+	}
+
+	if filter.IncludePast, err = getBoolQueryParam("include_past", r); err != nil {
+		return filter, filtered, err
+	}
+	if filter.From, err = getTimeQueryParam("from", r, false); err != nil {
+		return filter, filtered, err
+	}
+	if filter.To, err = getTimeQueryParam("to", r, true); err != nil {
+		return filter, filtered, err
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return filter, filtered, errors.New("Param 'to' is before 'from'")
+	}
+	if season, err := getQueryParam("season", r); err == nil {
+		filter.Season, err = strconv.ParseInt(season, 10, 64)
+		if err != nil || filter.Season < 0 {
+			return filter, filtered, errors.New("Invalid value for param 'season'")
+		}
+		// season 0 is the specials
+		filter.SpecialsOnly = filter.Season == 0
+	}
+
+	return filter, filtered, nil
+}
+
+// Write v as the JSON response for endpoint
+func writeJSON(w http.ResponseWriter, v interface{}, endpoint string) {
+	writeJSONStatus(w, http.StatusOK, v, endpoint)
+}
+
+// Write v as the JSON response for endpoint, with status
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}, endpoint string) {
+	output, err := json.Marshal(v)
+	if err != nil {
+		msg := fmt.Sprintf("Unable to process response in %s", endpoint)
+		err = errors.Wrap(err, msg)
+		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(output)
+	if err != nil {
+		// TODO handle errors better
+		fmt.Println(endpoint, err)
+	}
+}
+
 func handleGetEpisodes(w http.ResponseWriter, r *http.Request) {
 	setupCors(w)
 	if (*r).Method == "OPTIONS" {
@@ -92,9 +268,32 @@ func handleGetEpisodes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, filtered, err := getEpisodeFilter(r)
+	if err != nil {
+		fmt.Println("error in handleGetEpisodes()", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filtered {
+		episodes, err := getEpisodes(r.Context(), id, filter)
+		if err != nil {
+			fmt.Println("error in handleGetEpisodes()", err)
+			http.Error(w, "Unable to get episodes", http.StatusBadGateway)
+			return
+		}
+		if len(episodes.Episodes) == 0 {
+			http.Error(w, "No matching episodes", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, enrich(episodes), getEpisodesEndpoint)
+		return
+	}
+
+	// upcoming episodes by default
 	haveEpisodes, episodes := tvshowdata.GetShowData(id)
 	if haveEpisodes {
-		output, err := json.Marshal(episodes)
+		output, err := json.Marshal(enrich(episodes))
 		if err != nil {
 			msg := fmt.Sprintf("Unable to process upcoming shows in %s", getEpisodesEndpoint)
 			err = errors.Wrapf(err, msg)
@@ -127,30 +326,90 @@ func handleShowSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO get candidate episodes, write back
-	haveCandidates, candidateShows := tvshowdata.GetCandidateShows(query)
-	if haveCandidates {
-		output, err := json.Marshal(candidateShows)
-		if err != nil {
-			msg := fmt.Sprintf("Unable to process candidate shows in %s", showSearchEndpoint)
-			err = errors.Wrapf(err, msg)
-			fmt.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	opts, err := getSearchOptions(r)
+	if err != nil {
+		fmt.Println("Error in handleShowSearch():", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		w.Header().Set("content-type", "application/json")
-		_, err = w.Write(output)
-		if err != nil {
-			// TODO handle errors better
-			fmt.Println("searchUpcomingEpisodes()", err)
-		}
-	} else {
+	result, err := searchShows(query, opts)
+	if errors.Cause(err) == tvshowdata.ErrInvalidCursor {
+		http.Error(w, "Invalid value for param 'cursor'", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error in handleShowSearch():", err)
+		http.Error(w, "Unable to search shows", http.StatusBadGateway)
+		return
+	}
+	if result.Total == 0 {
 		http.Error(w, "No shows matching that query", http.StatusNotFound)
+		return
+	}
+	// so autocomplete knows the shows people look for
+	showindex.Add(result.Shows...)
+
+	writeJSON(w, result, showSearchEndpoint)
+}
+
+// Return the page and filters for a show search from the request query
+func getSearchOptions(r *http.Request) (tvshowdata.SearchOptions, error) {
+	var opts tvshowdata.SearchOptions
+	var err error
+
+	if opts.Page, err = getIntQueryParam("page", r); err != nil || opts.Page < 0 {
+		return opts, errors.New("Invalid value for param 'page'")
+	}
+	opts.PageSize, err = getIntQueryParam("page_size", r)
+	if err != nil || opts.PageSize < 0 || opts.PageSize > tvshowdata.MaxSearchPageSize {
+		msg := fmt.Sprintf("Param 'page_size' must be between 1 and %d", tvshowdata.MaxSearchPageSize)
+		return opts, errors.New(msg)
+	}
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = tvshowdata.DefaultSearchPageSize
+	}
+	if maxPage := tvshowdata.MaxSearchResults/pageSize + 1; opts.Page > maxPage {
+		return opts, errors.New(fmt.Sprintf("Param 'page' must be at most %d", maxPage))
+	}
+	if opts.RunningOnly, err = getBoolQueryParam("running_only", r); err != nil {
+		return opts, err
 	}
+	if status, err := getQueryParam("status", r); err == nil {
+		opts.Status = tvshowdata.ShowStatus(status)
+		if !opts.Status.Valid() {
+			return opts, errors.New("Invalid value for param 'status'")
+		}
+	}
+	opts.Cursor, _ = getQueryParam("cursor", r)
+	opts.Network, _ = getQueryParam("network", r)
+	opts.Country, _ = getQueryParam("country", r)
+
+	return opts, nil
 }
 
 func handleCalendarAdd(w http.ResponseWriter, r *http.Request) {
+	// default to google calendar for existing clients
+	provider, err := getQueryParam("calendar", r)
+	if err != nil {
+		provider = gcalwrapper.ProviderGoogle
+	}
+
+	dryRun, err := getBoolQueryParam("dry_run", r)
+	if err != nil {
+		fmt.Println("error in handleCalendarAdd()", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	async, err := getBoolQueryParam("async", r)
+	if err != nil {
+		fmt.Println("error in handleCalendarAdd()", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	body, err := getRequestBody(*r)
 	if err != nil {
 		fmt.Println(err)
@@ -158,26 +417,96 @@ func handleCalendarAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var episodes tvshowdata.Episodes
-	err = json.Unmarshal(body, &episodes)
+	var request createEventRequest
+	err = json.Unmarshal(body, &request)
 	if err != nil {
 		fmt.Println(err)
 		http.Error(w, "Invalid 'episodes' data", http.StatusBadRequest)
 		return
 	}
-	if len(episodes.Episodes) == 0 {
-		fmt.Println("No episodes")
-		http.Error(w, "No episodes provided", http.StatusBadRequest)
+
+	episodes, status, err := resolveEpisodes(r.Context(), request)
+	if report, ok := err.(validation.Report); ok {
+		writeJSONStatus(w, status, report, createEventEndpoint)
+		return
+	}
+	if err != nil {
+		fmt.Println("error in handleCalendarAdd()", err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if dryRun {
+		// previews don't write anything, so they don't need a login
+		previews, err := gcalwrapper.PreviewEpisodesForCalendar(userID, provider, episodes)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, dryRunResponse{DryRun: true, Events: previews}, createEventEndpoint)
+		return
+	}
+
+	if !loggedIn {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	if async {
+		job, err := gcalwrapper.EnqueueAddEpisodes(userID, provider, episodes)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		response := asyncResponse{JobID: job.ID, StatusURL: jobsEndpoint + job.ID}
+		writeJSONStatus(w, http.StatusAccepted, response, createEventEndpoint)
 		return
 	}
 
-	gcalwrapper.AddEpisodesToCalendar(episodes)
-	msg := "Created calendar event"
-	_, err = w.Write([]byte(msg))
+	result, err := gcalwrapper.AddEpisodesToCalendar(r.Context(), userID, provider, episodes)
 	if err != nil {
-		// TODO handle errors better
-		fmt.Println("handleCalendarAdd():", err)
+		fmt.Println(err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if result.Throttled > 0 {
+		fmt.Printf("handleCalendarAdd(): %d calls throttled by %s\n", result.Throttled, provider)
+	}
+
+	writeJSON(w, result, createEventEndpoint)
+}
+
+// GET reports the progress of the user's createevent job, by id in the path
+func handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if !loggedIn {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
 	}
+
+	id := strings.TrimPrefix(r.URL.Path, jobsEndpoint)
+	status, ok, err := gcalwrapper.GetAddJobStatus(userID, id)
+	if err != nil {
+		fmt.Println("error in handleJobStatus()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "No such job", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, status, jobsEndpoint)
 }
 
 // StartClientAPI starts the web server hosting the client API
@@ -186,9 +515,28 @@ func StartClientAPI(port string) error {
 	http.HandleFunc("/login", gcalwrapper.HandleLogin)
 	http.HandleFunc("/GoogleLogin", gcalwrapper.HandleGoogleLogin)
 	http.HandleFunc("/GoogleCallback", gcalwrapper.HandleGoogleCallback)
+	http.HandleFunc("/OutlookLogin", gcalwrapper.HandleOutlookLogin)
+	http.HandleFunc("/OutlookCallback", gcalwrapper.HandleOutlookCallback)
 	http.HandleFunc(getEpisodesEndpoint, handleGetEpisodes)
+	http.HandleFunc(bulkEpisodesEndpoint, handleBulkEpisodes)
 	http.HandleFunc(showSearchEndpoint, handleShowSearch)
+	http.HandleFunc(showsEndpoint, handleShow)
+	http.HandleFunc(autocompleteEndpoint, handleAutocomplete)
 	http.HandleFunc(createEventEndpoint, handleCalendarAdd)
+	http.HandleFunc(jobsEndpoint, handleJobStatus)
+	http.HandleFunc(eventsEndpoint, handleEvents)
+	http.HandleFunc(webhooksEndpoint, handleWebhooks)
+	http.HandleFunc(deleteWebhookEndpoint, handleDeleteWebhook)
+	http.HandleFunc(webhookDeliveriesEndpoint, handleWebhookDeliveries)
+	http.HandleFunc(pingWebhookEndpoint, handlePingWebhook)
+	http.HandleFunc(templatesEndpoint, handleTemplates)
+	http.HandleFunc(previewTemplatesEndpoint, handlePreviewTemplates)
+	http.HandleFunc(subscribeEndpoint, handleSubscribe)
+	http.HandleFunc(unsubscribeEndpoint, handleUnsubscribe)
+	http.HandleFunc(subscriptionsEndpoint, handleSubscriptions)
+	http.HandleFunc(scheduleEndpoint, handleSchedule)
+	http.HandleFunc(adminJobsEndpoint, handleAdminJobs)
+	http.HandleFunc(adminRetryJobEndpoint, handleAdminRetryJob)
 
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
 		msg := fmt.Sprintf("Could not start client API server on port %s", port)
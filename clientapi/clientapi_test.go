@@ -1,11 +1,14 @@
 package clientapi
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
 )
 
 // cause ioutil.ReadAll() in function under test to error
@@ -16,6 +19,17 @@ func (errReader) Read(buf []byte) (n int, err error) {
 	return 0, errors.New("test error")
 }
 
+// loginAs attaches a new session for userID to r
+func loginAs(t *testing.T, r *http.Request, userID string) {
+	w := httptest.NewRecorder()
+	if err := gcalwrapper.StartSession(w, userID); err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+}
+
 func TestGetRequestBody(t *testing.T) {
 	cases := []struct {
 		name    string
@@ -84,3 +98,86 @@ func TestSetupCors(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleCalendarAddDryRun(t *testing.T) {
+	body := `{"episodes":[{"season":1,"episode":1,"name":"B","air_date":"2119-09-03 02:00:00","runtime":30,"show_name":"A","show_id":7}]}`
+	cases := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{"dry run needs no login", createEventEndpoint + "?dry_run=true", http.StatusOK},
+		{"bad dry run value", createEventEndpoint + "?dry_run=maybe", http.StatusBadRequest},
+		{"unknown calendar", createEventEndpoint + "?dry_run=1&calendar=junk", http.StatusBadRequest},
+		{"real add needs login", createEventEndpoint, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handleCalendarAdd(w, httptest.NewRequest(http.MethodPost, c.url, strings.NewReader(body)))
+		resp := w.Result()
+
+		if resp.StatusCode != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'",
+				c.name, c.wantStatus, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandleCalendarAddAsync(t *testing.T) {
+	gcalwrapper.SetTokenStore(googleTokens{})
+	body := `{"episodes":[{"season":1,"episode":1,"name":"B","show_name":"A","show_id":7,"runtime":30,` +
+		`"air_date":"2119-01-01 00:00:00"}]}`
+
+	r := httptest.NewRequest(http.MethodPost, createEventEndpoint+"?async=true",
+		strings.NewReader(body))
+	loginAs(t, r, "async-user")
+	w := httptest.NewRecorder()
+	handleCalendarAdd(w, r)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("incorrect status: expected '%d', got '%d'", http.StatusAccepted, resp.StatusCode)
+	}
+
+	var accepted asyncResponse
+	if err := json.NewDecoder(resp.Body).Decode(&accepted); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		user       string
+		url        string
+		wantStatus int
+	}{
+		{"owner", "async-user", accepted.StatusURL, http.StatusOK},
+		{"other user", "someone-else", accepted.StatusURL, http.StatusNotFound},
+		{"missing job", "async-user", jobsEndpoint + "nope", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.url, nil)
+		loginAs(t, r, c.user)
+		w := httptest.NewRecorder()
+		handleJobStatus(w, r)
+		resp := w.Result()
+
+		if resp.StatusCode != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'",
+				c.name, c.wantStatus, resp.StatusCode)
+			continue
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got gcalwrapper.AddJobStatus
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		// no workers run in this test, so the job is still waiting
+		if got.ID != accepted.JobID || got.Pending != 1 {
+			t.Errorf("incorrect job status for '%s': got '%+v'", c.name, got)
+		}
+	}
+}
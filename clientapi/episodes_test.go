@@ -0,0 +1,92 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/net/context"
+)
+
+func TestHandleGetEpisodesFilter(t *testing.T) {
+	var gotFilter tvshowdata.EpisodeFilter
+	oldGet := getEpisodes
+	getEpisodes = func(ctx context.Context, id int64, filter tvshowdata.EpisodeFilter) (tvshowdata.Episodes, error) {
+		gotFilter = filter
+		if filter.Season == 9 {
+			return tvshowdata.Episodes{}, nil
+		}
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{Season: 1, Episode: 1, Title: "Pilot"}}}, nil
+	}
+	defer func() { getEpisodes = oldGet }()
+
+	day := func(s string) time.Time {
+		t, _ := time.Parse(dateFormat, s)
+		return t
+	}
+
+	cases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantFilter tvshowdata.EpisodeFilter
+	}{
+		{"all episodes", "&include_past=true", http.StatusOK, tvshowdata.EpisodeFilter{IncludePast: true}},
+		{"season", "&season=3", http.StatusOK, tvshowdata.EpisodeFilter{Season: 3}},
+		{"specials", "&season=0", http.StatusOK, tvshowdata.EpisodeFilter{SpecialsOnly: true}},
+		{"date window", "&include_past=1&from=2019-01-01&to=2019-01-31", http.StatusOK,
+			tvshowdata.EpisodeFilter{IncludePast: true, From: day("2019-01-01"),
+				To: day("2019-02-01").Add(-time.Nanosecond)}},
+		{"rfc 3339 from", "&from=2019-01-01T10:00:00Z", http.StatusOK,
+			tvshowdata.EpisodeFilter{From: day("2019-01-01").Add(10 * time.Hour)}},
+		{"nothing matches", "&season=9", http.StatusNotFound, tvshowdata.EpisodeFilter{}},
+		{"bad season", "&season=x", http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"negative season", "&season=-1", http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"bad include_past", "&include_past=maybe", http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"bad from", "&from=yesterday", http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"to before from", "&from=2019-02-01&to=2019-01-01", http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+	}
+
+	for _, c := range cases {
+		gotFilter = tvshowdata.EpisodeFilter{}
+		w := httptest.NewRecorder()
+		handleGetEpisodes(w, httptest.NewRequest(http.MethodGet, getEpisodesEndpoint+"?id=2550"+c.query, nil))
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'", c.name, c.wantStatus, w.Code)
+		}
+		if c.wantStatus == http.StatusOK && gotFilter != c.wantFilter {
+			t.Errorf("incorrect filter for '%s': expected '%+v', got '%+v'", c.name, c.wantFilter, gotFilter)
+		}
+	}
+}
+
+func TestHandleGetEpisodesEnriched(t *testing.T) {
+	oldGet := getEpisodes
+	getEpisodes = func(ctx context.Context, id int64, filter tvshowdata.EpisodeFilter) (tvshowdata.Episodes, error) {
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{Season: 1, Episode: 1, Title: "Pilot"}}}, nil
+	}
+	defer func() { getEpisodes = oldGet }()
+
+	defer SetEpisodeEnricher(nil)
+	SetEpisodeEnricher(func(episodes []tvshowdata.Episode) []tvshowdata.Episode {
+		for idx := range episodes {
+			episodes[idx].WhereToWatch = tvshowdata.ChannelFor("Netflix", true)
+		}
+		return episodes
+	})
+
+	w := httptest.NewRecorder()
+	handleGetEpisodes(w, httptest.NewRequest(http.MethodGet, getEpisodesEndpoint+"?id=2550&season=1", nil))
+
+	var got tvshowdata.Episodes
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unable to decode response '%s': %v", w.Body.String(), err)
+	}
+	if len(got.Episodes) != 1 || got.Episodes[0].WhereToWatch.Name != "Netflix" {
+		t.Errorf("incorrect output: expected episodes on 'Netflix', got '%+v'", got.Episodes)
+	}
+}
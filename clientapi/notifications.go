@@ -0,0 +1,93 @@
+// Client API streaming a user's notifications as Server-Sent Events
+
+package clientapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/notify"
+)
+
+const eventsEndpoint = prefix + "events"
+
+// how often a comment is sent to keep idle connections open
+var heartbeatInterval = 30 * time.Second
+
+// GET streams the user's notifications. Clients resuming after a dropped
+// connection get the events they missed from the Last-Event-ID header (or
+// 'last_event_id' param), as far back as the history goes
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if !loggedIn {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID, _ = getQueryParam("last_event_id", r)
+	}
+	var lastEventID uint64
+	if lastID != "" {
+		var err error
+		lastEventID, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	listener, missed := notify.Listen(userID, lastEventID)
+	defer listener.Close()
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range missed {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-listener.C:
+			if !ok {
+				// fell behind, the client reconnects with Last-Event-ID
+				return
+			}
+			writeEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes event in the text/event-stream format
+func writeEvent(w http.ResponseWriter, event notify.Event) {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	if err != nil {
+		fmt.Println("writeEvent()", err)
+	}
+}
@@ -0,0 +1,91 @@
+package clientapi
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/swayne275/showcal-backend-go/notify"
+)
+
+// readEvent reads the next event from an event stream, as its lines
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("incorrect status without login: expected '%d', got '%d'",
+			http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// an earlier session saw the first event, then dropped
+	listener, missed := notify.Listen("events-user", 0)
+	if len(missed) != 0 {
+		t.Fatalf("did not expect missed events, got '%+v'", missed)
+	}
+	notify.Publish("events-user", notify.EpisodeCreated, map[string]string{"key": "A/S01E01"})
+	seen := <-listener.C
+	listener.Close()
+	notify.Publish("events-user", notify.EpisodeFailed, map[string]string{"key": "A/S01E02"})
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginAs(t, req, "events-user")
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(seen.ID, 10))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("content-type"); got != "text/event-stream" {
+		t.Errorf("incorrect content type: expected 'text/event-stream', got '%s'", got)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	// ids are shared across users, so only check the ones after the resume
+	// point are replayed, then live events follow
+	missedEvent := readEvent(t, reader)
+	notify.Publish("events-user", notify.TokenExpired, map[string]string{"calendar": "google"})
+	liveEvent := readEvent(t, reader)
+
+	cases := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"missed event", missedEvent, "event: " + notify.EpisodeFailed},
+		{"live event", liveEvent, "event: " + notify.TokenExpired},
+	}
+
+	for _, c := range cases {
+		if len(c.lines) != 3 || c.lines[1] != c.want || !strings.HasPrefix(c.lines[2], "data: {") {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%v'", c.name, c.want, c.lines)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Client API for the agenda of upcoming episodes across followed shows
+
+package clientapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/schedule"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+)
+
+const scheduleEndpoint = prefix + "schedule"
+
+// buildSchedule is swapped out in tests
+var buildSchedule = schedule.Build
+
+// GET returns the episodes of every show the user follows airing in the
+// next 'days' days, grouped by day in the IANA timezone 'tz' (UTC if not
+// given). Shows that couldn't be fetched are listed under 'failed'
+func handleSchedule(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if !loggedIn {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	days, err := getIntQueryParam("days", r)
+	if err != nil || days < 0 || days > schedule.MaxDays {
+		msg := fmt.Sprintf("Param 'days' must be between 1 and %d", schedule.MaxDays)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	loc := time.UTC
+	if tz, err := getQueryParam("tz", r); err == nil {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			http.Error(w, "Invalid value for param 'tz'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	subs, err := subscriptions.UserSubscriptions(userID)
+	if err != nil {
+		fmt.Println("error in handleSchedule()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	showIDs := make([]int64, 0, len(subs))
+	for _, sub := range subs {
+		showIDs = append(showIDs, sub.ShowID)
+	}
+
+	result := buildSchedule(showIDs, days, loc, time.Now())
+	if len(showIDs) > 0 && len(result.Failed) == len(showIDs) {
+		// nothing to show, not just a partial schedule
+		writeJSONStatus(w, http.StatusBadGateway, result, scheduleEndpoint)
+		return
+	}
+
+	writeJSON(w, result, scheduleEndpoint)
+}
@@ -0,0 +1,77 @@
+package clientapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/schedule"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+)
+
+func TestHandleSchedule(t *testing.T) {
+	var gotShows []int64
+	var gotDays int
+	var gotLoc *time.Location
+	oldBuild := buildSchedule
+	buildSchedule = func(showIDs []int64, days int, loc *time.Location, now time.Time) schedule.Schedule {
+		gotShows, gotDays, gotLoc = showIDs, days, loc
+		result := schedule.Schedule{Days: []schedule.Day{}}
+		for _, id := range showIDs {
+			if id == 666 {
+				result.Failed = append(result.Failed, schedule.FailedShow{ShowID: id, Error: "api down"})
+			}
+		}
+		return result
+	}
+	defer func() { buildSchedule = oldBuild }()
+
+	if _, err := subscriptions.Subscribe("schedule-user", 42, "google"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := subscriptions.Subscribe("schedule-broken-user", 666, "google"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		query      string
+		user       string
+		wantStatus int
+		wantDays   int
+		wantTZ     string
+		wantShows  int
+	}{
+		{"needs login", "", "", http.StatusUnauthorized, 0, "", 0},
+		{"defaults", "", "schedule-user", http.StatusOK, 0, "UTC", 1},
+		{"days and timezone", "?days=3&tz=America/New_York", "schedule-user", http.StatusOK, 3, "America/New_York", 1},
+		{"too many days", "?days=31", "schedule-user", http.StatusBadRequest, 0, "", 0},
+		{"bad days", "?days=week", "schedule-user", http.StatusBadRequest, 0, "", 0},
+		{"bad timezone", "?tz=Mars/Olympus", "schedule-user", http.StatusBadRequest, 0, "", 0},
+		{"nothing followed", "", "schedule-nobody", http.StatusOK, 0, "UTC", 0},
+		{"every show failed", "", "schedule-broken-user", http.StatusBadGateway, 0, "UTC", 1},
+	}
+
+	for _, c := range cases {
+		gotShows, gotDays, gotLoc = nil, 0, nil
+		r := httptest.NewRequest(http.MethodGet, scheduleEndpoint+c.query, nil)
+		if c.user != "" {
+			loginAs(t, r, c.user)
+		}
+		w := httptest.NewRecorder()
+		handleSchedule(w, r)
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'", c.name, c.wantStatus, w.Code)
+			continue
+		}
+		if c.wantTZ == "" {
+			continue
+		}
+		if gotDays != c.wantDays || gotLoc.String() != c.wantTZ || len(gotShows) != c.wantShows {
+			t.Errorf("incorrect build for '%s': expected '%d %s %d', got '%d %s %d'", c.name,
+				c.wantDays, c.wantTZ, c.wantShows, gotDays, gotLoc, len(gotShows))
+		}
+	}
+}
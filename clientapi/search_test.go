@@ -0,0 +1,68 @@
+package clientapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+func TestHandleShowSearch(t *testing.T) {
+	var gotOpts tvshowdata.SearchOptions
+	oldSearch := searchShows
+	searchShows = func(query string, opts tvshowdata.SearchOptions) (tvshowdata.SearchResult, error) {
+		gotOpts = opts
+		switch {
+		case query == "nothing":
+			return tvshowdata.SearchResult{}, nil
+		case query == "down":
+			return tvshowdata.SearchResult{}, errors.New("api down")
+		case opts.Cursor == "bad":
+			return tvshowdata.SearchResult{}, errors.Wrap(tvshowdata.ErrInvalidCursor, "test")
+		}
+		return tvshowdata.SearchResult{Shows: []tvshowdata.Show{{ID: 1, Name: "Office"}}, Total: 1}, nil
+	}
+	defer func() { searchShows = oldSearch }()
+
+	cases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantOpts   tvshowdata.SearchOptions
+	}{
+		{"defaults", "query=office", http.StatusOK, tvshowdata.SearchOptions{}},
+		{"page", "query=office&page=2&page_size=10", http.StatusOK,
+			tvshowdata.SearchOptions{Page: 2, PageSize: 10}},
+		{"filters", "query=office&running_only=true&network=NBC&country=US", http.StatusOK,
+			tvshowdata.SearchOptions{RunningOnly: true, Network: "NBC", Country: "US"}},
+		{"status", "query=office&status=in_development", http.StatusOK,
+			tvshowdata.SearchOptions{Status: tvshowdata.ShowStatusInDevelopment}},
+		{"bad status", "query=office&status=Ended", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"cursor", "query=office&cursor=MjA", http.StatusOK, tvshowdata.SearchOptions{Cursor: "MjA"}},
+		{"no query", "", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"bad page", "query=office&page=x", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"page too big", "query=office&page_size=500", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"last page", "query=office&page=6", http.StatusOK, tvshowdata.SearchOptions{Page: 6}},
+		{"past the last page", "query=office&page=7", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"overflowing page", "query=office&page=461168601842738792", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"bad running_only", "query=office&running_only=maybe", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"bad cursor", "query=office&cursor=bad", http.StatusBadRequest, tvshowdata.SearchOptions{}},
+		{"no matches", "query=nothing", http.StatusNotFound, tvshowdata.SearchOptions{}},
+		{"api error", "query=down", http.StatusBadGateway, tvshowdata.SearchOptions{}},
+	}
+
+	for _, c := range cases {
+		gotOpts = tvshowdata.SearchOptions{}
+		w := httptest.NewRecorder()
+		handleShowSearch(w, httptest.NewRequest(http.MethodGet, showSearchEndpoint+"?"+c.query, nil))
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'", c.name, c.wantStatus, w.Code)
+		}
+		if c.wantStatus == http.StatusOK && gotOpts != c.wantOpts {
+			t.Errorf("incorrect options for '%s': expected '%+v', got '%+v'", c.name, c.wantOpts, gotOpts)
+		}
+	}
+}
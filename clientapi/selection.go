@@ -0,0 +1,159 @@
+// Picking a show's episodes on the server for createevent
+
+package clientapi
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"github.com/swayne275/showcal-backend-go/validation"
+	"golang.org/x/net/context"
+)
+
+// createEventRequest is the createevent body. Either the episodes are given,
+// or a show and a selector that picks its episodes. Episodes given with a
+// show are verified against the show's listing
+type createEventRequest struct {
+	Episodes []tvshowdata.Episode `json:"episodes"`
+	ShowID   int64                `json:"show_id"`
+	Select   episodeSelector      `json:"select"`
+}
+
+// episodeSelector picks which of a show's episodes to add. The zero value
+// picks every upcoming episode
+type episodeSelector struct {
+	Season int64 `json:"season"`
+	// Specials picks only specials, which episodate puts in season 0
+	Specials bool `json:"specials"`
+	// FirstEpisode and LastEpisode are a range within Season, or the specials
+	FirstEpisode int64 `json:"first_episode"`
+	LastEpisode  int64 `json:"last_episode"`
+	// Next keeps only the next N episodes
+	Next int `json:"next"`
+	// From and To are an air date window, as RFC 3339 times or dates
+	From string `json:"from"`
+	To   string `json:"to"`
+	// IncludePast allows episodes that have already aired
+	IncludePast bool `json:"include_past"`
+}
+
+// resolveEpisodes returns the episodes a createevent request is for, or the
+// error status and message for a bad request. Invalid client episodes give
+// a validation.Report
+func resolveEpisodes(ctx context.Context, request createEventRequest) (tvshowdata.Episodes, int, error) {
+	if request.ShowID < 0 {
+		return tvshowdata.Episodes{}, http.StatusBadRequest, errors.New("Invalid 'show_id'")
+	}
+	if request.ShowID == 0 && len(request.Episodes) == 0 {
+		fmt.Println("No episodes")
+		return tvshowdata.Episodes{}, http.StatusBadRequest, errors.New("No episodes provided")
+	}
+	if len(request.Episodes) > 0 {
+		return checkClientEpisodes(ctx, request)
+	}
+
+	filter, err := request.Select.filter()
+	if err != nil {
+		return tvshowdata.Episodes{}, http.StatusBadRequest, err
+	}
+
+	episodes, err := getEpisodes(ctx, request.ShowID, filter)
+	if err != nil {
+		err = errors.Wrapf(err, "Unable to get episodes for show %d", request.ShowID)
+		return tvshowdata.Episodes{}, http.StatusBadGateway, err
+	}
+	if len(episodes.Episodes) == 0 {
+		return tvshowdata.Episodes{}, http.StatusNotFound, errors.New("No episodes match the selection")
+	}
+
+	return episodes, http.StatusOK, nil
+}
+
+// checkClientEpisodes validates the episodes the client sent, verifying them
+// against the show's listing if the request names a show
+func checkClientEpisodes(ctx context.Context, request createEventRequest) (tvshowdata.Episodes, int, error) {
+	if request.Select != (episodeSelector{}) {
+		err := errors.New("'select' can't be used with 'episodes'")
+		return tvshowdata.Episodes{}, http.StatusBadRequest, err
+	}
+
+	// older clients only name the show once, for the whole request
+	for idx := range request.Episodes {
+		if request.Episodes[idx].ShowID == 0 && request.ShowID != 0 {
+			request.Episodes[idx].SetEpisodateIDs(request.ShowID)
+		}
+	}
+
+	report := validation.Episodes(request.Episodes, time.Now())
+	if report.Valid() {
+		// episode IDs key the synced events, so they're never the client's
+		for idx := range request.Episodes {
+			request.Episodes[idx].SetEpisodateIDs(request.Episodes[idx].ShowID)
+		}
+	}
+	if report.Valid() && request.ShowID != 0 {
+		listing, err := getEpisodes(ctx, request.ShowID, tvshowdata.EpisodeFilter{IncludePast: true})
+		if err != nil {
+			err = errors.Wrapf(err, "Unable to verify episodes for show %d", request.ShowID)
+			return tvshowdata.Episodes{}, http.StatusBadGateway, err
+		}
+		report = validation.VerifyEpisodes(request.Episodes, listing.Episodes)
+	}
+	if !report.Valid() {
+		return tvshowdata.Episodes{}, http.StatusUnprocessableEntity, report
+	}
+
+	return tvshowdata.Episodes{Episodes: request.Episodes}, http.StatusOK, nil
+}
+
+// filter converts the selector to a tvshowdata filter, checking it makes
+// sense
+func (s episodeSelector) filter() (tvshowdata.EpisodeFilter, error) {
+	filter := tvshowdata.EpisodeFilter{
+		Season:       s.Season,
+		SpecialsOnly: s.Specials,
+		FirstEpisode: s.FirstEpisode,
+		LastEpisode:  s.LastEpisode,
+		Limit:        s.Next,
+		IncludePast:  s.IncludePast,
+	}
+
+	if s.Season < 0 {
+		return filter, errors.New("Invalid 'season'")
+	}
+	if s.FirstEpisode < 0 || s.LastEpisode < 0 {
+		return filter, errors.New("Invalid episode range")
+	}
+	if s.Specials && s.Season != 0 {
+		return filter, errors.New("'specials' can't be used with 'season'")
+	}
+	if (s.FirstEpisode != 0 || s.LastEpisode != 0) && s.Season == 0 && !s.Specials {
+		return filter, errors.New("An episode range needs a 'season'")
+	}
+	if s.LastEpisode != 0 && s.LastEpisode < s.FirstEpisode {
+		return filter, errors.New("'last_episode' is before 'first_episode'")
+	}
+	if s.Next < 0 {
+		return filter, errors.New("Invalid 'next'")
+	}
+
+	var err error
+	if s.From != "" {
+		if filter.From, err = parseFilterTime(s.From, false); err != nil {
+			return filter, errors.New("Invalid 'from'")
+		}
+	}
+	if s.To != "" {
+		if filter.To, err = parseFilterTime(s.To, true); err != nil {
+			return filter, errors.New("Invalid 'to'")
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return filter, errors.New("'to' is before 'from'")
+	}
+
+	return filter, nil
+}
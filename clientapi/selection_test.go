@@ -0,0 +1,159 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"github.com/swayne275/showcal-backend-go/validation"
+	"golang.org/x/net/context"
+)
+
+func TestResolveEpisodes(t *testing.T) {
+	airDate, _ := time.Parse(time.RFC3339, "2119-09-03T02:00:00Z")
+	legacyEpisode := `{"season":3,"episode":5,"name":"Five","show_name":"Show","runtime":30,` +
+		`"air_date":"2119-09-03 02:00:00"}`
+	validEpisode := strings.Replace(legacyEpisode, `{`, `{"show_id":2550,`, 1)
+
+	var gotFilter tvshowdata.EpisodeFilter
+	oldGet := getEpisodes
+	getEpisodes = func(ctx context.Context, id int64, filter tvshowdata.EpisodeFilter) (tvshowdata.Episodes, error) {
+		gotFilter = filter
+		switch id {
+		case 404:
+			return tvshowdata.Episodes{}, nil
+		case 500:
+			return tvshowdata.Episodes{}, errors.New("api down")
+		}
+		episode := tvshowdata.Episode{Season: 3, Episode: 5, Title: "Five", ShowName: "Show",
+			AirDate: tvshowdata.Time{Time: airDate}}
+		episode.SetEpisodateIDs(id)
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{episode}}, nil
+	}
+	defer func() { getEpisodes = oldGet }()
+
+	cases := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantFilter tvshowdata.EpisodeFilter
+	}{
+		{"client episodes", `{"episodes":[` + validEpisode + `]}`, http.StatusOK,
+			tvshowdata.EpisodeFilter{}},
+		{"invalid client episodes", `{"episodes":[{"season":1,"episode":1,"name":"B"}]}`,
+			http.StatusUnprocessableEntity, tvshowdata.EpisodeFilter{}},
+		{"client episodes without show", `{"episodes":[` + legacyEpisode + `]}`,
+			http.StatusUnprocessableEntity, tvshowdata.EpisodeFilter{}},
+		{"older client naming the show once", `{"show_id":2550,"episodes":[` + legacyEpisode + `]}`,
+			http.StatusOK, tvshowdata.EpisodeFilter{IncludePast: true}},
+		{"verified client episodes", `{"show_id":2550,"episodes":[` + validEpisode + `]}`, http.StatusOK,
+			tvshowdata.EpisodeFilter{IncludePast: true}},
+		{"client episode id", `{"show_id":2550,"episodes":[` + strings.Replace(validEpisode,
+			`{`, `{"provider_episode_id":"1/S01E01",`, 1) + `]}`, http.StatusOK,
+			tvshowdata.EpisodeFilter{IncludePast: true}},
+		{"other provider", `{"show_id":2550,"episodes":[` + strings.Replace(validEpisode,
+			`{`, `{"provider":"tvmaze",`, 1) + `]}`, http.StatusUnprocessableEntity, tvshowdata.EpisodeFilter{}},
+		{"unverified client episodes", `{"show_id":2550,"episodes":[` + strings.Replace(validEpisode,
+			`"episode":5`, `"episode":6`, 1) + `]}`, http.StatusUnprocessableEntity, tvshowdata.EpisodeFilter{}},
+		{"nothing", `{}`, http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"upcoming episodes", `{"show_id":2550}`, http.StatusOK, tvshowdata.EpisodeFilter{}},
+		{"rest of season", `{"show_id":2550,"select":{"season":3,"first_episode":5}}`, http.StatusOK,
+			tvshowdata.EpisodeFilter{Season: 3, FirstEpisode: 5}},
+		{"next three", `{"show_id":2550,"select":{"next":3}}`, http.StatusOK,
+			tvshowdata.EpisodeFilter{Limit: 3}},
+		{"past season", `{"show_id":2550,"select":{"season":1,"include_past":true}}`, http.StatusOK,
+			tvshowdata.EpisodeFilter{Season: 1, IncludePast: true}},
+		{"select with episodes", `{"show_id":2550,"select":{"next":1},"episodes":[` + validEpisode + `]}`,
+			http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"range without season", `{"show_id":2550,"select":{"first_episode":2}}`,
+			http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"specials", `{"show_id":2550,"select":{"specials":true,"last_episode":2}}`, http.StatusOK,
+			tvshowdata.EpisodeFilter{SpecialsOnly: true, LastEpisode: 2}},
+		{"specials with season", `{"show_id":2550,"select":{"specials":true,"season":3}}`,
+			http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"backwards range", `{"show_id":2550,"select":{"season":1,"first_episode":4,"last_episode":2}}`,
+			http.StatusBadRequest, tvshowdata.EpisodeFilter{}},
+		{"bad window", `{"show_id":2550,"select":{"from":"soon"}}`, http.StatusBadRequest,
+			tvshowdata.EpisodeFilter{}},
+		{"negative next", `{"show_id":2550,"select":{"next":-1}}`, http.StatusBadRequest,
+			tvshowdata.EpisodeFilter{}},
+		{"nothing selected", `{"show_id":404}`, http.StatusNotFound, tvshowdata.EpisodeFilter{}},
+		{"api error", `{"show_id":500}`, http.StatusBadGateway, tvshowdata.EpisodeFilter{}},
+	}
+
+	for _, c := range cases {
+		gotFilter = tvshowdata.EpisodeFilter{}
+		var request createEventRequest
+		if err := json.Unmarshal([]byte(c.body), &request); err != nil {
+			t.Fatalf("bad test body for '%s': %v", c.name, err)
+		}
+
+		episodes, status, err := resolveEpisodes(context.Background(), request)
+		if _, isReport := err.(validation.Report); isReport != (status == http.StatusUnprocessableEntity) {
+			t.Errorf("incorrect error for '%s': expected a report with status '%d', got '%v'", c.name, status, err)
+		}
+		if status != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d' (%v)", c.name, c.wantStatus, status, err)
+			continue
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+		if len(episodes.Episodes) != 1 || gotFilter != c.wantFilter {
+			t.Errorf("incorrect output for '%s': expected filter '%+v', got '%+v' with '%v'",
+				c.name, c.wantFilter, gotFilter, episodes)
+		}
+	}
+}
+
+func TestHandleCalendarAddSelection(t *testing.T) {
+	oldGet := getEpisodes
+	getEpisodes = func(ctx context.Context, id int64, filter tvshowdata.EpisodeFilter) (tvshowdata.Episodes, error) {
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{Season: 3, Episode: 5, Title: "Five",
+			ShowName: "Show", RuntimeMinutes: 30}}}, nil
+	}
+	defer func() { getEpisodes = oldGet }()
+
+	body := `{"show_id":2550,"select":{"season":3}}`
+	w := httptest.NewRecorder()
+	handleCalendarAdd(w, httptest.NewRequest(http.MethodPost, createEventEndpoint+"?dry_run=true",
+		strings.NewReader(body)))
+
+	var got dryRunResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unable to decode response '%s': %v", w.Body.String(), err)
+	}
+	if len(got.Events) != 1 {
+		t.Errorf("incorrect preview count: expected '1', got '%d'", len(got.Events))
+	}
+}
+
+func TestHandleCalendarAddInvalid(t *testing.T) {
+	body := `{"episodes":[{"season":1,"episode":1,"name":"B","show_name":"A","show_id":7,"runtime":600,` +
+		`"air_date":"2001-01-01 00:00:00"}]}`
+	w := httptest.NewRecorder()
+	handleCalendarAdd(w, httptest.NewRequest(http.MethodPost, createEventEndpoint+"?dry_run=true",
+		strings.NewReader(body)))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("incorrect status: expected '%d', got '%d'", http.StatusUnprocessableEntity, w.Code)
+	}
+	var report validation.Report
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	cases := []string{"episodes[0].air_date", "episodes[0].runtime"}
+	if len(report.Errors) != len(cases) {
+		t.Fatalf("incorrect report: expected '%v', got '%+v'", cases, report.Errors)
+	}
+	for idx, field := range cases {
+		if report.Errors[idx].Field != field {
+			t.Errorf("incorrect field %d: expected '%s', got '%s'", idx, field, report.Errors[idx].Field)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Client API for show pages
+
+package clientapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+const (
+	// followed by the show id
+	showsEndpoint = prefix + "shows/"
+	// after the show id, for its next episode
+	nextEpisodeSuffix = "/next"
+)
+
+// calls out to tvshowdata, swapped out in tests
+var (
+	getShowDetails = tvshowdata.GetShowDetails
+	getNextEpisode = tvshowdata.GetNextEpisode
+)
+
+// nextEpisodeResponse adds when the next episode airs for the caller
+type nextEpisodeResponse struct {
+	tvshowdata.NextEpisode
+	Timezone string `json:"timezone"`
+	// the rest are only set when an episode is scheduled
+	LocalAirDate string `json:"local_air_date,omitempty"`
+	// SecondsUntilAir is 0 once the episode is airing
+	SecondsUntilAir *int64 `json:"seconds_until_air,omitempty"`
+	// DaysUntilAir counts calendar days in the timezone, 0 for today
+	DaysUntilAir *int `json:"days_until_air,omitempty"`
+}
+
+// Read the show id from a shows/{id}{suffix} path, writing an error
+// response if it is missing or invalid
+func getShowIDFromPath(w http.ResponseWriter, r *http.Request, suffix string) (int64, bool) {
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, showsEndpoint), suffix)
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid show id", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// GET returns the details for the show in the path, or its next episode
+// for shows/{id}/next
+func handleShow(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, nextEpisodeSuffix) {
+		handleNextEpisode(w, r)
+		return
+	}
+
+	id, ok := getShowIDFromPath(w, r, "")
+	if !ok {
+		return
+	}
+
+	details, err := getShowDetails(id)
+	if errors.Cause(err) == tvshowdata.ErrShowNotFound {
+		http.Error(w, "No such show", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("error in handleShow()", err)
+		http.Error(w, "Unable to get show details", http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, details, showsEndpoint)
+}
+
+// GET returns the next episode for the show in the path, with how long
+// until it airs in the IANA timezone 'tz' (UTC if not given)
+func handleNextEpisode(w http.ResponseWriter, r *http.Request) {
+	id, ok := getShowIDFromPath(w, r, nextEpisodeSuffix)
+	if !ok {
+		return
+	}
+
+	loc := time.UTC
+	if tz, err := getQueryParam("tz", r); err == nil {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			http.Error(w, "Invalid value for param 'tz'", http.StatusBadRequest)
+			return
+		}
+	}
+
+	next, err := getNextEpisode(id)
+	if errors.Cause(err) == tvshowdata.ErrShowNotFound {
+		http.Error(w, "No such show", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		fmt.Println("error in handleNextEpisode()", err)
+		http.Error(w, "Unable to get next episode", http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, newNextEpisodeResponse(next, loc, time.Now()), showsEndpoint)
+}
+
+// Work out when next airs, as of now in loc
+func newNextEpisodeResponse(next tvshowdata.NextEpisode, loc *time.Location, now time.Time) nextEpisodeResponse {
+	response := nextEpisodeResponse{NextEpisode: next, Timezone: loc.String()}
+	if next.Episode == nil {
+		return response
+	}
+
+	airDate := next.Episode.AirDate.In(loc)
+	if next.Episode.DateOnly() {
+		// only the day is known, and it's the same day everywhere, so count
+		// from its start in loc rather than shifting UTC midnight
+		day := next.Episode.AirDate.UTC()
+		airDate = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	}
+	response.LocalAirDate = airDate.Format(time.RFC3339)
+
+	seconds := int64(airDate.Sub(now) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	response.SecondsUntilAir = &seconds
+
+	// compare dates at UTC midnight so DST changes don't skew the count
+	local := now.In(loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+	airDay := time.Date(airDate.Year(), airDate.Month(), airDate.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(airDay.Sub(today).Hours() / 24)
+	if days < 0 {
+		days = 0
+	}
+	response.DaysUntilAir = &days
+
+	return response
+}
@@ -0,0 +1,176 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+func TestHandleShow(t *testing.T) {
+	oldGet := getShowDetails
+	getShowDetails = func(id int64) (tvshowdata.ShowDetails, error) {
+		switch id {
+		case 404:
+			return tvshowdata.ShowDetails{}, errors.Wrap(tvshowdata.ErrShowNotFound, "test")
+		case 500:
+			return tvshowdata.ShowDetails{}, errors.New("api down")
+		}
+		return tvshowdata.ShowDetails{ID: id, Name: "Show", Genres: []string{"Drama"}}, nil
+	}
+	defer func() { getShowDetails = oldGet }()
+
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"details", http.MethodGet, showsEndpoint + "2550", http.StatusOK},
+		{"no id", http.MethodGet, showsEndpoint, http.StatusBadRequest},
+		{"bad id", http.MethodGet, showsEndpoint + "abc", http.StatusBadRequest},
+		{"negative id", http.MethodGet, showsEndpoint + "-1", http.StatusBadRequest},
+		{"unknown show", http.MethodGet, showsEndpoint + "404", http.StatusNotFound},
+		{"api error", http.MethodGet, showsEndpoint + "500", http.StatusBadGateway},
+		{"POST", http.MethodPost, showsEndpoint + "2550", http.StatusMethodNotAllowed},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handleShow(w, httptest.NewRequest(c.method, c.path, nil))
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'", c.name, c.wantStatus, w.Code)
+			continue
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got struct {
+			ID     int64    `json:"id"`
+			Name   string   `json:"name"`
+			Genres []string `json:"genres"`
+			Status bool     `json:"status"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != 2550 || got.Name != "Show" || len(got.Genres) != 1 || got.Status {
+			t.Errorf("incorrect output for '%s': got '%+v'", c.name, got)
+		}
+	}
+}
+
+func TestHandleNextEpisode(t *testing.T) {
+	airDate := time.Now().Add(49 * time.Hour).Truncate(time.Second)
+	oldGet := getNextEpisode
+	getNextEpisode = func(id int64) (tvshowdata.NextEpisode, error) {
+		switch id {
+		case 404:
+			return tvshowdata.NextEpisode{}, errors.Wrap(tvshowdata.ErrShowNotFound, "test")
+		case 500:
+			return tvshowdata.NextEpisode{}, errors.New("api down")
+		case 3564:
+			return tvshowdata.NextEpisode{ShowID: id, ShowName: "Friends", Status: tvshowdata.ShowStatusEnded}, nil
+		}
+		episode := tvshowdata.Episode{ShowID: id, Season: 15, Episode: 21,
+			AirDate: tvshowdata.Time{Time: airDate}}
+		return tvshowdata.NextEpisode{ShowID: id, ShowName: "American Dad!",
+			Status: tvshowdata.ShowStatusRunning, Episode: &episode}, nil
+	}
+	defer func() { getNextEpisode = oldGet }()
+
+	cases := []struct {
+		name        string
+		path        string
+		wantStatus  int
+		wantTZ      string
+		wantEpisode bool
+	}{
+		{"next", showsEndpoint + "2550/next", http.StatusOK, "UTC", true},
+		{"next in timezone", showsEndpoint + "2550/next?tz=Asia/Tokyo", http.StatusOK, "Asia/Tokyo", true},
+		{"nothing scheduled", showsEndpoint + "3564/next", http.StatusOK, "UTC", false},
+		{"bad timezone", showsEndpoint + "2550/next?tz=Nowhere", http.StatusBadRequest, "", false},
+		{"bad id", showsEndpoint + "abc/next", http.StatusBadRequest, "", false},
+		{"unknown show", showsEndpoint + "404/next", http.StatusNotFound, "", false},
+		{"api error", showsEndpoint + "500/next", http.StatusBadGateway, "", false},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handleShow(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'", c.name, c.wantStatus, w.Code)
+			continue
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got struct {
+			Timezone        string    `json:"timezone"`
+			LocalAirDate    string    `json:"local_air_date"`
+			SecondsUntilAir *int64    `json:"seconds_until_air"`
+			DaysUntilAir    *int      `json:"days_until_air"`
+			Episode         *struct{} `json:"episode"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Timezone != c.wantTZ || (got.Episode != nil) != c.wantEpisode ||
+			(got.SecondsUntilAir != nil) != c.wantEpisode {
+			t.Errorf("incorrect output for '%s': got '%s'", c.name, w.Body.String())
+		}
+		if c.wantEpisode {
+			local, err := time.Parse(time.RFC3339, got.LocalAirDate)
+			if err != nil || !local.Equal(airDate) {
+				t.Errorf("incorrect local air date for '%s': got '%s'", c.name, got.LocalAirDate)
+			}
+		}
+	}
+}
+
+func TestNewNextEpisodeResponse(t *testing.T) {
+	// 23:00 on the 1st in UTC, already the 2nd in Tokyo
+	now := time.Date(2019, 9, 1, 23, 0, 0, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	newYork := time.FixedZone("EDT", -4*60*60)
+	thirdUTC := time.Date(2019, 9, 3, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name        string
+		airDate     time.Time
+		precision   tvshowdata.AirDatePrecision
+		loc         *time.Location
+		wantSeconds int64
+		wantDays    int
+	}{
+		{"later today", now.Add(30 * time.Minute), tvshowdata.PrecisionTime, time.UTC, 1800, 0},
+		{"tomorrow", now.Add(2 * time.Hour), tvshowdata.PrecisionTime, time.UTC, 7200, 1},
+		{"still today in tokyo", now.Add(2 * time.Hour), tvshowdata.PrecisionTime, tokyo, 7200, 0},
+		{"airing", now.Add(-time.Minute), tvshowdata.PrecisionTime, time.UTC, 0, 0},
+		{"next week", now.Add(7 * 24 * time.Hour), tvshowdata.PrecisionTime, tokyo, 7 * 24 * 3600, 7},
+		// the 3rd is the 3rd everywhere, even where UTC midnight is the 2nd
+		{"date only", thirdUTC, tvshowdata.PrecisionDate, time.UTC, 25 * 3600, 2},
+		{"date only in new york", thirdUTC, tvshowdata.PrecisionDate, newYork, 29 * 3600, 2},
+		{"date only in tokyo", thirdUTC, tvshowdata.PrecisionDate, tokyo, 16 * 3600, 1},
+	}
+
+	for _, c := range cases {
+		episode := tvshowdata.Episode{AirDate: tvshowdata.Time{Time: c.airDate},
+			AirDatePrecision: c.precision}
+		got := newNextEpisodeResponse(tvshowdata.NextEpisode{Episode: &episode}, c.loc, now)
+
+		if *got.SecondsUntilAir != c.wantSeconds || *got.DaysUntilAir != c.wantDays {
+			t.Errorf("incorrect output for '%s': expected '%d %d', got '%d %d'", c.name,
+				c.wantSeconds, c.wantDays, *got.SecondsUntilAir, *got.DaysUntilAir)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Client API for following shows into a calendar
+
+package clientapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+)
+
+const (
+	subscribeEndpoint     = prefix + "subscribe"
+	unsubscribeEndpoint   = prefix + "unsubscribe"
+	subscriptionsEndpoint = prefix + "subscriptions"
+)
+
+// startSync fills the calendar for a new subscription without waiting for
+// the scheduled sync, swapped out in tests
+var startSync = func(sub subscriptions.Subscription) {
+	if _, err := subscriptions.EnqueueSync(sub); err != nil {
+		fmt.Println("error queueing sync for new subscription", err)
+	}
+}
+
+type subscriptionsResponse struct {
+	Subscriptions []subscriptions.Subscription `json:"subscriptions"`
+}
+
+// Read the logged in user, show id and calendar for a subscription request,
+// writing an error response if any are missing
+func getSubscriptionParams(w http.ResponseWriter, r *http.Request) (string, int64, string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", 0, "", false
+	}
+
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if !loggedIn {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return "", 0, "", false
+	}
+
+	idStr, err := getQueryParam("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", 0, "", false
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid value for param 'id'", http.StatusBadRequest)
+		return "", 0, "", false
+	}
+
+	// default to google calendar like createevent
+	provider, err := getQueryParam("calendar", r)
+	if err != nil {
+		provider = gcalwrapper.ProviderGoogle
+	}
+
+	return userID, id, provider, true
+}
+
+// POST follows show 'id' into the user's 'calendar', syncing right away
+func handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, id, provider, ok := getSubscriptionParams(w, r)
+	if !ok {
+		return
+	}
+	hasToken, err := gcalwrapper.HasToken(userID, provider)
+	if err != nil {
+		fmt.Println("error in handleSubscribe()", err)
+		http.Error(w, "Unable to check login", http.StatusInternalServerError)
+		return
+	}
+	if !hasToken {
+		http.Error(w, fmt.Sprintf("No %s login for user", provider), http.StatusUnauthorized)
+		return
+	}
+
+	sub, err := subscriptions.Subscribe(userID, id, provider)
+	if err != nil {
+		fmt.Println("error in handleSubscribe()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	startSync(sub)
+
+	writeJSON(w, sub, subscribeEndpoint)
+}
+
+// POST stops syncing show 'id' to the user's 'calendar'
+func handleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, id, provider, ok := getSubscriptionParams(w, r)
+	if !ok {
+		return
+	}
+
+	removed, err := subscriptions.Unsubscribe(userID, id, provider)
+	if err != nil {
+		fmt.Println("error in handleUnsubscribe()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Not subscribed to that show", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET lists the shows the user follows
+func handleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if !loggedIn {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	subs, err := subscriptions.UserSubscriptions(userID)
+	if err != nil {
+		fmt.Println("error in handleSubscriptions()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if subs == nil {
+		subs = []subscriptions.Subscription{}
+	}
+
+	writeJSON(w, subscriptionsResponse{Subscriptions: subs}, subscriptionsEndpoint)
+}
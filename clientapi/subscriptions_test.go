@@ -0,0 +1,88 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+	"golang.org/x/oauth2"
+)
+
+// googleTokens has a google login for every user
+type googleTokens struct{}
+
+func (googleTokens) GetToken(userID, provider string) (oauth2.Token, bool, error) {
+	return oauth2.Token{AccessToken: "x"}, provider == gcalwrapper.ProviderGoogle, nil
+}
+
+func (googleTokens) SetToken(userID, provider string, token oauth2.Token) error {
+	return nil
+}
+
+func TestHandleSubscriptions(t *testing.T) {
+	gcalwrapper.SetTokenStore(googleTokens{})
+	var synced []int64
+	oldSync := startSync
+	startSync = func(sub subscriptions.Subscription) { synced = append(synced, sub.ShowID) }
+	defer func() { startSync = oldSync }()
+
+	cases := []struct {
+		name       string
+		method     string
+		endpoint   string
+		loggedIn   bool
+		wantStatus int
+		wantCount  int
+	}{
+		{"list needs login", http.MethodGet, subscriptionsEndpoint, false, http.StatusUnauthorized, 0},
+		{"empty list", http.MethodGet, subscriptionsEndpoint, true, http.StatusOK, 0},
+		{"subscribe needs login", http.MethodPost, subscribeEndpoint + "?id=7", false, http.StatusUnauthorized, 0},
+		{"subscribe needs id", http.MethodPost, subscribeEndpoint, true, http.StatusBadRequest, 0},
+		{"subscribe with GET", http.MethodGet, subscribeEndpoint + "?id=7", true, http.StatusMethodNotAllowed, 0},
+		{"no outlook login", http.MethodPost, subscribeEndpoint + "?id=7&calendar=outlook", true, http.StatusUnauthorized, 0},
+		{"subscribe", http.MethodPost, subscribeEndpoint + "?id=7", true, http.StatusOK, 0},
+		{"list subscription", http.MethodGet, subscriptionsEndpoint, true, http.StatusOK, 1},
+		{"unsubscribe", http.MethodPost, unsubscribeEndpoint + "?id=7", true, http.StatusNoContent, 0},
+		{"unsubscribe again", http.MethodPost, unsubscribeEndpoint + "?id=7", true, http.StatusNotFound, 0},
+		{"list after unsubscribe", http.MethodGet, subscriptionsEndpoint, true, http.StatusOK, 0},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.endpoint, nil)
+		if c.loggedIn {
+			loginAs(t, r, "subscriptions-user")
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(map[string]http.HandlerFunc{
+			subscriptionsEndpoint: handleSubscriptions,
+			subscribeEndpoint:     handleSubscribe,
+			unsubscribeEndpoint:   handleUnsubscribe,
+		}[r.URL.Path]).ServeHTTP(w, r)
+		resp := w.Result()
+
+		if resp.StatusCode != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'",
+				c.name, c.wantStatus, resp.StatusCode)
+			continue
+		}
+		if r.URL.Path != subscriptionsEndpoint || c.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got subscriptionsResponse
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Subscriptions) != c.wantCount {
+			t.Errorf("incorrect number of subscriptions for '%s': expected '%d', got '%d'",
+				c.name, c.wantCount, len(got.Subscriptions))
+		}
+	}
+
+	if len(synced) != 1 || synced[0] != 7 {
+		t.Errorf("expected one sync for show 7, got '%v'", synced)
+	}
+}
@@ -0,0 +1,122 @@
+// Client API for event summary/description templates
+
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+const (
+	templatesEndpoint        = prefix + "templates"
+	previewTemplatesEndpoint = prefix + "previewtemplates"
+)
+
+// templatesResponse describes the templates in use for a user
+type templatesResponse struct {
+	Defaults  gcalwrapper.EventTemplates `json:"defaults"`
+	Overrides gcalwrapper.EventTemplates `json:"overrides"`
+	Effective gcalwrapper.EventTemplates `json:"effective"`
+}
+
+// previewTemplatesRequest renders templates for episode (or a sample)
+type previewTemplatesRequest struct {
+	Templates gcalwrapper.EventTemplates `json:"templates"`
+	Episode   *tvshowdata.Episode        `json:"episode"`
+}
+
+type previewTemplatesResponse struct {
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+}
+
+// GET returns the user's templates, POST validates and saves overrides
+// (empty fields fall back to the server-wide default)
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if r.Method == http.MethodPost {
+		if !loggedIn {
+			http.Error(w, "Not logged in", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := getRequestBody(*r)
+		if err != nil {
+			fmt.Println(err)
+			http.Error(w, "Invalid templates", http.StatusBadRequest)
+			return
+		}
+
+		var templates gcalwrapper.EventTemplates
+		if err := json.Unmarshal(body, &templates); err != nil {
+			fmt.Println(err)
+			http.Error(w, "Invalid 'templates' data", http.StatusBadRequest)
+			return
+		}
+
+		if templates == (gcalwrapper.EventTemplates{}) {
+			err = gcalwrapper.ResetUserTemplates(userID)
+		} else {
+			err = gcalwrapper.SaveUserTemplates(userID, templates)
+		}
+		if err != nil {
+			fmt.Println("error in handleTemplates()", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	response := templatesResponse{Defaults: gcalwrapper.DefaultTemplates()}
+	response.Effective = response.Defaults
+	if loggedIn {
+		overrides, effective, err := gcalwrapper.UserTemplates(userID)
+		if err != nil {
+			fmt.Println("error in handleTemplates()", err)
+			http.Error(w, "Unable to load templates", http.StatusInternalServerError)
+			return
+		}
+		response.Overrides, response.Effective = overrides, effective
+	}
+
+	writeJSON(w, response, templatesEndpoint)
+}
+
+func handlePreviewTemplates(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	body, err := getRequestBody(*r)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Invalid templates", http.StatusBadRequest)
+		return
+	}
+
+	var req previewTemplatesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Println(err)
+		http.Error(w, "Invalid 'templates' data", http.StatusBadRequest)
+		return
+	}
+
+	summary, description, err := gcalwrapper.PreviewTemplates(req.Templates, req.Episode)
+	if err != nil {
+		fmt.Println("error in handlePreviewTemplates()", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, previewTemplatesResponse{Summary: summary, Description: description},
+		previewTemplatesEndpoint)
+}
@@ -0,0 +1,55 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleTemplates(t *testing.T) {
+	cases := []struct {
+		name        string
+		method      string
+		body        string
+		loggedIn    bool
+		wantStatus  int
+		wantSummary string
+	}{
+		{"defaults for anonymous", http.MethodGet, "", false, http.StatusOK, `{{.ShowName}}: "{{.Title}}"`},
+		{"save needs login", http.MethodPost, `{"summary":"{{.SxxEyy}}"}`, false, http.StatusUnauthorized, ""},
+		{"invalid template", http.MethodPost, `{"summary":"{{.Nope}}"}`, true, http.StatusBadRequest, ""},
+		{"save override", http.MethodPost, `{"summary":"{{.SxxEyy}}"}`, true, http.StatusOK, "{{.SxxEyy}}"},
+		{"read override", http.MethodGet, "", true, http.StatusOK, "{{.SxxEyy}}"},
+		{"reset override", http.MethodPost, `{}`, true, http.StatusOK, `{{.ShowName}}: "{{.Title}}"`},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, templatesEndpoint, strings.NewReader(c.body))
+		if c.loggedIn {
+			loginAs(t, r, "templates-user")
+		}
+		w := httptest.NewRecorder()
+		handleTemplates(w, r)
+		resp := w.Result()
+
+		if resp.StatusCode != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'",
+				c.name, c.wantStatus, resp.StatusCode)
+			continue
+		}
+		if c.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var got templatesResponse
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Effective.Summary != c.wantSummary {
+			t.Errorf("incorrect summary for '%s': expected '%s', got '%s'",
+				c.name, c.wantSummary, got.Effective.Summary)
+		}
+	}
+}
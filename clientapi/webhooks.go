@@ -0,0 +1,191 @@
+// Client API for registering webhooks and inspecting their deliveries
+
+package clientapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/webhooks"
+)
+
+const (
+	webhooksEndpoint          = prefix + "webhooks"
+	deleteWebhookEndpoint     = prefix + "webhooks/delete"
+	webhookDeliveriesEndpoint = prefix + "webhooks/deliveries"
+	pingWebhookEndpoint       = prefix + "webhooks/ping"
+)
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type webhooksResponse struct {
+	Webhooks []webhooks.Webhook `json:"webhooks"`
+}
+
+type deliveriesResponse struct {
+	Deliveries []webhooks.Delivery `json:"deliveries"`
+}
+
+// the logged in user, writing an error response if there isn't one
+func requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, loggedIn := gcalwrapper.UserFromRequest(r)
+	if !loggedIn {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	}
+
+	return userID, loggedIn
+}
+
+// GET lists the user's webhooks, POST registers a new one and returns it
+// with the secret used to sign its payloads
+func handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		list, err := webhooks.UserWebhooks(userID)
+		if err != nil {
+			fmt.Println("error in handleWebhooks()", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, webhooksResponse{Webhooks: list}, webhooksEndpoint)
+		return
+	}
+
+	body, err := getRequestBody(*r)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Invalid webhook", http.StatusBadRequest)
+		return
+	}
+
+	var req webhookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Println(err)
+		http.Error(w, "Invalid 'webhook' data", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := webhooks.Register(userID, req.URL, req.Events)
+	if err != nil {
+		fmt.Println("error in handleWebhooks()", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSONStatus(w, http.StatusCreated, webhook, webhooksEndpoint)
+}
+
+// POST removes the user's webhook 'id'
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, id, ok := getWebhookParams(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	removed, err := webhooks.Remove(userID, id)
+	if err != nil {
+		fmt.Println("error in handleDeleteWebhook()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "No such webhook", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET returns the recent deliveries to the user's webhook 'id'
+func handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, id, ok := getWebhookParams(w, r, http.MethodGet)
+	if !ok {
+		return
+	}
+
+	deliveries, found, err := webhooks.Deliveries(userID, id)
+	if err != nil {
+		fmt.Println("error in handleWebhookDeliveries()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No such webhook", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, deliveriesResponse{Deliveries: deliveries}, webhookDeliveriesEndpoint)
+}
+
+// POST sends a test ping to the user's webhook 'id', returning the delivery
+func handlePingWebhook(w http.ResponseWriter, r *http.Request) {
+	setupCors(w)
+	if (*r).Method == "OPTIONS" {
+		return
+	}
+
+	userID, id, ok := getWebhookParams(w, r, http.MethodPost)
+	if !ok {
+		return
+	}
+
+	delivery, found, err := webhooks.SendPing(userID, id)
+	if err != nil {
+		fmt.Println("error in handlePingWebhook()", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "No such webhook", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, delivery, pingWebhookEndpoint)
+}
+
+// Read the logged in user and webhook id for a request that must use
+// method, writing an error response if either is missing
+func getWebhookParams(w http.ResponseWriter, r *http.Request, method string) (string, string, bool) {
+	if r.Method != method {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", "", false
+	}
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return "", "", false
+	}
+
+	id, err := getQueryParam("id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", "", false
+	}
+
+	return userID, id, true
+}
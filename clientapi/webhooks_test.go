@@ -0,0 +1,94 @@
+package clientapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/swayne275/showcal-backend-go/webhooks"
+)
+
+func TestHandleWebhooks(t *testing.T) {
+	// local receivers are refused, and .invalid never resolves, so pings are
+	// logged as failed without leaving the machine
+	r := httptest.NewRequest(http.MethodPost, webhooksEndpoint,
+		strings.NewReader(`{"url":"https://receiver.invalid/hook"}`))
+	loginAs(t, r, "webhooks-user")
+	w := httptest.NewRecorder()
+	handleWebhooks(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("incorrect status for register: expected '%d', got '%d'", http.StatusCreated, w.Code)
+	}
+	var created webhooks.Webhook
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Secret == "" {
+		t.Errorf("expected secret when registering")
+	}
+
+	handlers := map[string]http.HandlerFunc{
+		webhooksEndpoint:          handleWebhooks,
+		deleteWebhookEndpoint:     handleDeleteWebhook,
+		webhookDeliveriesEndpoint: handleWebhookDeliveries,
+		pingWebhookEndpoint:       handlePingWebhook,
+	}
+	cases := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		user       string
+		wantStatus int
+	}{
+		{"list needs login", http.MethodGet, webhooksEndpoint, "", "", http.StatusUnauthorized},
+		{"bad url", http.MethodPost, webhooksEndpoint, `{"url":"nope"}`, "webhooks-user", http.StatusBadRequest},
+		{"local url", http.MethodPost, webhooksEndpoint, `{"url":"http://127.0.0.1:8080/"}`, "webhooks-user", http.StatusBadRequest},
+		{"list", http.MethodGet, webhooksEndpoint, "", "webhooks-user", http.StatusOK},
+		{"ping", http.MethodPost, pingWebhookEndpoint + "?id=" + created.ID, "", "webhooks-user", http.StatusOK},
+		{"ping other user's", http.MethodPost, pingWebhookEndpoint + "?id=" + created.ID, "", "someone-else", http.StatusNotFound},
+		{"deliveries", http.MethodGet, webhookDeliveriesEndpoint + "?id=" + created.ID, "", "webhooks-user", http.StatusOK},
+		{"delete with GET", http.MethodGet, deleteWebhookEndpoint + "?id=" + created.ID, "", "webhooks-user", http.StatusMethodNotAllowed},
+		{"delete", http.MethodPost, deleteWebhookEndpoint + "?id=" + created.ID, "", "webhooks-user", http.StatusNoContent},
+		{"delete again", http.MethodPost, deleteWebhookEndpoint + "?id=" + created.ID, "", "webhooks-user", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		if c.user != "" {
+			loginAs(t, r, c.user)
+		}
+		w := httptest.NewRecorder()
+		handlers[r.URL.Path](w, r)
+
+		if w.Code != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%d', got '%d'",
+				c.name, c.wantStatus, w.Code)
+			continue
+		}
+
+		switch c.name {
+		case "list":
+			var got webhooksResponse
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Webhooks) != 1 || got.Webhooks[0].Secret != "" {
+				t.Errorf("expected one webhook without its secret, got '%+v'", got.Webhooks)
+			}
+		case "ping":
+			var got webhooks.Delivery
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			// the receiver doesn't resolve, and successful deliveries are
+			// covered in the webhooks package
+			if got.Status != webhooks.DeliveryFailed || got.Attempts != 1 {
+				t.Errorf("incorrect ping status: expected '%s', got '%s'",
+					webhooks.DeliveryFailed, got.Status)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Adds episodes to a calendar as a background job, so large batches don't
+// hold a request open
+
+package gcalwrapper
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/swayne275/gerrors"
+	"github.com/swayne275/showcal-backend-go/jobs"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/net/context"
+)
+
+// AddEventsJob is the job type that adds episodes to a calendar
+const AddEventsJob = "calendar.add_events"
+
+// addEventsPayload is the work for an AddEventsJob
+type addEventsPayload struct {
+	UserID   string              `json:"user_id"`
+	Provider string              `json:"calendar"`
+	Episodes tvshowdata.Episodes `json:"episodes"`
+}
+
+// AddJobStatus reports the progress of an AddEventsJob
+type AddJobStatus struct {
+	ID string `json:"id"`
+	// Status is the job status, see jobs.StatusQueued etc
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Total   int    `json:"total"`
+	Pending int    `json:"pending"`
+	AddResult
+}
+
+// RegisterJobs sets up the background jobs that write to calendars
+func RegisterJobs() {
+	jobs.Register(AddEventsJob, runAddEventsJob)
+}
+
+// runAddEventsJob writes the episodes in an AddEventsJob
+func runAddEventsJob(ctx context.Context, job jobs.Job) error {
+	var payload addEventsPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return gerrors.Wrapf(err, "Invalid add events payload")
+	}
+
+	progress := func(result AddResult) {
+		if err := jobs.SetProgress(job.ID, result); err != nil {
+			fmt.Println("error saving add events progress", err)
+		}
+	}
+
+	// events that fail are reported in the progress, and were already
+	// retried, so only a failure to start is worth retrying the job for
+	result, err := AddEpisodesToCalendarWithProgress(ctx, payload.UserID, payload.Provider,
+		payload.Episodes, progress)
+	if err != nil {
+		return err
+	}
+	// unless the job was stopped, e.g. on shutdown, before every event was
+	// written. Running it again updates the events already written
+	if ctx.Err() != nil && result.Failed > 0 {
+		return gerrors.Wrapf(ctx.Err(), "Stopped before all events were written")
+	}
+
+	return nil
+}
+
+// EnqueueAddEpisodes queues adding episodes to the user's calendar with
+// provider, returning the job to poll with GetAddJobStatus
+func EnqueueAddEpisodes(userID, provider string, episodes tvshowdata.Episodes) (jobs.Job, error) {
+	if _, ok := sinks[provider]; !ok {
+		return jobs.Job{}, gerrors.New(fmt.Sprintf("Unknown calendar provider '%s'", provider))
+	}
+	if err := requireLogin(userID, provider); err != nil {
+		return jobs.Job{}, err
+	}
+
+	payload := addEventsPayload{UserID: userID, Provider: provider, Episodes: episodes}
+	job, err := jobs.Enqueue(AddEventsJob, payload)
+	if err != nil {
+		return jobs.Job{}, gerrors.Wrapf(err, "Error in EnqueueAddEpisodes()")
+	}
+
+	return job, nil
+}
+
+// GetAddJobStatus reports the progress of the user's AddEventsJob with id.
+// Reports false for jobs that don't exist or belong to someone else
+func GetAddJobStatus(userID, id string) (AddJobStatus, bool, error) {
+	job, ok, err := jobs.Get(id)
+	if err != nil {
+		return AddJobStatus{}, false, gerrors.Wrapf(err, "Error in GetAddJobStatus()")
+	}
+	if !ok || job.Type != AddEventsJob {
+		return AddJobStatus{}, false, nil
+	}
+
+	var payload addEventsPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return AddJobStatus{}, false, gerrors.Wrapf(err, "Invalid add events payload")
+	}
+	if payload.UserID != userID {
+		return AddJobStatus{}, false, nil
+	}
+
+	status := AddJobStatus{ID: job.ID, Status: job.Status, Error: job.LastError}
+	if len(job.Progress) == 0 {
+		// not planned yet, so every episode is waiting
+		status.Total = len(payload.Episodes.Episodes)
+		status.Pending = status.Total
+		status.Events = []EventResult{}
+		return status, true, nil
+	}
+
+	if err := json.Unmarshal(job.Progress, &status.AddResult); err != nil {
+		return AddJobStatus{}, false, gerrors.Wrapf(err, "Invalid add events progress")
+	}
+	status.Total = len(status.Events)
+	for _, event := range status.Events {
+		if event.Status == EventPending {
+			status.Pending++
+		}
+	}
+
+	return status, true, nil
+}
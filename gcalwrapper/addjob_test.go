@@ -0,0 +1,186 @@
+package gcalwrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/jobs"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// fakeSink saves every event except those with a key in fail, until ctx is
+// done
+type fakeSink struct {
+	mu    sync.Mutex
+	calls int
+	added []string
+	fail  map[string]bool
+}
+
+func (f *fakeSink) AddEvents(ctx context.Context, userID string, events []BasicEvent) AddResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	result := AddResult{}
+	for _, event := range events {
+		if ctx.Err() != nil {
+			result.add(event, EventFailed, ctx.Err())
+			continue
+		}
+		f.added = append(f.added, event.Key)
+		if f.fail[event.Key] {
+			result.add(event, EventFailed, fmt.Errorf("no room"))
+			continue
+		}
+		result.add(event, EventCreated, nil)
+	}
+
+	return result
+}
+
+func (f *fakeSink) DeleteEvents(ctx context.Context, userID string, keys []string) AddResult {
+	return AddResult{}
+}
+
+// register fake as the "fake" provider, with a login for u1
+func useFakeSink(t *testing.T, fake *fakeSink) {
+	oldTokens, oldEvents := tokens, syncedEvents
+	tokens, syncedEvents = newMemoryTokenStore(), newMemoryEventStore()
+	sinks["fake"] = fake
+	t.Cleanup(func() {
+		tokens, syncedEvents = oldTokens, oldEvents
+		delete(sinks, "fake")
+	})
+
+	if err := tokens.SetToken("u1", "fake", oauth2.Token{AccessToken: "x"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// futureEpisodes returns count distinct episodes, plus one that already aired
+func futureEpisodes(count int) tvshowdata.Episodes {
+	airDate := time.Now().Add(24 * time.Hour)
+	episodes := tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{Season: 1, Episode: 99,
+		Title: "old", ShowName: "A", RuntimeMinutes: 30,
+		AirDate: tvshowdata.Time{Time: time.Now().Add(-24 * time.Hour)}}}}
+	for i := 1; i <= count; i++ {
+		episodes.Episodes = append(episodes.Episodes, tvshowdata.Episode{Season: 1,
+			Episode: int64(i), Title: "B", ShowName: "A", RuntimeMinutes: 30,
+			AirDate: tvshowdata.Time{Time: airDate}})
+	}
+
+	return episodes
+}
+
+func TestAddEpisodesToCalendarWithProgress(t *testing.T) {
+	fake := &fakeSink{fail: map[string]bool{"A/S01E60": true}}
+	useFakeSink(t, fake)
+
+	var reports []AddResult
+	result, err := AddEpisodesToCalendarWithProgress(context.Background(), "u1", "fake", futureEpisodes(60),
+		func(r AddResult) { reports = append(reports, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// planned, then one report per chunk of events written
+	wantPending := []int{60, 10, 0}
+	if len(reports) != len(wantPending) {
+		t.Fatalf("incorrect number of reports: expected '%d', got '%d'",
+			len(wantPending), len(reports))
+	}
+	for idx, report := range reports {
+		pending := 0
+		for _, event := range report.Events {
+			if event.Status == EventPending {
+				pending++
+			}
+		}
+		if pending != wantPending[idx] {
+			t.Errorf("incorrect pending for report %d: expected '%d', got '%d'",
+				idx, wantPending[idx], pending)
+		}
+	}
+
+	if result.Created != 59 || result.Failed != 1 || result.Skipped != 1 || fake.calls != 2 {
+		t.Errorf("incorrect result: got created %d, failed %d, skipped %d in %d calls",
+			result.Created, result.Failed, result.Skipped, fake.calls)
+	}
+	if result.Events[0].Status != EventSkipped || result.Events[60].Status != EventFailed {
+		t.Errorf("expected events in episode order, got '%+v' and '%+v'",
+			result.Events[0], result.Events[60])
+	}
+}
+
+func TestAddEventsJob(t *testing.T) {
+	useFakeSink(t, &fakeSink{})
+	RegisterJobs()
+
+	if _, err := EnqueueAddEpisodes("u2", "fake", futureEpisodes(1)); err == nil {
+		t.Errorf("expected error queueing for a user without a login")
+	}
+
+	job, err := EnqueueAddEpisodes("u1", "fake", futureEpisodes(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued, ok, err := GetAddJobStatus("u1", job.ID)
+	if err != nil || !ok {
+		t.Fatalf("expected status for queued job, got '%t' (%v)", ok, err)
+	}
+	if queued.Status != jobs.StatusQueued || queued.Total != 4 || queued.Pending != 4 {
+		t.Errorf("incorrect queued status: got '%+v'", queued)
+	}
+	if _, ok, _ := GetAddJobStatus("u2", job.ID); ok {
+		t.Errorf("did not expect another user to see the job")
+	}
+
+	stop, err := jobs.Start(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _, _ := GetAddJobStatus("u1", job.ID)
+		if status.Status == jobs.StatusSucceeded {
+			if status.Created != 3 || status.Skipped != 1 || status.Pending != 0 {
+				t.Errorf("incorrect finished status: got '%+v'", status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never finished, got '%+v'", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAddEventsJobStopped(t *testing.T) {
+	useFakeSink(t, &fakeSink{})
+	payload, err := json.Marshal(addEventsPayload{UserID: "u1", Provider: "fake",
+		Episodes: futureEpisodes(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := jobs.Job{ID: "stopped", Type: AddEventsJob, Payload: payload}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// so the job is queued again, rather than succeeding with events unwritten
+	if err := runAddEventsJob(ctx, job); err == nil {
+		t.Errorf("expected an error for a stopped job")
+	}
+
+	if err := runAddEventsJob(context.Background(), job); err != nil {
+		t.Errorf("unexpected error for a finished job: %v", err)
+	}
+}
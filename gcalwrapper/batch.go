@@ -0,0 +1,363 @@
+// Google Calendar HTTP batch requests (multipart/mixed) for bulk writes
+
+package gcalwrapper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/swayne275/gerrors"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Google caps calendar batch requests at 50 calls
+	maxBatchSize = 50
+
+	// events collection of the user's primary calendar
+	primaryEventsPath = "/calendar/v3/calendars/primary/events"
+)
+
+// calendar batch endpoint, swapped out in tests
+var googleBatchURL = "https://www.googleapis.com/batch/calendar/v3"
+
+// batchOp is a single insert or update inside a batch request
+type batchOp struct {
+	source BasicEvent
+	// existing event to update, empty to insert a new one
+	eventID string
+	event   calendar.Event
+	lastErr error
+}
+
+// batchResult is the outcome of a single batchOp
+type batchResult struct {
+	event calendar.Event
+	err   error
+	// the item failed in a way that may succeed if sent again
+	retry bool
+	// the event to update no longer exists
+	stale bool
+	// the provider applied the call, though its response couldn't be read
+	written bool
+}
+
+// addEventsBatch saves events to the user's primary calendar in batches of
+// up to maxBatchSize, retrying only the items that failed with backoff
+func addEventsBatch(ctx context.Context, client *http.Client, userID string,
+	events []BasicEvent) AddResult {
+	result := AddResult{}
+
+	pending := make([]batchOp, 0, len(events))
+	for _, event := range events {
+		gcalEvent, err := buildCalendarEvent(event)
+		if err != nil {
+			result.add(event, EventFailed, gerrors.Wrapf(err, "Error in addEventsBatch()"))
+			continue
+		}
+
+		eventID, _, err := getSyncedEventID(userID, ProviderGoogle, event.Key)
+		if err != nil {
+			result.add(event, EventFailed, gerrors.Wrapf(err, "Error in addEventsBatch()"))
+			continue
+		}
+		pending = append(pending, batchOp{source: event, eventID: eventID, event: gcalEvent})
+	}
+
+	for attempt := 1; len(pending) > 0; attempt++ {
+		var failed []batchOp
+
+		for start := 0; start < len(pending); start += maxBatchSize {
+			end := start + maxBatchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			chunk := pending[start:end]
+
+			if err := waitForQuota(ctx, userID, len(chunk)); err != nil {
+				for _, op := range chunk {
+					result.add(op.source, EventFailed, err)
+				}
+				continue
+			}
+
+			results, err := doBatch(ctx, client, chunk)
+			if err != nil {
+				if _, isAPIError := err.(*googleapi.Error); isAPIError && !isRetryableError(err) {
+					for _, op := range chunk {
+						result.add(op.source, EventFailed, err)
+					}
+					continue
+				}
+				if isRateLimitError(err) {
+					result.Throttled += len(chunk)
+				}
+
+				// nothing in the chunk was applied, so send all of it again
+				for _, op := range chunk {
+					op.lastErr = err
+					failed = append(failed, op)
+				}
+				continue
+			}
+
+			for idx, itemResult := range results {
+				op := chunk[idx]
+				switch {
+				case itemResult.err == nil:
+					status := EventCreated
+					if op.eventID != "" {
+						status = EventUpdated
+					}
+					setSyncedEventID(userID, ProviderGoogle, op.source.Key, itemResult.event.Id)
+					result.add(op.source, status, nil)
+				case itemResult.written && op.eventID != "":
+					// the update went through, and the event kept its ID
+					result.add(op.source, EventUpdated, nil)
+				case itemResult.written:
+					// created, but with no ID to save, so sending it again
+					// would only add a duplicate
+					result.add(op.source, EventFailed, itemResult.err)
+				case itemResult.stale:
+					// the user deleted the old event, so insert a new one
+					deleteSyncedEventID(userID, ProviderGoogle, op.source.Key)
+					op.eventID = ""
+					op.lastErr = itemResult.err
+					failed = append(failed, op)
+				case itemResult.retry:
+					if isRateLimitError(itemResult.err) {
+						result.Throttled++
+					}
+					op.lastErr = itemResult.err
+					failed = append(failed, op)
+				default:
+					result.add(op.source, EventFailed, itemResult.err)
+				}
+			}
+		}
+
+		if len(failed) > 0 && attempt >= maxCallAttempts {
+			for _, op := range failed {
+				err := gerrors.Wrapf(op.lastErr, "gave up after %d attempts", attempt)
+				result.add(op.source, EventFailed, err)
+			}
+			break
+		}
+		if len(failed) > 0 {
+			if err := sleepContext(ctx, backoffDelay(attempt)); err != nil {
+				for _, op := range failed {
+					result.add(op.source, EventFailed, gerrors.Wrapf(err, "Error in addEventsBatch()"))
+				}
+				break
+			}
+		}
+		pending = failed
+	}
+
+	return result
+}
+
+// doBatch sends ops as one multipart/mixed request and returns the result
+// for each op, in the same order
+func doBatch(ctx context.Context, client *http.Client, ops []batchOp) ([]batchResult, error) {
+	body, contentType, err := buildBatchBody(ops)
+	if err != nil {
+		return nil, gerrors.Wrapf(err, "Error in doBatch()")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, googleBatchURL, body)
+	if err != nil {
+		return nil, gerrors.Wrapf(err, "Error in doBatch()")
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, gerrors.Wrapf(err, "Error in doBatch()")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// left unwrapped so callers can check for rate limits
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return nil, parseGoogleError(resp.StatusCode, respBody)
+	}
+
+	results, err := parseBatchResponse(resp, ops)
+	if err != nil {
+		return nil, gerrors.Wrapf(err, "Error in doBatch()")
+	}
+
+	return results, nil
+}
+
+// buildBatchBody encodes each op as an application/http part
+func buildBatchBody(ops []batchOp) (*bytes.Buffer, string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for idx, op := range ops {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<item-%d>", idx))
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+
+		eventJSON, err := json.Marshal(op.event)
+		if err != nil {
+			return nil, "", err
+		}
+
+		method, path := http.MethodPost, primaryEventsPath
+		if op.eventID != "" {
+			method, path = http.MethodPut, primaryEventsPath+"/"+op.eventID
+		}
+		_, err = fmt.Fprintf(part,
+			"%s %s HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s",
+			method, path, len(eventJSON), eventJSON)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return body, "multipart/mixed; boundary=" + writer.Boundary(), nil
+}
+
+// parseBatchResponse matches each response part back to its op by
+// Content-ID. Ops without a readable response are only retried if they're
+// updates, as an insert may have been applied and sending it again would
+// duplicate it
+func parseBatchResponse(resp *http.Response, ops []batchOp) ([]batchResult, error) {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return nil, gerrors.New("batch response is not multipart")
+	}
+
+	results := make([]batchResult, len(ops))
+	seen := make([]bool, len(ops))
+
+	missingErr := gerrors.New("no response for batch item")
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// truncated or garbled, so the rest can't be read
+			missingErr = gerrors.Wrapf(err, "unreadable batch response")
+			break
+		}
+
+		idx, ok := batchItemIndex(part.Header.Get("Content-ID"))
+		if !ok || idx >= len(ops) {
+			continue
+		}
+
+		itemResp, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			results[idx] = batchResult{err: err, retry: ops[idx].eventID != ""}
+			seen[idx] = true
+			continue
+		}
+		itemBody, err := ioutil.ReadAll(itemResp.Body)
+		itemResp.Body.Close()
+		if err != nil {
+			results[idx] = batchResult{err: err, retry: ops[idx].eventID != ""}
+			seen[idx] = true
+			continue
+		}
+
+		results[idx] = parseBatchItem(itemResp.StatusCode, itemBody, ops[idx])
+		seen[idx] = true
+	}
+
+	for idx, op := range ops {
+		if !seen[idx] {
+			results[idx] = batchResult{err: missingErr, retry: op.eventID != ""}
+		}
+	}
+
+	return results, nil
+}
+
+// parseBatchItem converts a single item's HTTP response into a result
+func parseBatchItem(statusCode int, body []byte, op batchOp) batchResult {
+	if statusCode >= 200 && statusCode < 300 {
+		var event calendar.Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			err = gerrors.Wrapf(err, "event was saved, but the response couldn't be read")
+			return batchResult{err: err, written: true}
+		}
+		return batchResult{event: event}
+	}
+
+	err := parseGoogleError(statusCode, body)
+	return batchResult{
+		err:   err,
+		retry: isRetryableError(err),
+		stale: op.eventID != "" && isStaleEventError(err),
+	}
+}
+
+// get the op index from a "<response-item-N>" Content-ID
+func batchItemIndex(contentID string) (int, bool) {
+	contentID = strings.Trim(contentID, "<>")
+	idx := strings.LastIndex(contentID, "-")
+	if idx < 0 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(contentID[idx+1:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// parseGoogleError converts a non-success API response into a googleapi.Error
+func parseGoogleError(statusCode int, body []byte) error {
+	var envelope struct {
+		Error *googleapi.Error `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+		return &googleapi.Error{
+			Code:    statusCode,
+			Message: fmt.Sprintf("Got HTTP StatusCode: %d", statusCode),
+			Body:    string(body),
+		}
+	}
+
+	envelope.Error.Code = statusCode
+	envelope.Error.Body = string(body)
+	return envelope.Error
+}
+
+// isStaleEventError reports if err means the event to update is gone
+func isStaleEventError(err error) bool {
+	gErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+
+	return gErr.Code == http.StatusNotFound || gErr.Code == http.StatusGone
+}
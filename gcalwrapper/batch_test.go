@@ -0,0 +1,289 @@
+package gcalwrapper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+// batchCall is a single item seen by fakeBatch
+type batchCall struct {
+	method  string
+	path    string
+	summary string
+}
+
+// fakeBatch emulates the calendar batch endpoint. respond picks the status
+// for each item, and every batch request received is recorded
+type fakeBatch struct {
+	mu       sync.Mutex
+	requests [][]batchCall
+	respond  func(call batchCall) int
+}
+
+func (f *fakeBatch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var calls []batchCall
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		req, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var event calendar.Event
+		body, _ := ioutil.ReadAll(req.Body)
+		_ = json.Unmarshal(body, &event)
+		calls = append(calls, batchCall{method: req.Method, path: req.URL.Path,
+			summary: event.Summary})
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, calls)
+	f.mu.Unlock()
+
+	writer := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	for idx, call := range calls {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<response-item-%d>", idx))
+		part, _ := writer.CreatePart(header)
+
+		status := f.respond(call)
+		body := fmt.Sprintf(`{"error":{"code":%d,"message":"nope","errors":[{"reason":"backendError"}]}}`,
+			status)
+		if status == http.StatusOK {
+			body = fmt.Sprintf(`{"id":"id-%s","summary":"%s"}`, call.summary, call.summary)
+		}
+		fmt.Fprintf(part, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\n\r\n%s",
+			status, http.StatusText(status), body)
+	}
+	writer.Close()
+}
+
+// point batch calls at fake for the duration of a test
+func useFakeBatch(t *testing.T, fake *fakeBatch) {
+	server := httptest.NewServer(fake)
+	oldURL, oldStore := googleBatchURL, syncedEvents
+	googleBatchURL = server.URL
+	syncedEvents = newMemoryEventStore()
+	useFastBackoff(t)
+	t.Cleanup(func() {
+		googleBatchURL, syncedEvents = oldURL, oldStore
+		server.Close()
+	})
+}
+
+func makeEvents(n int) []BasicEvent {
+	events := make([]BasicEvent, n)
+	for idx := range events {
+		name := fmt.Sprintf("ep%d", idx)
+		events[idx] = BasicEvent{Key: name, Summary: name,
+			Start: time.Date(2119, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2119, 1, 1, 0, 30, 0, 0, time.UTC)}
+	}
+
+	return events
+}
+
+func TestAddEventsBatchChunksAndRetries(t *testing.T) {
+	// every fifth event fails with a 503 the first time it is seen
+	var seenMu sync.Mutex
+	seen := make(map[string]bool)
+	fake := &fakeBatch{respond: func(call batchCall) int {
+		seenMu.Lock()
+		defer seenMu.Unlock()
+		var n int
+		fmt.Sscanf(call.summary, "ep%d", &n)
+		if n%5 == 0 && !seen[call.summary] {
+			seen[call.summary] = true
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	useFakeBatch(t, fake)
+
+	result := addEventsBatch(context.Background(), http.DefaultClient, "u1", makeEvents(120))
+	if result.Created != 120 || result.Failed != 0 {
+		t.Fatalf("incorrect result: expected '120' created, got '%+v'", result)
+	}
+
+	wantSizes := []int{50, 50, 20, 24}
+	if len(fake.requests) != len(wantSizes) {
+		t.Fatalf("incorrect number of batch requests: expected '%d', got '%d'",
+			len(wantSizes), len(fake.requests))
+	}
+	for idx, want := range wantSizes {
+		if got := len(fake.requests[idx]); got != want {
+			t.Errorf("incorrect size for batch %d: expected '%d', got '%d'", idx, want, got)
+		}
+	}
+
+	// only the failed items are sent again
+	for _, call := range fake.requests[3] {
+		var n int
+		fmt.Sscanf(call.summary, "ep%d", &n)
+		if n%5 != 0 {
+			t.Errorf("item '%s' retried but did not fail", call.summary)
+		}
+	}
+
+	if id, ok, _ := getSyncedEventID("u1", ProviderGoogle, "ep5"); !ok || id != "id-ep5" {
+		t.Errorf("incorrect synced event for 'ep5': expected 'id-ep5', got '%s'", id)
+	}
+}
+
+func TestAddEventsBatchUpdatesAndErrors(t *testing.T) {
+	fake := &fakeBatch{respond: func(call batchCall) int {
+		switch {
+		case call.summary == "ep1":
+			return http.StatusBadRequest
+		case call.path == primaryEventsPath+"/gone":
+			return http.StatusNotFound
+		}
+		return http.StatusOK
+	}}
+	useFakeBatch(t, fake)
+
+	setSyncedEventID("u1", ProviderGoogle, "ep0", "old0")
+	setSyncedEventID("u1", ProviderGoogle, "ep2", "gone")
+	result := addEventsBatch(context.Background(), http.DefaultClient, "u1", makeEvents(3))
+
+	// the bad request is reported and never retried
+	if result.Failed != 1 || result.Updated != 1 || result.Created != 1 {
+		t.Fatalf("incorrect result: expected 1 failed/updated/created, got '%+v'", result)
+	}
+
+	cases := []struct {
+		call batchCall
+		want int
+	}{
+		{batchCall{http.MethodPut, primaryEventsPath + "/old0", "ep0"}, 1},
+		{batchCall{http.MethodPost, primaryEventsPath, "ep1"}, 1},
+		{batchCall{http.MethodPut, primaryEventsPath + "/gone", "ep2"}, 1},
+		{batchCall{http.MethodPost, primaryEventsPath, "ep2"}, 1},
+	}
+	for _, c := range cases {
+		got := 0
+		for _, batch := range fake.requests {
+			for _, call := range batch {
+				if call == c.call {
+					got++
+				}
+			}
+		}
+
+		if got != c.want {
+			t.Errorf("incorrect count for '%+v': expected '%d', got '%d'", c.call, c.want, got)
+		}
+	}
+
+	if id, _, _ := getSyncedEventID("u1", ProviderGoogle, "ep2"); id != "id-ep2" {
+		t.Errorf("incorrect synced event for 'ep2': expected 'id-ep2', got '%s'", id)
+	}
+}
+
+func TestBatchItemIndex(t *testing.T) {
+	cases := []struct {
+		in     string
+		want   int
+		wantOk bool
+	}{
+		{"<response-item-0>", 0, true},
+		{"<response-item-42>", 42, true},
+		{"response-item-7", 7, true},
+		{"<response-item-x>", 0, false},
+		{"", 0, false},
+	}
+
+	for _, c := range cases {
+		got, ok := batchItemIndex(c.in)
+
+		if ok != c.wantOk || got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%d' (%t), got '%d' (%t)",
+				c.in, c.want, c.wantOk, got, ok)
+		}
+	}
+}
+
+func TestParseBatchResponseTruncated(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "application/http")
+	header.Set("Content-ID", "<response-item-0>")
+	part, _ := writer.CreatePart(header)
+	fmt.Fprint(part, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n{\"id\":\"new\"}")
+	// cut off before the remaining items and the closing boundary
+	header.Set("Content-ID", "<response-item-1>")
+	part, _ = writer.CreatePart(header)
+	fmt.Fprint(part, "HTTP/1.1 200 OK\r\n")
+
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": {"multipart/mixed; boundary=" + writer.Boundary()}},
+		Body:   ioutil.NopCloser(body),
+	}
+	ops := []batchOp{{}, {}, {eventID: "old"}}
+	results, err := parseBatchResponse(resp, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name      string
+		result    batchResult
+		wantErr   bool
+		wantRetry bool
+	}{
+		{"read", results[0], false, false},
+		// the insert may have been applied, but the update is safe to send again
+		{"cut off insert", results[1], true, false},
+		{"unread update", results[2], true, true},
+	}
+	for _, c := range cases {
+		if (c.result.err != nil) != c.wantErr || c.result.retry != c.wantRetry {
+			t.Errorf("incorrect output for '%s': expected error '%t' and retry '%t', got '%+v'",
+				c.name, c.wantErr, c.wantRetry, c.result)
+		}
+	}
+}
+
+func TestParseBatchItemUnreadableBody(t *testing.T) {
+	cases := []struct {
+		name string
+		op   batchOp
+	}{
+		{"insert", batchOp{}},
+		{"update", batchOp{eventID: "old"}},
+	}
+
+	for _, c := range cases {
+		got := parseBatchItem(http.StatusOK, []byte(`{"id":`), c.op)
+		if got.err == nil || !got.written || got.retry {
+			t.Errorf("incorrect output for '%s': expected a written failure, got '%+v'", c.name, got)
+		}
+	}
+}
@@ -30,12 +30,24 @@ import (
 
 // BasicEvent is a simple calendar event with name, description, start, end
 type BasicEvent struct {
+	// Key identifies the source episode, so adding it again updates the
+	// existing event instead of creating a duplicate
+	Key         string
 	Summary     string
 	Description string
 	Start       time.Time
 	End         time.Time
+	// AllDay events cover the days from Start up to End, ignoring the time
+	AllDay bool
+	// Location is where to watch, ie the network or streaming service
+	Location string
+	// ImageURL is the show's image, attached as the event's source
+	ImageURL string
 }
 
+// dates of all day events
+const allDayFormat = "2006-01-02"
+
 // TODO pass this down from main
 // change to something else if run as main
 const serverPort = "8080"
@@ -50,59 +62,326 @@ var (
 		Scopes:       []string{"https://www.googleapis.com/auth/calendar"},
 		Endpoint:     google.Endpoint,
 	}
-	// Get a random string for each user login
-	oauthStateString = "random"
-	testToken        oauth2.Token
-	hasRun           bool
 )
 
+// outcomes for a single saved event
+const (
+	// EventPending events are still waiting to be written
+	EventPending = "pending"
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+	EventSkipped = "skipped"
+	EventFailed  = "failed"
+)
+
+// EventResult is the outcome of saving a single event
+type EventResult struct {
+	Key     string `json:"key"`
+	Summary string `json:"summary"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AddResult summarizes saving a set of events to a calendar
+type AddResult struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// Throttled counts calls the provider rejected for rate limits, all of
+	// which were retried with backoff
+	Throttled int           `json:"throttled"`
+	Events    []EventResult `json:"events"`
+}
+
+// ProgressFunc receives the running result while events are written, with
+// events not yet written marked EventPending
+type ProgressFunc func(result AddResult)
+
+// Sink is a calendar provider that showCal can write events to
+type Sink interface {
+	// AddEvents writes events to the user's default calendar, giving up on
+	// the rest once ctx is done
+	AddEvents(ctx context.Context, userID string, events []BasicEvent) AddResult
+	// DeleteEvents removes the events previously saved for keys
+	DeleteEvents(ctx context.Context, userID string, keys []string) AddResult
+}
+
+// googleSink writes events to Google Calendar
+type googleSink struct{}
+
+// sinks are the supported calendar providers, keyed by provider name
+var sinks = map[string]Sink{
+	ProviderGoogle:  googleSink{},
+	ProviderOutlook: outlookSink{},
+}
+
 const htmlIndex = `<html><body>
-<a href="/GoogleLogin">Log in with Google</a>
+<a href="/GoogleLogin">Log in with Google</a><br>
+<a href="/OutlookLogin">Log in with Outlook</a>
 </body></html>
 `
 
-// AddEpisodesToCalendar concurrently adds one more more events to the calendar
-// TODO validate all dates are in the future
-func AddEpisodesToCalendar(episodes tvshowdata.Episodes) {
-	if !hasValidToken() {
-		return
+// AddEpisodesToCalendar adds one more more events to the user's calendar
+// with provider, reporting what happened to each one. Episodes that already
+// aired or are repeated are skipped. Events not yet written when ctx is done
+// are reported as failed
+func AddEpisodesToCalendar(ctx context.Context, userID, provider string,
+	episodes tvshowdata.Episodes) (AddResult, error) {
+	return AddEpisodesToCalendarWithProgress(ctx, userID, provider, episodes, nil)
+}
+
+// AddEpisodesToCalendarWithProgress is AddEpisodesToCalendar, calling
+// progress (if not nil) once the episodes are planned and again as each
+// chunk of events is written
+func AddEpisodesToCalendarWithProgress(ctx context.Context, userID, provider string,
+	episodes tvshowdata.Episodes, progress ProgressFunc) (AddResult, error) {
+	sink, ok := sinks[provider]
+	if !ok {
+		return AddResult{}, gerrors.New(fmt.Sprintf("Unknown calendar provider '%s'", provider))
+	}
+	if err := requireLogin(userID, provider); err != nil {
+		return AddResult{}, err
 	}
 
-	service, err := getCalendarService(testToken)
+	if err := adoptLegacyKeys(userID, provider, episodes.Episodes); err != nil {
+		return AddResult{}, gerrors.Wrapf(err, "Error in AddEpisodesToCalendar()")
+	}
+	plan, err := planEpisodes(userID, provider, episodes, time.Now())
 	if err != nil {
-		fmt.Println(err) // TODO
-	}
-
-	for idx := range episodes.Episodes {
-		// TODO error handling from createSingleEvent()
-		// TODO verify: can't pass episode since it changes each loop
-		go func(ep tvshowdata.Episode) {
-			err := createSingleEvent(formatEpisodeForCalendar(ep), service)
-			if err != nil {
-				// TODO better error handling
-				fmt.Println("AddEpsidoesToCalendar err:", err, "episode:",
-					ep)
+		return AddResult{}, err
+	}
+	result := AddResult{Events: make([]EventResult, len(plan))}
+	// where each event waiting to be written is reported in result
+	pending := make(map[string]int)
+	var events []BasicEvent
+	for idx, planned := range plan {
+		event := planned.event
+		if planned.action == ActionSkip {
+			result.Skipped++
+			result.Events[idx] = EventResult{Key: event.Key, Summary: event.Summary,
+				Status: EventSkipped, Reason: planned.reason}
+			continue
+		}
+
+		result.Events[idx] = EventResult{Key: event.Key, Summary: event.Summary,
+			Status: EventPending}
+		pending[event.Key] = idx
+		events = append(events, event)
+	}
+
+	report := func() {
+		if progress != nil {
+			snapshot := result
+			snapshot.Events = append([]EventResult(nil), result.Events...)
+			progress(snapshot)
+		}
+	}
+	report()
+
+	for start := 0; start < len(events); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(events) {
+			end = len(events)
+		}
+
+		saved := sink.AddEvents(ctx, userID, events[start:end])
+		result.Created += saved.Created
+		result.Updated += saved.Updated
+		result.Failed += saved.Failed
+		result.Throttled += saved.Throttled
+		notifyEventResults(userID, provider, saved.Events)
+		for _, event := range saved.Events {
+			if idx, ok := pending[event.Key]; ok {
+				result.Events[idx] = event
+				delete(pending, event.Key)
 			}
-		}(episodes.Episodes[idx])
+		}
+		report()
+	}
+
+	return result, nil
+}
+
+// RemoveEpisodesFromCalendar deletes the events showCal saved for episodes
+// from the user's calendar with provider. Episodes that were never saved
+// are skipped
+func RemoveEpisodesFromCalendar(ctx context.Context, userID, provider string,
+	episodes tvshowdata.Episodes) (AddResult, error) {
+	sink, ok := sinks[provider]
+	if !ok {
+		return AddResult{}, gerrors.New(fmt.Sprintf("Unknown calendar provider '%s'", provider))
+	}
+	if err := requireLogin(userID, provider); err != nil {
+		return AddResult{}, err
+	}
+
+	if err := adoptLegacyKeys(userID, provider, episodes.Episodes); err != nil {
+		return AddResult{}, gerrors.Wrapf(err, "Error in RemoveEpisodesFromCalendar()")
+	}
+
+	var keys []string
+	var skipped []BasicEvent
+	for _, episode := range episodes.Episodes {
+		key := EpisodeKey(episode)
+		_, saved, err := getSyncedEventID(userID, provider, key)
+		if err != nil {
+			return AddResult{}, gerrors.Wrapf(err, "Error in RemoveEpisodesFromCalendar()")
+		}
+		if !saved {
+			skipped = append(skipped, BasicEvent{Key: key})
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	result := AddResult{}
+	if len(keys) > 0 {
+		result = sink.DeleteEvents(ctx, userID, keys)
+		notifyEventResults(userID, provider, result.Events)
+	}
+	for _, event := range skipped {
+		result.skip(event, "not in calendar")
 	}
+
+	return result, nil
 }
 
-// TODO this only checks if it's been init
-func hasValidToken() bool {
-	if !hasRun {
-		msg := fmt.Sprintf("Go to http://localhost:%s/login to auth with google services",
-			serverPort)
+// record the outcome of saving event
+func (r *AddResult) add(event BasicEvent, status string, err error) {
+	result := EventResult{Key: event.Key, Summary: event.Summary, Status: status}
+	switch status {
+	case EventCreated:
+		r.Created++
+	case EventUpdated:
+		r.Updated++
+	case EventDeleted:
+		r.Deleted++
+	default:
+		r.Failed++
+		if err != nil {
+			result.Error = err.Error()
+		}
+	}
+
+	r.Events = append(r.Events, result)
+}
+
+// record that event was not written, and why
+func (r *AddResult) skip(event BasicEvent, reason string) {
+	r.Skipped++
+	r.Events = append(r.Events, EventResult{Key: event.Key, Summary: event.Summary,
+		Status: EventSkipped, Reason: reason})
+}
+
+// requireLogin errors if the user hasn't authorized showCal with provider
+// TODO this only checks if a token was stored, not that it still works
+func requireLogin(userID, provider string) error {
+	ok, err := HasToken(userID, provider)
+	if err != nil {
+		return gerrors.Wrapf(err, "Error in requireLogin()")
+	}
+	if !ok {
+		msg := fmt.Sprintf("Go to http://localhost:%s/login to auth with %s services",
+			serverPort, provider)
 		fmt.Println(msg)
-		return false
+		return gerrors.New(fmt.Sprintf("No %s login for user", provider))
+	}
+
+	return nil
+}
+
+// AddEvents writes events to the user's primary calendar, using a single
+// request for one event and batch requests for more
+func (googleSink) AddEvents(ctx context.Context, userID string, events []BasicEvent) AddResult {
+	token, ok, err := tokens.GetToken(userID, ProviderGoogle)
+	if err != nil {
+		return failAll(events, gerrors.Wrapf(err, "Error loading google token"))
+	}
+	if !ok {
+		return failAll(events, gerrors.New("no google token for user"))
+	}
+
+	client := getUserClient(userID, ProviderGoogle, googleOauthConfig, token)
+	if len(events) != 1 {
+		return addEventsBatch(ctx, client, userID, events)
+	}
+
+	service, err := getCalendarService(client)
+	if err != nil {
+		return failAll(events, err)
+	}
+
+	result := AddResult{}
+	status, throttled, err := createSingleEvent(ctx, userID, events[0], service)
+	if err != nil {
+		fmt.Println("googleSink.AddEvents err:", err, "event:", events[0])
 	}
+	result.Throttled = throttled
+	result.add(events[0], status, err)
 
-	return true
+	return result
 }
 
-// convert a valid OAuth2 token into a calendar service with background context
+// DeleteEvents removes the events saved for keys from the user's primary
+// calendar, treating events the user already deleted as removed
+func (googleSink) DeleteEvents(ctx context.Context, userID string, keys []string) AddResult {
+	events := keyEvents(keys)
+	token, ok, err := tokens.GetToken(userID, ProviderGoogle)
+	if err != nil {
+		return failAll(events, gerrors.Wrapf(err, "Error loading google token"))
+	}
+	if !ok {
+		return failAll(events, gerrors.New("no google token for user"))
+	}
+
+	client := getUserClient(userID, ProviderGoogle, googleOauthConfig, token)
+	service, err := getCalendarService(client)
+	if err != nil {
+		return failAll(events, err)
+	}
+
+	result := AddResult{}
+	for _, event := range events {
+		throttled, err := deleteSingleEvent(ctx, userID, event.Key, service)
+		if err != nil {
+			fmt.Println("googleSink.DeleteEvents err:", err, "key:", event.Key)
+			result.add(event, EventFailed, err)
+		} else {
+			result.add(event, EventDeleted, nil)
+		}
+		result.Throttled += throttled
+	}
+
+	return result
+}
+
+// keyEvents wraps keys as events for reporting results
+func keyEvents(keys []string) []BasicEvent {
+	events := make([]BasicEvent, len(keys))
+	for idx, key := range keys {
+		events[idx] = BasicEvent{Key: key}
+	}
+
+	return events
+}
+
+// failAll reports every event as failed with err
+func failAll(events []BasicEvent, err error) AddResult {
+	result := AddResult{}
+	for _, event := range events {
+		result.add(event, EventFailed, err)
+	}
+
+	return result
+}
+
+// convert an authorized http client into a calendar service with background context
 // only one of *Service, error will be non-nil
-func getCalendarService(token oauth2.Token) (*calendar.Service, error) {
-	client := oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&token))
+func getCalendarService(client *http.Client) (*calendar.Service, error) {
 	ctx := context.Background()
 
 	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
@@ -121,49 +400,112 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 // HandleGoogleLogin redirects to google services to auth with gcal
 func HandleGoogleLogin(w http.ResponseWriter, r *http.Request) {
-	url := googleOauthConfig.AuthCodeURL(oauthStateString)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	beginOAuthLogin(w, r, googleOauthConfig)
 }
 
 // HandleGoogleCallback processes oauth2 data from google services
 func HandleGoogleCallback(w http.ResponseWriter, r *http.Request) {
-	state := r.FormValue("state")
-	if state != oauthStateString {
-		fmt.Printf("invalid oauth state, expected '%s', got '%s'\n",
-			oauthStateString, state)
-		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
-		return
-	}
+	finishOAuthLogin(w, r, googleOauthConfig, ProviderGoogle)
+}
 
-	code := r.FormValue("code")
-	token, err := googleOauthConfig.Exchange(context.Background(), code)
+// Creates a single event in the user's primary calendar, or updates the
+// event previously created for the same episode. Calls rejected for rate
+// limits are retried with backoff, and counted in the returned int
+func createSingleEvent(ctx context.Context, userID string, event BasicEvent,
+	service *calendar.Service) (string, int, error) {
+	gcalEvent, err := buildCalendarEvent(event)
 	if err != nil {
-		fmt.Printf("oauthConf.Exchange() failed with '%s'\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
-		return
+		err = gerrors.Wrapf(err, "Error in createSingleEvent()")
+		return EventFailed, 0, err
 	}
 
-	// TODO remove test code after building real solution
-	hasRun = true
-	testToken = *token
+	throttled := 0
+	for attempt := 1; ; attempt++ {
+		if err := waitForQuota(ctx, userID, 1); err != nil {
+			return EventFailed, throttled, gerrors.Wrapf(err, "Error in createSingleEvent()")
+		}
+
+		status, err := saveSingleEvent(ctx, userID, event.Key, &gcalEvent, service)
+		if err == nil {
+			return status, throttled, nil
+		}
+		if !isRetryableError(err) || attempt >= maxCallAttempts {
+			err = gerrors.Wrapf(err, "Error in createSingleEvent()")
+			return EventFailed, throttled, err
+		}
+		if isRateLimitError(err) {
+			throttled++
+		}
+
+		if err := sleepContext(ctx, backoffDelay(attempt)); err != nil {
+			return EventFailed, throttled, gerrors.Wrapf(err, "Error in createSingleEvent()")
+		}
+	}
 }
 
-// Creates a single event in the user's primary calendar
-func createSingleEvent(event BasicEvent, service *calendar.Service) error {
-	gcalEvent, err := buildCalendarEvent(event)
+// Deletes the event saved for key from the user's primary calendar, with
+// the same retries as createSingleEvent
+func deleteSingleEvent(ctx context.Context, userID, key string, service *calendar.Service) (int, error) {
+	eventID, ok, err := getSyncedEventID(userID, ProviderGoogle, key)
 	if err != nil {
-		err = gerrors.Wrapf(err, "Error in createSingleEvent()")
-		return err
+		return 0, gerrors.Wrapf(err, "Error in deleteSingleEvent()")
+	}
+	if !ok {
+		return 0, nil
+	}
+
+	throttled := 0
+	for attempt := 1; ; attempt++ {
+		if err := waitForQuota(ctx, userID, 1); err != nil {
+			return throttled, gerrors.Wrapf(err, "Error in deleteSingleEvent()")
+		}
+
+		err := service.Events.Delete("primary", eventID).Context(ctx).Do()
+		if err == nil || isStaleEventError(err) {
+			deleteSyncedEventID(userID, ProviderGoogle, key)
+			return throttled, nil
+		}
+		if !isRetryableError(err) || attempt >= maxCallAttempts {
+			return throttled, gerrors.Wrapf(err, "Error in deleteSingleEvent()")
+		}
+		if isRateLimitError(err) {
+			throttled++
+		}
+
+		if err := sleepContext(ctx, backoffDelay(attempt)); err != nil {
+			return throttled, gerrors.Wrapf(err, "Error in deleteSingleEvent()")
+		}
 	}
+}
 
-	createdEvent, err := service.Events.Insert("primary", &gcalEvent).Do()
+// Inserts or updates gcalEvent, depending on if key was saved before
+func saveSingleEvent(ctx context.Context, userID, key string, gcalEvent *calendar.Event,
+	service *calendar.Service) (string, error) {
+	var savedEvent *calendar.Event
+
+	status := EventUpdated
+	eventID, haveEvent, err := getSyncedEventID(userID, ProviderGoogle, key)
 	if err != nil {
-		err = gerrors.Wrapf(err, "Error in createSingleEvent()")
-		return err
+		return EventFailed, err
+	}
+	if haveEvent {
+		savedEvent, err = service.Events.Update("primary", eventID, gcalEvent).Context(ctx).Do()
+		if isStaleEventError(err) {
+			// the user deleted the old event, so start over with a new one
+			haveEvent = false
+		}
+	}
+	if !haveEvent {
+		status = EventCreated
+		savedEvent, err = service.Events.Insert("primary", gcalEvent).Context(ctx).Do()
+	}
+	if err != nil {
+		return EventFailed, err
 	}
 
-	fmt.Println("Calendar event created:", createdEvent.HtmlLink)
-	return nil
+	setSyncedEventID(userID, ProviderGoogle, key, savedEvent.Id)
+	fmt.Println("Calendar event saved:", savedEvent.HtmlLink)
+	return status, nil
 }
 
 // Converts standard struct into google calendar event format
@@ -177,26 +519,84 @@ func buildCalendarEvent(event BasicEvent) (calendar.Event, error) {
 	gcalEvent := calendar.Event{
 		Summary:     event.Summary,
 		Description: event.Description,
-		Start:       &calendar.EventDateTime{DateTime: event.Start.Format(time.RFC3339)},
-		End:         &calendar.EventDateTime{DateTime: event.End.Format(time.RFC3339)},
+		Location:    event.Location,
+		Start:       eventDateTime(event.Start, event.AllDay),
+		End:         eventDateTime(event.End, event.AllDay),
+		Reminders:   &calendar.EventReminders{UseDefault: true},
+	}
+	if event.ImageURL != "" {
+		gcalEvent.Source = &calendar.EventSource{Title: event.Summary, Url: event.ImageURL}
 	}
 
 	return gcalEvent, nil
 }
 
-// todo need to check input data is valid somewhere
-// todo check that runtime isn't greater than 3 hours
+// eventDateTime converts t to calendar format, naming its zone when known,
+// or to just its date for all day events
+func eventDateTime(t time.Time, allDay bool) *calendar.EventDateTime {
+	if allDay {
+		return &calendar.EventDateTime{Date: t.Format(allDayFormat)}
+	}
+
+	dateTime := &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}
+	if zone := t.Location().String(); zone != "Local" {
+		dateTime.TimeZone = zone
+	}
+
+	return dateTime
+}
+
 // formatEpisodeForCalendar converts a TV show episode into a calendar event
+// using the server-wide templates
 func formatEpisodeForCalendar(episode tvshowdata.Episode) BasicEvent {
-	summary := fmt.Sprintf("%s: \"%s\"", episode.ShowName, episode.Title)
-	description := fmt.Sprintf("%s: \"%s\"\nSeason %d, Episode %d",
-		episode.ShowName, episode.Title, episode.Season, episode.Episode)
+	return formatEpisodeWithTemplates(episode, DefaultTemplates())
+}
+
+// formatEpisodeWithTemplates converts a TV show episode into a calendar
+// event, rendering the summary and description with templates
+func formatEpisodeWithTemplates(episode tvshowdata.Episode, templates EventTemplates) BasicEvent {
+	summary, description, err := renderTemplates(templates, episode)
+	if err != nil {
+		// templates are validated when saved, so this should not happen
+		fmt.Println("formatEpisodeWithTemplates():", err)
+		builtin := EventTemplates{Summary: defaultSummaryTemplate,
+			Description: defaultDescriptionTemplate}
+		summary, description, _ = renderTemplates(builtin, episode)
+	}
+
 	event := BasicEvent{
+		Key:         EpisodeKey(episode),
 		Summary:     summary,
 		Description: description,
 		Start:       episode.AirDate.Time,
 		End:         episode.AirDate.Time.Add(time.Minute * time.Duration(episode.RuntimeMinutes)),
+		Location:    episode.WhereToWatch.String(),
+		ImageURL:    episode.ShowImageURL,
+	}
+	if episode.DateOnly() {
+		// the time isn't known, so block out the day
+		day := episode.AirDate.UTC()
+		event.Start = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		event.End = event.Start.AddDate(0, 0, 1)
+		event.AllDay = true
 	}
 
 	return event
 }
+
+// EpisodeKey identifies an episode for the synced events mapping: its
+// provider and provider episode ID, or for episodes without them, the show
+// name, season and episode
+func EpisodeKey(episode tvshowdata.Episode) string {
+	if episode.Provider == "" || episode.ProviderEpisodeID == "" {
+		return legacyEpisodeKey(episode)
+	}
+
+	return episode.Provider + ":" + episode.ProviderEpisodeID
+}
+
+// legacyEpisodeKey is how episodes were keyed before they carried provider
+// IDs, still used to find the events synced back then
+func legacyEpisodeKey(episode tvshowdata.Episode) string {
+	return fmt.Sprintf("%s/S%02dE%02d", episode.ShowName, episode.Season, episode.Episode)
+}
@@ -23,11 +23,49 @@ func TestFormatEpisodeForCalendar(t *testing.T) {
 			RuntimeMinutes: 30,
 			ShowName:       "A",
 		}, BasicEvent{
+			Key:         "A/S01E01",
 			Summary:     "A: \"B\"",
 			Description: "A: \"B\"\nSeason 1, Episode 1",
 			Start:       time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
 			End:         time.Date(2019, 1, 1, 0, 30, 0, 0, time.UTC),
 		}},
+		{tvshowdata.Episode{
+			Season:  0,
+			Episode: 2,
+			Title:   "B",
+			AirDate: tvshowdata.Time{
+				Time: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			AirDatePrecision: tvshowdata.PrecisionDate,
+			RuntimeMinutes:   30,
+			ShowName:         "A",
+		}, BasicEvent{
+			Key:         "A/S00E02",
+			Summary:     "A: \"B\"",
+			Description: "A: \"B\"\nSpecial 2",
+			Start:       time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:         time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+			AllDay:      true,
+		}},
+		{tvshowdata.Episode{
+			Season:  1,
+			Episode: 2,
+			Title:   "B",
+			AirDate: tvshowdata.Time{
+				Time: time.Date(2019, 1, 1, 0, 30, 0, 0, time.UTC),
+			},
+			AirDatePrecision: tvshowdata.PrecisionTime,
+			SlotPart:         2,
+			SlotParts:        2,
+			RuntimeMinutes:   30,
+			ShowName:         "A",
+		}, BasicEvent{
+			Key:         "A/S01E02",
+			Summary:     "A: \"B\"",
+			Description: "A: \"B\"\nSeason 1, Episode 2 (part 2 of 2)",
+			Start:       time.Date(2019, 1, 1, 0, 30, 0, 0, time.UTC),
+			End:         time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC),
+		}},
 	}
 
 	for _, c := range cases {
@@ -39,3 +77,80 @@ func TestFormatEpisodeForCalendar(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildAllDayCalendarEvent(t *testing.T) {
+	event := BasicEvent{
+		Summary: "A: \"B\"",
+		Start:   time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:     time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+		AllDay:  true,
+	}
+
+	gcalEvent, err := buildCalendarEvent(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcalEvent.Start.Date != "2019-01-01" || gcalEvent.Start.DateTime != "" {
+		t.Errorf("incorrect start: expected date '2019-01-01', got '%+v'", gcalEvent.Start)
+	}
+	if gcalEvent.End.Date != "2019-01-02" || gcalEvent.End.DateTime != "" {
+		t.Errorf("incorrect end: expected date '2019-01-02', got '%+v'", gcalEvent.End)
+	}
+}
+
+func TestFormatEnrichedEpisode(t *testing.T) {
+	episode := tvshowdata.Episode{Season: 1, Episode: 2, Title: "B", ShowName: "A", RuntimeMinutes: 30,
+		AirDate:      tvshowdata.Time{Time: time.Date(2119, 1, 1, 0, 0, 0, 0, time.UTC)},
+		ShowURL:      "https://www.episodate.com/tv-show/a",
+		Synopsis:     "Things happen.",
+		ShowImageURL: "https://example.com/a.jpg",
+		Links:        tvshowdata.EpisodeLinks{Episode: "https://www.tvmaze.com/episodes/2"},
+		WhereToWatch: tvshowdata.ChannelFor("Netflix", false),
+	}
+
+	event := formatEpisodeForCalendar(episode)
+	wantDescription := "A: \"B\"\nSeason 1, Episode 2\n\nThings happen.\n\nhttps://www.tvmaze.com/episodes/2"
+	if event.Description != wantDescription {
+		t.Errorf("incorrect description: expected '%s', got '%s'", wantDescription, event.Description)
+	}
+
+	gcalEvent, err := buildCalendarEvent(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gcalEvent.Location != "Netflix (https://www.netflix.com)" {
+		t.Errorf("incorrect location: expected 'Netflix (https://www.netflix.com)', got '%s'", gcalEvent.Location)
+	}
+	if gcalEvent.Source == nil || gcalEvent.Source.Url != episode.ShowImageURL || gcalEvent.Source.Title != event.Summary {
+		t.Errorf("incorrect source: expected '%s', got '%+v'", episode.ShowImageURL, gcalEvent.Source)
+	}
+
+	// no image, no source
+	episode.ShowImageURL = ""
+	if gcalEvent, _ := buildCalendarEvent(formatEpisodeForCalendar(episode)); gcalEvent.Source != nil {
+		t.Errorf("incorrect source: expected none, got '%+v'", gcalEvent.Source)
+	}
+}
+
+func TestPlanEpisodesEnriches(t *testing.T) {
+	defer SetEpisodeEnricher(nil)
+	SetEpisodeEnricher(func(episodes []tvshowdata.Episode) []tvshowdata.Episode {
+		enriched := append([]tvshowdata.Episode(nil), episodes...)
+		for idx := range enriched {
+			enriched[idx].Synopsis = "Enriched."
+		}
+		return enriched
+	})
+
+	episode := tvshowdata.Episode{Season: 1, Episode: 1, Title: "B", ShowName: "A", RuntimeMinutes: 30,
+		AirDate: tvshowdata.Time{Time: time.Date(2119, 1, 1, 0, 0, 0, 0, time.UTC)}}
+	plan, err := planEpisodes("", ProviderGoogle, tvshowdata.Episodes{Episodes: []tvshowdata.Episode{episode}},
+		time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan) != 1 || plan[0].event.Description != "A: \"B\"\nSeason 1, Episode 1\n\nEnriched." {
+		t.Errorf("incorrect plan: got '%+v'", plan)
+	}
+}
@@ -0,0 +1,91 @@
+// Notifies a user's live clients about their calendar events and logins
+
+package gcalwrapper
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/swayne275/showcal-backend-go/notify"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// notification types for each event outcome
+var eventNotifications = map[string]string{
+	EventCreated: notify.EpisodeCreated,
+	EventUpdated: notify.EpisodeUpdated,
+	EventDeleted: notify.EpisodeDeleted,
+	EventFailed:  notify.EpisodeFailed,
+}
+
+// eventNotification is the data sent for an event outcome
+type eventNotification struct {
+	Calendar string `json:"calendar"`
+	EventResult
+}
+
+// tokenNotification is the data sent when a login can't be used
+type tokenNotification struct {
+	Calendar string `json:"calendar"`
+	Error    string `json:"error"`
+}
+
+// notifyingTokenSource saves tokens src refreshes, and tells the user,
+// once, that src couldn't produce a usable token
+type notifyingTokenSource struct {
+	userID   string
+	provider string
+	src      oauth2.TokenSource
+	once     sync.Once
+
+	mu sync.Mutex
+	// the access token last saved
+	saved string
+}
+
+// Token returns a token from src, refreshing it if needed
+func (s *notifyingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.src.Token()
+	if err != nil {
+		s.once.Do(func() {
+			notify.Publish(s.userID, notify.TokenExpired,
+				tokenNotification{Calendar: s.provider, Error: err.Error()})
+		})
+		return token, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.saved {
+		// refreshed, so keep it for next time
+		if saveErr := tokens.SetToken(s.userID, s.provider, *token); saveErr != nil {
+			fmt.Println("error saving refreshed token", saveErr)
+		} else {
+			s.saved = token.AccessToken
+		}
+	}
+
+	return token, nil
+}
+
+// http client authorized with the user's OAuth2 token for provider, which
+// refreshes the token when it can and notifies the user when it can't
+func getUserClient(userID, provider string, config *oauth2.Config, token oauth2.Token) *http.Client {
+	ctx := context.Background()
+	src := &notifyingTokenSource{userID: userID, provider: provider,
+		src: config.TokenSource(ctx, &token), saved: token.AccessToken}
+
+	return oauth2.NewClient(ctx, src)
+}
+
+// notifyEventResults tells the user what happened to each written event
+func notifyEventResults(userID, provider string, results []EventResult) {
+	for _, result := range results {
+		if eventType, ok := eventNotifications[result.Status]; ok {
+			notify.Publish(userID, eventType,
+				eventNotification{Calendar: provider, EventResult: result})
+		}
+	}
+}
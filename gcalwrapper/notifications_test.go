@@ -0,0 +1,58 @@
+package gcalwrapper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/swayne275/showcal-backend-go/notify"
+	"golang.org/x/oauth2"
+)
+
+// brokenTokenSource can't refresh
+type brokenTokenSource struct{}
+
+func (brokenTokenSource) Token() (*oauth2.Token, error) {
+	return nil, errors.New("oauth2: token expired and refresh token is not set")
+}
+
+func TestNotifyingTokenSource(t *testing.T) {
+	listener, _ := notify.Listen("token-user", 0)
+	defer listener.Close()
+
+	src := &notifyingTokenSource{userID: "token-user", provider: ProviderGoogle,
+		src: brokenTokenSource{}}
+	for i := 0; i < 3; i++ {
+		if _, err := src.Token(); err == nil {
+			t.Fatalf("expected token error")
+		}
+	}
+
+	// told once per client, not once per call
+	if got := len(listener.C); got != 1 {
+		t.Fatalf("incorrect number of notifications: expected '1', got '%d'", got)
+	}
+	if event := <-listener.C; event.Type != notify.TokenExpired {
+		t.Errorf("incorrect notification: expected '%s', got '%s'", notify.TokenExpired, event.Type)
+	}
+}
+
+func TestNotifyEventResults(t *testing.T) {
+	listener, _ := notify.Listen("results-user", 0)
+	defer listener.Close()
+
+	notifyEventResults("results-user", ProviderGoogle, []EventResult{
+		{Key: "a", Status: EventCreated},
+		{Key: "b", Status: EventSkipped},
+		{Key: "c", Status: EventFailed, Error: "boom"},
+	})
+
+	cases := []string{notify.EpisodeCreated, notify.EpisodeFailed}
+	if got := len(listener.C); got != len(cases) {
+		t.Fatalf("incorrect number of notifications: expected '%d', got '%d'", len(cases), got)
+	}
+	for _, want := range cases {
+		if event := <-listener.C; event.Type != want {
+			t.Errorf("incorrect notification: expected '%s', got '%s'", want, event.Type)
+		}
+	}
+}
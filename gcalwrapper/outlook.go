@@ -0,0 +1,326 @@
+// Calendar sink for Outlook using the Microsoft Graph events API
+
+package gcalwrapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/swayne275/gerrors"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+const (
+	// date time layout Graph expects alongside an explicit time zone
+	graphTimeFormat = "2006-01-02T15:04:05"
+)
+
+var (
+	outlookOauthConfig = &oauth2.Config{
+		RedirectURL: "http://localhost:" + serverPort + "/OutlookCallback",
+		// from https://portal.azure.com app registrations
+		// TODO stored in environment variables for now, fix this
+		ClientID:     os.Getenv("outlookkey"),
+		ClientSecret: os.Getenv("outlooksecret"),
+		Scopes: []string{"offline_access",
+			"https://graph.microsoft.com/Calendars.ReadWrite"},
+		Endpoint: microsoft.AzureADEndpoint("common"),
+	}
+	// root of the Microsoft Graph API, swapped out in tests
+	graphBaseURL = "https://graph.microsoft.com/v1.0"
+)
+
+// outlookSink writes events to the user's Outlook calendar
+type outlookSink struct{}
+
+// graphEvent is the subset of a Graph event resource showCal writes
+type graphEvent struct {
+	ID       string        `json:"id,omitempty"`
+	Subject  string        `json:"subject"`
+	Body     graphItemBody `json:"body"`
+	Start    graphDateTime `json:"start"`
+	End      graphDateTime `json:"end"`
+	Location graphLocation `json:"location"`
+	IsAllDay bool          `json:"isAllDay"`
+	WebLink  string        `json:"webLink,omitempty"`
+}
+
+type graphLocation struct {
+	DisplayName string `json:"displayName"`
+}
+
+type graphItemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+// graphError is the error envelope returned by Graph
+type graphError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// HandleOutlookLogin redirects to microsoft services to auth with outlook
+func HandleOutlookLogin(w http.ResponseWriter, r *http.Request) {
+	beginOAuthLogin(w, r, outlookOauthConfig)
+}
+
+// HandleOutlookCallback processes oauth2 data from microsoft services
+func HandleOutlookCallback(w http.ResponseWriter, r *http.Request) {
+	finishOAuthLogin(w, r, outlookOauthConfig, ProviderOutlook)
+}
+
+// AddEvents creates each event in the user's default Outlook calendar, or
+// updates the event previously created for the same episode
+func (outlookSink) AddEvents(ctx context.Context, userID string, events []BasicEvent) AddResult {
+	token, ok, err := tokens.GetToken(userID, ProviderOutlook)
+	if err != nil {
+		return failAll(events, gerrors.Wrapf(err, "Error loading outlook token"))
+	}
+	if !ok {
+		return failAll(events, gerrors.New("no outlook token for user"))
+	}
+
+	result := AddResult{}
+	client := getUserClient(userID, ProviderOutlook, outlookOauthConfig, token)
+	for _, event := range events {
+		status, err := saveOutlookEvent(ctx, userID, event, client)
+		if err != nil {
+			fmt.Println("outlookSink.AddEvents err:", err, "event:", event)
+		}
+		result.add(event, status, err)
+	}
+
+	return result
+}
+
+// DeleteEvents removes the events saved for keys from the user's default
+// Outlook calendar, treating events the user already deleted as removed
+func (outlookSink) DeleteEvents(ctx context.Context, userID string, keys []string) AddResult {
+	events := keyEvents(keys)
+	token, ok, err := tokens.GetToken(userID, ProviderOutlook)
+	if err != nil {
+		return failAll(events, gerrors.Wrapf(err, "Error loading outlook token"))
+	}
+	if !ok {
+		return failAll(events, gerrors.New("no outlook token for user"))
+	}
+
+	result := AddResult{}
+	client := getUserClient(userID, ProviderOutlook, outlookOauthConfig, token)
+	for _, event := range events {
+		eventID, ok, err := getSyncedEventID(userID, ProviderOutlook, event.Key)
+		if err != nil {
+			result.add(event, EventFailed, gerrors.Wrapf(err, "Error in outlookSink.DeleteEvents()"))
+			continue
+		}
+		if ok {
+			err := deleteOutlookEvent(ctx, eventID, client)
+			if err != nil && !isStaleGraphError(err) {
+				err = gerrors.Wrapf(err, "Error in outlookSink.DeleteEvents()")
+				fmt.Println("outlookSink.DeleteEvents err:", err, "key:", event.Key)
+				result.add(event, EventFailed, err)
+				continue
+			}
+		}
+
+		deleteSyncedEventID(userID, ProviderOutlook, event.Key)
+		result.add(event, EventDeleted, nil)
+	}
+
+	return result
+}
+
+// Updates the event saved for the episode, or creates one if there is none
+// or the user deleted it
+func saveOutlookEvent(ctx context.Context, userID string, event BasicEvent,
+	client *http.Client) (string, error) {
+	eventID, haveEvent, err := getSyncedEventID(userID, ProviderOutlook, event.Key)
+	if err != nil {
+		return EventFailed, gerrors.Wrapf(err, "Error in saveOutlookEvent()")
+	}
+	if haveEvent {
+		err := updateOutlookEvent(ctx, eventID, event, client)
+		if err == nil {
+			return EventUpdated, nil
+		}
+		if !isStaleGraphError(err) {
+			return EventFailed, gerrors.Wrapf(err, "Error in saveOutlookEvent()")
+		}
+	}
+
+	eventID, err = createOutlookEvent(ctx, event, client)
+	if err != nil {
+		return EventFailed, err
+	}
+
+	setSyncedEventID(userID, ProviderOutlook, event.Key, eventID)
+	return EventCreated, nil
+}
+
+// Creates a single event in the user's default Outlook calendar, returning
+// its ID
+func createOutlookEvent(ctx context.Context, event BasicEvent, client *http.Client) (string, error) {
+	created, err := sendGraphEvent(ctx, http.MethodPost, "/me/events", http.StatusCreated,
+		event, client)
+	if err != nil {
+		return "", gerrors.Wrapf(err, "Error in createOutlookEvent()")
+	}
+
+	fmt.Println("Outlook event created:", created.WebLink)
+	return created.ID, nil
+}
+
+// Replaces the details of an existing Outlook event. Graph errors are
+// returned unwrapped so callers can check isStaleGraphError
+func updateOutlookEvent(ctx context.Context, eventID string, event BasicEvent,
+	client *http.Client) error {
+	_, err := sendGraphEvent(ctx, http.MethodPatch, "/me/events/"+url.PathEscape(eventID),
+		http.StatusOK, event, client)
+	return err
+}
+
+// Deletes an Outlook event. Graph errors are returned unwrapped so callers
+// can check isStaleGraphError
+func deleteOutlookEvent(ctx context.Context, eventID string, client *http.Client) error {
+	path := graphBaseURL + "/me/events/" + url.PathEscape(eventID)
+	req, err := http.NewRequest(http.MethodDelete, path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return parseGraphError(resp.StatusCode, respBody)
+	}
+
+	return nil
+}
+
+// Sends event to the Graph path with method, returning the saved event
+func sendGraphEvent(ctx context.Context, method, path string, wantStatus int, event BasicEvent,
+	client *http.Client) (graphEvent, error) {
+	gEvent, err := buildGraphEvent(event)
+	if err != nil {
+		return graphEvent{}, err
+	}
+
+	body, err := json.Marshal(gEvent)
+	if err != nil {
+		return graphEvent{}, err
+	}
+
+	req, err := http.NewRequest(method, graphBaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return graphEvent{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return graphEvent{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return graphEvent{}, err
+	}
+
+	if resp.StatusCode != wantStatus {
+		return graphEvent{}, parseGraphError(resp.StatusCode, respBody)
+	}
+
+	var saved graphEvent
+	if err := json.Unmarshal(respBody, &saved); err != nil {
+		return graphEvent{}, err
+	}
+
+	return saved, nil
+}
+
+// Converts standard struct into graph event format, with times in UTC
+func buildGraphEvent(event BasicEvent) (graphEvent, error) {
+	if event.Summary == "" {
+		err := gerrors.Wrapf(gerrors.New("No event summary"),
+			"Error in buildGraphEvent()")
+		return graphEvent{}, err
+	}
+
+	gEvent := graphEvent{
+		Subject:  event.Summary,
+		Body:     graphItemBody{ContentType: "text", Content: event.Description},
+		Location: graphLocation{DisplayName: event.Location},
+		// all day events already start and end at midnight UTC
+		IsAllDay: event.AllDay,
+		Start: graphDateTime{
+			DateTime: event.Start.UTC().Format(graphTimeFormat),
+			TimeZone: "UTC",
+		},
+		End: graphDateTime{
+			DateTime: event.End.UTC().Format(graphTimeFormat),
+			TimeZone: "UTC",
+		},
+	}
+	return gEvent, nil
+}
+
+// graphStatusError is a non-success Graph response
+type graphStatusError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *graphStatusError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("graph returned HTTP StatusCode: %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("graph returned HTTP StatusCode %d: %s: %s",
+		e.StatusCode, e.Code, e.Message)
+}
+
+// Converts a non-success Graph response into an error
+func parseGraphError(statusCode int, body []byte) error {
+	statusErr := &graphStatusError{StatusCode: statusCode}
+
+	var gErr graphError
+	if err := json.Unmarshal(body, &gErr); err == nil {
+		statusErr.Code = gErr.Error.Code
+		statusErr.Message = gErr.Error.Message
+	}
+
+	return statusErr
+}
+
+// isStaleGraphError reports if err means the event no longer exists
+func isStaleGraphError(err error) bool {
+	statusErr, ok := err.(*graphStatusError)
+	if !ok {
+		return false
+	}
+
+	return statusErr.StatusCode == http.StatusNotFound ||
+		statusErr.StatusCode == http.StatusGone
+}
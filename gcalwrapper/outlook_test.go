@@ -0,0 +1,364 @@
+package gcalwrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// fakeGraph emulates the Graph /me/events endpoints and records created,
+// updated and deleted events
+type fakeGraph struct {
+	mu        sync.Mutex
+	created   []graphEvent
+	updated   []string
+	deleted   []string
+	authToken string
+	status    int
+}
+
+func (f *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	eventID := strings.TrimPrefix(r.URL.Path, "/me/events/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/me/events":
+	case (r.Method == http.MethodPatch || r.Method == http.MethodDelete) &&
+		eventID != r.URL.Path:
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+f.authToken {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"code":"InvalidAuthenticationToken","message":"bad token"}}`)
+		return
+	}
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		fmt.Fprint(w, `{"error":{"code":"ErrorInternalServerError","message":"boom"}}`)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodDelete {
+		if eventID == "gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.deleted = append(f.deleted, eventID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event graphEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		if eventID == "gone" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"ErrorItemNotFound","message":"gone"}}`)
+			return
+		}
+		f.updated = append(f.updated, eventID)
+		event.ID = eventID
+		_ = json.NewEncoder(w).Encode(event)
+		return
+	}
+
+	event.ID = fmt.Sprintf("evt%d", len(f.created))
+	f.created = append(f.created, event)
+
+	event.WebLink = "https://outlook.example/" + event.ID
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(event)
+}
+
+// point graph calls at server for the duration of a test
+func useFakeGraph(t *testing.T, fake *fakeGraph) *httptest.Server {
+	server := httptest.NewServer(fake)
+	oldBase := graphBaseURL
+	graphBaseURL = server.URL
+	t.Cleanup(func() {
+		graphBaseURL = oldBase
+		server.Close()
+	})
+
+	return server
+}
+
+func TestBuildGraphEvent(t *testing.T) {
+	start := time.Date(2019, 1, 1, 20, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	cases := []struct {
+		name    string
+		in      BasicEvent
+		want    graphEvent
+		wantErr bool
+	}{
+		{
+			name: "converts to UTC",
+			in: BasicEvent{
+				Summary:     "A: \"B\"",
+				Description: "desc",
+				Start:       start,
+				End:         start.Add(30 * time.Minute),
+			},
+			want: graphEvent{
+				Subject: "A: \"B\"",
+				Body:    graphItemBody{ContentType: "text", Content: "desc"},
+				Start:   graphDateTime{DateTime: "2019-01-02T01:00:00", TimeZone: "UTC"},
+				End:     graphDateTime{DateTime: "2019-01-02T01:30:00", TimeZone: "UTC"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "where to watch",
+			in: BasicEvent{
+				Summary:  "A: \"B\"",
+				Location: "Netflix (https://www.netflix.com)",
+				Start:    start,
+				End:      start.Add(30 * time.Minute),
+			},
+			want: graphEvent{
+				Subject:  "A: \"B\"",
+				Body:     graphItemBody{ContentType: "text"},
+				Start:    graphDateTime{DateTime: "2019-01-02T01:00:00", TimeZone: "UTC"},
+				End:      graphDateTime{DateTime: "2019-01-02T01:30:00", TimeZone: "UTC"},
+				Location: graphLocation{DisplayName: "Netflix (https://www.netflix.com)"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "all day",
+			in: BasicEvent{
+				Summary: "A: \"B\"",
+				Start:   time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:     time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC),
+				AllDay:  true,
+			},
+			want: graphEvent{
+				Subject:  "A: \"B\"",
+				Body:     graphItemBody{ContentType: "text"},
+				Start:    graphDateTime{DateTime: "2019-01-01T00:00:00", TimeZone: "UTC"},
+				End:      graphDateTime{DateTime: "2019-01-02T00:00:00", TimeZone: "UTC"},
+				IsAllDay: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "no summary",
+			in:      BasicEvent{Start: start, End: start},
+			want:    graphEvent{},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := buildGraphEvent(c.in)
+		gotErr := (err != nil)
+
+		if gotErr != c.wantErr {
+			t.Errorf("incorrect output error for '%s': expected '%t', got '%t'",
+				c.name, c.wantErr, gotErr)
+		}
+
+		if got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%+v', got '%+v'",
+				c.name, c.want, got)
+		}
+	}
+}
+
+func TestCreateOutlookEvent(t *testing.T) {
+	event := BasicEvent{
+		Summary: "A: \"B\"",
+		Start:   time.Date(2119, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:     time.Date(2119, 1, 1, 0, 30, 0, 0, time.UTC),
+	}
+	cases := []struct {
+		name    string
+		token   string
+		status  int
+		wantErr bool
+	}{
+		{name: "created", token: "good", wantErr: false},
+		{name: "bad token", token: "bad", wantErr: true},
+		{name: "server error", token: "good", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, c := range cases {
+		fake := &fakeGraph{authToken: "good", status: c.status}
+		useFakeGraph(t, fake)
+		client := oauth2.NewClient(context.Background(),
+			oauth2.StaticTokenSource(&oauth2.Token{AccessToken: c.token}))
+
+		_, err := createOutlookEvent(context.Background(), event, client)
+		gotErr := (err != nil)
+
+		if gotErr != c.wantErr {
+			t.Errorf("incorrect output error for '%s': expected '%t', got '%t' (%v)",
+				c.name, c.wantErr, gotErr, err)
+		}
+	}
+}
+
+func TestOutlookLoginAndAddEvents(t *testing.T) {
+	fake := &fakeGraph{authToken: "graph-access"}
+	useFakeGraph(t, fake)
+
+	// emulate the Azure AD token endpoint for the code exchange
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("code") != "abc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"graph-access","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer tokenServer.Close()
+
+	oldEndpoint := outlookOauthConfig.Endpoint
+	outlookOauthConfig.Endpoint = oauth2.Endpoint{
+		AuthURL:  tokenServer.URL + "/authorize",
+		TokenURL: tokenServer.URL + "/token",
+	}
+	defer func() { outlookOauthConfig.Endpoint = oldEndpoint }()
+
+	// login redirects to the consent page, without a session until the
+	// login finishes
+	w := httptest.NewRecorder()
+	HandleOutlookLogin(w, httptest.NewRequest(http.MethodGet, "/OutlookLogin", nil))
+	resp := w.Result()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("incorrect login status: expected '%d', got '%d'",
+			http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginCookies := resp.Cookies()
+	if len(loginCookies) != 1 || loginCookies[0].Name != oauthNonceCookieName {
+		t.Fatalf("expected only a nonce cookie before the callback, got '%+v'", loginCookies)
+	}
+	callback := fmt.Sprintf("/OutlookCallback?state=%s&code=abc",
+		location.Query().Get("state"))
+
+	// another browser opening the callback isn't logged in
+	w = httptest.NewRecorder()
+	HandleOutlookCallback(w, httptest.NewRequest(http.MethodGet, callback, nil))
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			t.Fatalf("did not expect a session without the nonce cookie")
+		}
+	}
+
+	// so log in again, and the callback exchanges the code, stores the token
+	// and starts a session
+	w = httptest.NewRecorder()
+	HandleOutlookLogin(w, httptest.NewRequest(http.MethodGet, "/OutlookLogin", nil))
+	resp = w.Result()
+	if location, err = url.Parse(resp.Header.Get("Location")); err != nil {
+		t.Fatal(err)
+	}
+	callback = fmt.Sprintf("/OutlookCallback?state=%s&code=abc",
+		location.Query().Get("state"))
+	r := httptest.NewRequest(http.MethodGet, callback, nil)
+	r.AddCookie(resp.Cookies()[0])
+	w = httptest.NewRecorder()
+	HandleOutlookCallback(w, r)
+	var session *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookieName {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatalf("expected a '%s' cookie, got '%+v'", sessionCookieName, w.Result().Cookies())
+	}
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(session)
+	userID, ok := UserFromRequest(r)
+	if !ok || userID == session.Value {
+		t.Fatalf("expected the session to resolve to a user, got '%s' (%t)", userID, ok)
+	}
+	if ok, _ := HasToken(userID, ProviderOutlook); !ok {
+		t.Fatalf("expected outlook token for user after callback")
+	}
+	if ok, _ := HasToken(userID, ProviderGoogle); ok {
+		t.Errorf("did not expect a google token for user")
+	}
+
+	events := []BasicEvent{
+		{Summary: "A: \"B\"", Start: time.Now(), End: time.Now().Add(time.Hour)},
+		{Summary: "A: \"C\"", Start: time.Now(), End: time.Now().Add(time.Hour)},
+	}
+	result := outlookSink{}.AddEvents(context.Background(), userID, events)
+	if result.Created != len(events) || result.Failed != 0 {
+		t.Errorf("incorrect result: expected '%d' created, got '%+v'", len(events), result)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.created) != len(events) {
+		t.Errorf("incorrect number of created events: expected '%d', got '%d'",
+			len(events), len(fake.created))
+	}
+}
+
+func TestOutlookUpdateAndDeleteEvents(t *testing.T) {
+	oldEvents, oldTokens := syncedEvents, tokens
+	syncedEvents, tokens = newMemoryEventStore(), newMemoryTokenStore()
+	defer func() { syncedEvents, tokens = oldEvents, oldTokens }()
+
+	fake := &fakeGraph{authToken: "good"}
+	useFakeGraph(t, fake)
+	if err := tokens.SetToken("u1", ProviderOutlook, oauth2.Token{AccessToken: "good"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ids are escaped in the path
+	setSyncedEventID("u1", ProviderOutlook, "A/S01E01", "evt?old")
+	setSyncedEventID("u1", ProviderOutlook, "A/S01E02", "gone")
+	start := time.Now().Add(time.Hour)
+	events := []BasicEvent{
+		{Key: "A/S01E01", Summary: "A: \"B\"", Start: start, End: start.Add(time.Hour)},
+		{Key: "A/S01E02", Summary: "A: \"C\"", Start: start, End: start.Add(time.Hour)},
+	}
+
+	result := outlookSink{}.AddEvents(context.Background(), "u1", events)
+	// the event the user deleted is created again
+	if result.Updated != 1 || result.Created != 1 || result.Failed != 0 {
+		t.Errorf("incorrect add result: got '%+v'", result)
+	}
+	if id, _, _ := getSyncedEventID("u1", ProviderOutlook, "A/S01E02"); id != "evt0" {
+		t.Errorf("incorrect event for recreated episode: expected 'evt0', got '%s'", id)
+	}
+
+	setSyncedEventID("u1", ProviderOutlook, "A/S01E02", "gone")
+	result = outlookSink{}.DeleteEvents(context.Background(), "u1", []string{"A/S01E01", "A/S01E02"})
+	if result.Deleted != 2 || result.Failed != 0 {
+		t.Errorf("incorrect delete result: got '%+v'", result)
+	}
+	if _, ok, _ := getSyncedEventID("u1", ProviderOutlook, "A/S01E01"); ok {
+		t.Errorf("expected deleted event to be forgotten")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.updated) != 1 || len(fake.deleted) != 1 || fake.deleted[0] != "evt?old" {
+		t.Errorf("incorrect graph calls: updated '%v', deleted '%v'", fake.updated, fake.deleted)
+	}
+}
@@ -0,0 +1,145 @@
+// Plans and previews calendar writes without calling the provider
+
+package gcalwrapper
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/swayne275/gerrors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+// what writing an event would do
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionSkip   = "skip"
+)
+
+// calendar each provider's events are written to
+var targetCalendars = map[string]string{
+	ProviderGoogle:  "primary",
+	ProviderOutlook: "default",
+}
+
+// providerEvents build the body each provider is sent for an event
+var providerEvents = map[string]func(BasicEvent) (interface{}, error){
+	ProviderGoogle: func(event BasicEvent) (interface{}, error) {
+		return buildCalendarEvent(event)
+	},
+	ProviderOutlook: func(event BasicEvent) (interface{}, error) {
+		return buildGraphEvent(event)
+	},
+}
+
+// PreviewEvent is an event exactly as it would be written to a calendar
+type PreviewEvent struct {
+	Key         string `json:"key"`
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+	// Event is the body sent to the provider, with its start, end and
+	// reminders. Missing for events that would be skipped as invalid
+	Event    interface{} `json:"event,omitempty"`
+	Provider string      `json:"provider"`
+	Calendar string      `json:"calendar"`
+	Action   string      `json:"action"`
+	Reason   string      `json:"reason,omitempty"`
+}
+
+// plannedEvent is an event along with what writing it would do
+type plannedEvent struct {
+	event  BasicEvent
+	action string
+	reason string
+}
+
+// PreviewEpisodesForCalendar renders episodes as they would be written to
+// the user's calendar with provider, without calling the provider. userID
+// may be empty for users that haven't logged in yet
+func PreviewEpisodesForCalendar(userID, provider string,
+	episodes tvshowdata.Episodes) ([]PreviewEvent, error) {
+	calendarName, ok := targetCalendars[provider]
+	buildEvent, hasBuilder := providerEvents[provider]
+	if !ok || !hasBuilder {
+		return nil, gerrors.New(fmt.Sprintf("Unknown calendar provider '%s'", provider))
+	}
+
+	plan, err := planEpisodes(userID, provider, episodes, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make([]PreviewEvent, 0, len(plan))
+	for _, planned := range plan {
+		preview := PreviewEvent{
+			Key:         planned.event.Key,
+			Summary:     planned.event.Summary,
+			Description: planned.event.Description,
+			Provider:    provider,
+			Calendar:    calendarName,
+			Action:      planned.action,
+			Reason:      planned.reason,
+		}
+
+		if event, err := buildEvent(planned.event); err == nil {
+			preview.Event = event
+		}
+
+		previews = append(previews, preview)
+	}
+
+	return previews, nil
+}
+
+// planEpisodes formats each episode and decides what writing it would do:
+// skip invalid events, episodes without an air date, events that already
+// ended and repeats of an earlier episode, update events saved before, and create the rest.
+// Errors looking up what was saved fail the whole plan, rather than creating duplicates
+func planEpisodes(userID, provider string, episodes tvshowdata.Episodes,
+	now time.Time) ([]plannedEvent, error) {
+	planned := make([]plannedEvent, 0, len(episodes.Episodes))
+	seen := make(map[string]bool)
+	templates, err := templatesForUser(userID)
+	if err != nil {
+		return nil, gerrors.Wrapf(err, "Error in planEpisodes()")
+	}
+
+	list := episodes.Episodes
+	if episodeEnricher != nil {
+		list = episodeEnricher(list)
+	}
+	for _, ep := range list {
+		event := formatEpisodeWithTemplates(ep, templates)
+		plan := plannedEvent{event: event, action: ActionCreate}
+
+		_, err := buildCalendarEvent(event)
+		switch {
+		case err != nil:
+			plan.action, plan.reason = ActionSkip, err.Error()
+		case ep.AirDatePrecision == tvshowdata.PrecisionUnknown:
+			plan.action, plan.reason = ActionSkip, "air date not announced"
+		case seen[event.Key]:
+			plan.action, plan.reason = ActionSkip, "repeats an earlier episode"
+		case event.End.Before(now):
+			plan.action, plan.reason = ActionSkip, "already aired"
+		case userID != "":
+			_, saved, err := getSyncedEventID(userID, provider, event.Key)
+			if err == nil && !saved {
+				// saved before episodes had provider IDs, and moved on write
+				_, saved, err = getSyncedEventID(userID, provider, legacyEpisodeKey(ep))
+			}
+			if err != nil {
+				return nil, gerrors.Wrapf(err, "Error in planEpisodes()")
+			}
+			if saved {
+				plan.action = ActionUpdate
+			}
+		}
+
+		seen[event.Key] = true
+		planned = append(planned, plan)
+	}
+
+	return planned, nil
+}
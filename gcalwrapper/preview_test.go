@@ -0,0 +1,204 @@
+package gcalwrapper
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/net/context"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestPlanEpisodes(t *testing.T) {
+	oldStore := syncedEvents
+	syncedEvents = newMemoryEventStore()
+	defer func() { syncedEvents = oldStore }()
+
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	episode := func(number int64, airDate time.Time, title string) tvshowdata.Episode {
+		return tvshowdata.Episode{Season: 1, Episode: number, Title: title,
+			AirDate: tvshowdata.Time{Time: airDate}, RuntimeMinutes: 30, ShowName: "A"}
+	}
+	future := now.Add(24 * time.Hour)
+	unannounced := episode(4, time.Time{}, "unannounced")
+	unannounced.AirDatePrecision = tvshowdata.PrecisionUnknown
+
+	setSyncedEventID("u1", ProviderGoogle, "A/S01E02", "evt2")
+	episodes := tvshowdata.Episodes{Episodes: []tvshowdata.Episode{
+		episode(1, future, "new"),
+		episode(2, future, "saved before"),
+		episode(3, now.Add(-24*time.Hour), "aired"),
+		episode(1, future, "repeat"),
+		unannounced,
+	}}
+
+	cases := []struct {
+		userID string
+		want   []string
+	}{
+		{"u1", []string{ActionCreate, ActionUpdate, ActionSkip, ActionSkip, ActionSkip}},
+		// anonymous users have nothing to update
+		{"", []string{ActionCreate, ActionCreate, ActionSkip, ActionSkip, ActionSkip}},
+	}
+
+	for _, c := range cases {
+		got, err := planEpisodes(c.userID, ProviderGoogle, episodes, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(c.want) {
+			t.Fatalf("incorrect number of plans for '%s': expected '%d', got '%d'",
+				c.userID, len(c.want), len(got))
+		}
+		for idx, want := range c.want {
+			if got[idx].action != want {
+				t.Errorf("incorrect action for '%s' episode %d: expected '%s', got '%s'",
+					c.userID, idx, want, got[idx].action)
+			}
+		}
+	}
+}
+
+func TestPreviewEpisodesForCalendar(t *testing.T) {
+	airDate := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	episodes := tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{
+		Season: 1, Episode: 1, Title: "B", ShowName: "A", RuntimeMinutes: 30,
+		AirDate: tvshowdata.Time{Time: airDate},
+	}}}
+
+	got, err := PreviewEpisodesForCalendar("", ProviderGoogle, episodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("incorrect number of previews: expected '1', got '%d'", len(got))
+	}
+
+	preview := got[0]
+	if preview.Summary != "A: \"B\"" || preview.Action != ActionCreate ||
+		preview.Calendar != "primary" {
+		t.Errorf("incorrect preview: got '%+v'", preview)
+	}
+	gcalEvent, ok := preview.Event.(calendar.Event)
+	if !ok {
+		t.Fatalf("incorrect event: expected a Google event, got '%+v'", preview.Event)
+	}
+	if gcalEvent.Start.DateTime != airDate.Format(time.RFC3339) || gcalEvent.Start.TimeZone != "UTC" {
+		t.Errorf("incorrect start: got '%+v'", gcalEvent.Start)
+	}
+	if gcalEvent.Reminders == nil || !gcalEvent.Reminders.UseDefault {
+		t.Errorf("expected default reminders, got '%+v'", gcalEvent.Reminders)
+	}
+
+	// Outlook previews show what Graph is sent
+	got, err = PreviewEpisodesForCalendar("", ProviderOutlook, episodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, ok := got[0].Event.(graphEvent)
+	if !ok || got[0].Calendar != "default" {
+		t.Fatalf("incorrect preview: expected a Graph event, got '%+v'", got[0])
+	}
+	if graph.Subject != preview.Summary ||
+		graph.Start.DateTime != airDate.Format(graphTimeFormat) || graph.Start.TimeZone != "UTC" {
+		t.Errorf("incorrect Graph event: got '%+v'", graph)
+	}
+
+	if _, err := PreviewEpisodesForCalendar("", "junk", episodes); err == nil {
+		t.Errorf("expected error for unknown provider")
+	}
+}
+
+func TestAddEpisodesFollowsPlan(t *testing.T) {
+	fake := &fakeSink{}
+	useFakeSink(t, fake)
+
+	episodes := futureEpisodes(2)
+	// a repeat of the first new episode
+	episodes.Episodes = append(episodes.Episodes, episodes.Episodes[1])
+
+	preview, err := PreviewEpisodesForCalendar("", ProviderGoogle, episodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := AddEpisodesToCalendar(context.Background(), "u1", "fake", episodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only what the plan creates or updates reaches the calendar
+	var want []string
+	for idx, planned := range preview {
+		if planned.Action != ActionSkip {
+			want = append(want, planned.Key)
+		} else if result.Events[idx].Status != EventSkipped {
+			t.Errorf("incorrect status for '%s': expected '%s', got '%s'",
+				planned.Key, EventSkipped, result.Events[idx].Status)
+		}
+	}
+	if fmt.Sprint(fake.added) != fmt.Sprint(want) {
+		t.Errorf("incorrect events written: expected '%v', got '%v'", want, fake.added)
+	}
+	if result.Created != 2 || result.Skipped != 2 {
+		t.Errorf("incorrect result: expected 2 created and 2 skipped, got '%+v'", result)
+	}
+}
+
+// brokenEventStore fails every lookup
+type brokenEventStore struct{ *memoryEventStore }
+
+func (brokenEventStore) GetEventID(userID, provider, key string) (string, bool, error) {
+	return "", false, errors.New("store unavailable")
+}
+
+func TestPlanEpisodesLookupError(t *testing.T) {
+	oldStore := syncedEvents
+	syncedEvents = brokenEventStore{newMemoryEventStore()}
+	defer func() { syncedEvents = oldStore }()
+
+	episodes := tvshowdata.Episodes{Episodes: []tvshowdata.Episode{{Season: 1, Episode: 1,
+		Title: "B", ShowName: "A", RuntimeMinutes: 30,
+		AirDate: tvshowdata.Time{Time: time.Now().Add(24 * time.Hour)}}}}
+
+	// a failed lookup mustn't be planned as a create, duplicating the event
+	if plan, err := planEpisodes("u1", ProviderGoogle, episodes, time.Now()); err == nil {
+		t.Errorf("expected an error, got '%+v'", plan)
+	}
+	if plan, err := planEpisodes("", ProviderGoogle, episodes, time.Now()); err != nil || len(plan) != 1 {
+		t.Errorf("incorrect plan without a user: got '%+v' (%v)", plan, err)
+	}
+}
+
+func TestAdoptLegacyKeys(t *testing.T) {
+	oldStore := syncedEvents
+	syncedEvents = newMemoryEventStore()
+	defer func() { syncedEvents = oldStore }()
+
+	episode := tvshowdata.Episode{Season: 1, Episode: 2, Title: "B", ShowName: "A", RuntimeMinutes: 30,
+		AirDate: tvshowdata.Time{Time: time.Now().Add(24 * time.Hour)}}
+	episode.SetEpisodateIDs(7)
+	if got := EpisodeKey(episode); got != "episodate:7/S01E02" {
+		t.Errorf("incorrect key: expected 'episodate:7/S01E02', got '%s'", got)
+	}
+
+	// synced before episodes had provider IDs
+	setSyncedEventID("u1", ProviderGoogle, "A/S01E02", "evt")
+	episodes := tvshowdata.Episodes{Episodes: []tvshowdata.Episode{episode}}
+	if plan, err := planEpisodes("u1", ProviderGoogle, episodes, time.Now()); err != nil ||
+		plan[0].action != ActionUpdate {
+		t.Errorf("expected the legacy event to be updated, got '%+v' (%v)", plan, err)
+	}
+
+	if err := adoptLegacyKeys("u1", ProviderGoogle, episodes.Episodes); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok, _ := getSyncedEventID("u1", ProviderGoogle, EpisodeKey(episode)); !ok || id != "evt" {
+		t.Errorf("incorrect event for the new key: expected 'evt', got '%s'", id)
+	}
+	if _, ok, _ := getSyncedEventID("u1", ProviderGoogle, "A/S01E02"); ok {
+		t.Errorf("expected the legacy key to be dropped")
+	}
+}
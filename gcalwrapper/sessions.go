@@ -0,0 +1,137 @@
+// Login sessions, mapping the session cookie to a showCal user
+
+package gcalwrapper
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/swayne275/gerrors"
+)
+
+const (
+	// cookie holding a session ID, never the user ID itself
+	sessionCookieName = "showcal_session"
+	// how long a login lasts before the user has to log in again
+	sessionTTL = 30 * 24 * time.Hour
+)
+
+// SessionStore maps sessions to showCal users. Sessions are stored by a
+// hash of their ID, so the store can't be used to log in as anyone
+type SessionStore interface {
+	// GetSession returns the user for an unexpired session
+	GetSession(key string) (string, bool, error)
+	SetSession(key, userID string, expires time.Time) error
+	DeleteSession(key string) error
+}
+
+// memorySessionStore is the default, process-local SessionStore
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	userID  string
+	expires time.Time
+}
+
+var sessions SessionStore = newMemorySessionStore()
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// GetSession returns the user for key, if the session hasn't expired
+func (s *memorySessionStore) GetSession(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[key]
+	if !ok || time.Now().After(session.expires) {
+		return "", false, nil
+	}
+
+	return session.userID, true, nil
+}
+
+// SetSession saves the session for userID, dropping expired ones
+func (s *memorySessionStore) SetSession(key, userID string, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, session := range s.sessions {
+		if now.After(session.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[key] = memorySession{userID: userID, expires: expires}
+	return nil
+}
+
+// DeleteSession forgets the session
+func (s *memorySessionStore) DeleteSession(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, key)
+	return nil
+}
+
+// SetSessionStore replaces the default in-memory session storage
+func SetSessionStore(store SessionStore) {
+	sessions = store
+}
+
+// UserFromRequest returns the showCal user logged in with r's session, if
+// there is one
+func UserFromRequest(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+
+	userID, ok, err := sessions.GetSession(sessionKey(cookie.Value))
+	if err != nil {
+		fmt.Println("error in UserFromRequest()", err)
+		return "", false
+	}
+
+	return userID, ok
+}
+
+// StartSession logs userID in, setting a cookie with a new random session ID
+func StartSession(w http.ResponseWriter, userID string) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return gerrors.Wrapf(err, "Error in StartSession()")
+	}
+	sessionID := hex.EncodeToString(buf)
+
+	expires := time.Now().Add(sessionTTL)
+	if err := sessions.SetSession(sessionKey(sessionID), userID, expires); err != nil {
+		return gerrors.Wrapf(err, "Error in StartSession()")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// sessionKey is what a session is stored as
+func sessionKey(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,69 @@
+package gcalwrapper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUserFromRequest(t *testing.T) {
+	oldSessions := sessions
+	sessions = newMemorySessionStore()
+	defer func() { sessions = oldSessions }()
+
+	w := httptest.NewRecorder()
+	if err := StartSession(w, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == "u1" || !cookies[0].HttpOnly {
+		t.Fatalf("incorrect session cookie: got '%+v'", cookies)
+	}
+
+	cases := []struct {
+		name   string
+		cookie *http.Cookie
+		want   string
+		wantOk bool
+	}{
+		{"no cookie", nil, "", false},
+		{"session", cookies[0], "u1", true},
+		// the cookie can't name a user directly
+		{"user id", &http.Cookie{Name: sessionCookieName, Value: "u1"}, "", false},
+		{"old user cookie", &http.Cookie{Name: "showcal_user", Value: "u1"}, "", false},
+		{"unknown session", &http.Cookie{Name: sessionCookieName, Value: "random"}, "", false},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.cookie != nil {
+			r.AddCookie(c.cookie)
+		}
+
+		userID, ok := UserFromRequest(r)
+		if ok != c.wantOk || userID != c.want {
+			t.Errorf("incorrect output for '%s': expected '%s' (%t), got '%s' (%t)",
+				c.name, c.want, c.wantOk, userID, ok)
+		}
+	}
+}
+
+func TestMemorySessionStoreExpires(t *testing.T) {
+	store := newMemorySessionStore()
+	if err := store.SetSession("old", "u1", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := store.GetSession("old"); ok {
+		t.Errorf("expected an expired session to be rejected")
+	}
+
+	store.SetSession("new", "u1", time.Now().Add(time.Minute))
+	if _, ok := store.sessions["old"]; ok {
+		t.Errorf("expected expired sessions to be dropped")
+	}
+	store.DeleteSession("new")
+	if _, ok, _ := store.GetSession("new"); ok {
+		t.Errorf("expected a deleted session to be rejected")
+	}
+}
@@ -0,0 +1,129 @@
+// Tracks which calendar event was created for each episode
+
+package gcalwrapper
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+// EventStore maps a BasicEvent Key to the calendar event created for it,
+// per user and provider, so adding an episode again updates the old event
+type EventStore interface {
+	GetEventID(userID, provider, key string) (string, bool, error)
+	SetEventID(userID, provider, key, eventID string) error
+	DeleteEventID(userID, provider, key string) error
+}
+
+// memoryEventStore is the default, process-local EventStore
+type memoryEventStore struct {
+	mu  sync.RWMutex
+	ids map[string]string
+}
+
+var syncedEvents EventStore = newMemoryEventStore()
+
+func newMemoryEventStore() *memoryEventStore {
+	return &memoryEventStore{ids: make(map[string]string)}
+}
+
+// GetEventID returns the calendar event ID stored for key, if any
+func (s *memoryEventStore) GetEventID(userID, provider, key string) (string, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.ids[userID+"/"+provider+"/"+key]
+	return id, ok, nil
+}
+
+// SetEventID records the calendar event ID created for key
+func (s *memoryEventStore) SetEventID(userID, provider, key, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ids[userID+"/"+provider+"/"+key] = eventID
+	return nil
+}
+
+// DeleteEventID forgets the calendar event for key
+func (s *memoryEventStore) DeleteEventID(userID, provider, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ids, userID+"/"+provider+"/"+key)
+	return nil
+}
+
+// SetEventStore replaces the default in-memory episode to event mapping
+func SetEventStore(store EventStore) {
+	syncedEvents = store
+}
+
+// look up the event saved for key, ignoring events without a key
+func getSyncedEventID(userID, provider, key string) (string, bool, error) {
+	if key == "" {
+		return "", false, nil
+	}
+
+	return syncedEvents.GetEventID(userID, provider, key)
+}
+
+// remember the event saved for key, ignoring events without a key
+func setSyncedEventID(userID, provider, key, eventID string) {
+	if key == "" || eventID == "" {
+		return
+	}
+
+	if err := syncedEvents.SetEventID(userID, provider, key, eventID); err != nil {
+		fmt.Println("setSyncedEventID():", err)
+	}
+}
+
+// adoptLegacyKeys moves events synced under an episode's legacy key to its
+// current key, so episodes saved before they had provider IDs are updated
+// rather than added again
+func adoptLegacyKeys(userID, provider string, episodes []tvshowdata.Episode) error {
+	for _, episode := range episodes {
+		key, legacy := EpisodeKey(episode), legacyEpisodeKey(episode)
+		if key == legacy {
+			continue
+		}
+
+		_, saved, err := getSyncedEventID(userID, provider, key)
+		if err != nil {
+			return err
+		}
+		if saved {
+			continue
+		}
+		eventID, saved, err := getSyncedEventID(userID, provider, legacy)
+		if err != nil {
+			return err
+		}
+		if !saved {
+			continue
+		}
+
+		if err := syncedEvents.SetEventID(userID, provider, key, eventID); err != nil {
+			return err
+		}
+		if err := syncedEvents.DeleteEventID(userID, provider, legacy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// forget the event saved for key
+func deleteSyncedEventID(userID, provider, key string) {
+	if key == "" {
+		return
+	}
+
+	if err := syncedEvents.DeleteEventID(userID, provider, key); err != nil {
+		fmt.Println("deleteSyncedEventID():", err)
+	}
+}
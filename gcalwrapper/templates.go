@@ -0,0 +1,414 @@
+// User-definable text/template formats for event summaries and descriptions
+
+package gcalwrapper
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/swayne275/gerrors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+const (
+	// formats matching what showCal has always written
+	defaultSummaryTemplate     = `{{.ShowName}}: "{{.Title}}"`
+	defaultDescriptionTemplate = `{{.ShowName}}: "{{.Title}}"` + "\n{{if .Special}}Special {{.Episode}}{{else}}Season {{.Season}}, Episode {{.Episode}}{{end}}" +
+		"{{if .SlotParts}} (part {{.SlotPart}} of {{.SlotParts}}){{end}}" +
+		"{{with .Synopsis}}\n\n{{.}}{{end}}{{with or .EpisodeLink .Link}}\n\n{{.}}{{end}}"
+
+	// keep templates (and what they render) to something a calendar accepts
+	maxTemplateLength = 2000
+	// templates are user input, so rendering one can't take long
+	renderTimeout = 100 * time.Millisecond
+)
+
+// errRenderTooLong stops a render once it passes maxTemplateLength
+var errRenderTooLong = gerrors.New("rendered template is too long")
+
+// printf widths and precisions, to cap how much one call can pad
+var printfVerb = regexp.MustCompile(`%%|%[-+# 0]*(?:\[\d+\])?(\*|\d+)?(?:\.(?:\[\d+\])?(\*|\d+))?`)
+
+// limitedWriter fails writes past limit, so a render stops as soon as it
+// is too long rather than after building all of it
+type limitedWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		return 0, errRenderTooLong
+	}
+	return w.buf.Write(p)
+}
+
+// EventTemplates are the text/template formats for an event's summary and
+// description. An empty field falls back to the server-wide default
+type EventTemplates struct {
+	Summary     string `json:"summary"`
+	Description string `json:"description"`
+}
+
+// TemplateData holds the fields templates can reference
+type TemplateData struct {
+	ShowName string
+	Title    string
+	Season   int64
+	Episode  int64
+	// SxxEyy is the zero padded season and episode, ie S03E07
+	SxxEyy  string
+	Network string
+	// WhereToWatch is the network or streaming service, and Streaming is
+	// set for the latter
+	WhereToWatch string
+	Streaming    bool
+	Runtime      int64
+	AirDate      time.Time
+	// DateOnly is set when only the day of AirDate is known
+	DateOnly bool
+	// Special is set for season 0 episodes
+	Special bool
+	// SlotPart is the part of a shared slot, from 1 of SlotParts, or 0
+	SlotPart  int
+	SlotParts int
+	// Link is the show's page
+	Link string
+	// the rest are empty unless the episode was enriched
+	Synopsis    string
+	EpisodeLink string
+	IMDbLink    string
+	ImageURL    string
+}
+
+// TemplateStore holds each user's template overrides
+type TemplateStore interface {
+	GetTemplates(userID string) (EventTemplates, bool, error)
+	SetTemplates(userID string, templates EventTemplates) error
+	DeleteTemplates(userID string) error
+}
+
+// memoryTemplateStore is the default, process-local TemplateStore
+type memoryTemplateStore struct {
+	mu        sync.RWMutex
+	templates map[string]EventTemplates
+}
+
+var (
+	userTemplates TemplateStore = newMemoryTemplateStore()
+
+	// fills in episode details before formatting, if set
+	episodeEnricher func([]tvshowdata.Episode) []tvshowdata.Episode
+
+	defaultTemplates = EventTemplates{
+		Summary:     defaultSummaryTemplate,
+		Description: defaultDescriptionTemplate,
+	}
+	defaultTemplatesMu sync.RWMutex
+
+	// rendered when validating and previewing templates without an episode
+	sampleEpisode = tvshowdata.Episode{
+		Season:           3,
+		Episode:          7,
+		Title:            "Pilot",
+		AirDate:          tvshowdata.Time{Time: time.Date(2119, 9, 3, 2, 0, 0, 0, time.UTC)},
+		AirDatePrecision: tvshowdata.PrecisionTime,
+		RuntimeMinutes:   30,
+		ShowName:         "Sample Show",
+		Network:          "Sample Network",
+		WhereToWatch:     tvshowdata.Channel{Name: "Sample Network"},
+		ShowURL:          "https://www.episodate.com/tv-show/sample-show",
+		Synopsis:         "The sample characters meet for the first time.",
+		ImageURL:         "https://static.tvmaze.com/uploads/images/original_untouched/0/1.jpg",
+		Links: tvshowdata.EpisodeLinks{
+			Episode: "https://www.tvmaze.com/episodes/1/sample-show-3x07-pilot",
+			IMDbID:  "tt0000001",
+		},
+	}
+)
+
+func newMemoryTemplateStore() *memoryTemplateStore {
+	return &memoryTemplateStore{templates: make(map[string]EventTemplates)}
+}
+
+// GetTemplates returns the user's template overrides, if any
+func (s *memoryTemplateStore) GetTemplates(userID string) (EventTemplates, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	templates, ok := s.templates[userID]
+	return templates, ok, nil
+}
+
+// SetTemplates replaces the user's template overrides
+func (s *memoryTemplateStore) SetTemplates(userID string, templates EventTemplates) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.templates[userID] = templates
+	return nil
+}
+
+// DeleteTemplates removes the user's template overrides
+func (s *memoryTemplateStore) DeleteTemplates(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.templates, userID)
+	return nil
+}
+
+// SetEpisodeEnricher sets what fills in episode synopses, images and links
+// before events are formatted. There is none by default
+func SetEpisodeEnricher(enricher func([]tvshowdata.Episode) []tvshowdata.Episode) {
+	episodeEnricher = enricher
+}
+
+// SetTemplateStore replaces the default in-memory template storage
+func SetTemplateStore(store TemplateStore) {
+	userTemplates = store
+}
+
+// DefaultTemplates returns the server-wide templates
+func DefaultTemplates() EventTemplates {
+	defaultTemplatesMu.RLock()
+	defer defaultTemplatesMu.RUnlock()
+
+	return defaultTemplates
+}
+
+// SetDefaultTemplates validates and replaces the server-wide templates.
+// Empty fields keep the current default
+func SetDefaultTemplates(templates EventTemplates) error {
+	merged := mergeTemplates(DefaultTemplates(), templates)
+	if err := ValidateTemplates(merged); err != nil {
+		return gerrors.Wrapf(err, "Error in SetDefaultTemplates()")
+	}
+
+	defaultTemplatesMu.Lock()
+	defer defaultTemplatesMu.Unlock()
+
+	defaultTemplates = merged
+	return nil
+}
+
+// UserTemplates returns the user's overrides, if any, and the templates
+// actually used for them after falling back to the defaults
+func UserTemplates(userID string) (EventTemplates, EventTemplates, error) {
+	overrides, _, err := userTemplates.GetTemplates(userID)
+	if err != nil {
+		return EventTemplates{}, EventTemplates{}, gerrors.Wrapf(err, "Error in UserTemplates()")
+	}
+
+	return overrides, mergeTemplates(DefaultTemplates(), overrides), nil
+}
+
+// SaveUserTemplates validates and stores the user's template overrides
+func SaveUserTemplates(userID string, templates EventTemplates) error {
+	if err := ValidateTemplates(mergeTemplates(DefaultTemplates(), templates)); err != nil {
+		return err
+	}
+
+	if err := userTemplates.SetTemplates(userID, templates); err != nil {
+		return gerrors.Wrapf(err, "Error in SaveUserTemplates()")
+	}
+
+	return nil
+}
+
+// ResetUserTemplates goes back to the server-wide templates for the user
+func ResetUserTemplates(userID string) error {
+	return userTemplates.DeleteTemplates(userID)
+}
+
+// ValidateTemplates checks that templates parse, only reference known
+// fields, and render a non-empty summary of reasonable length
+func ValidateTemplates(templates EventTemplates) error {
+	_, _, err := renderTemplates(templates, sampleEpisode)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PreviewTemplates renders templates for episode, or a sample episode if
+// episode is nil. Empty fields fall back to the server-wide default
+func PreviewTemplates(templates EventTemplates, episode *tvshowdata.Episode) (string, string, error) {
+	if episode == nil {
+		episode = &sampleEpisode
+	}
+
+	return renderTemplates(mergeTemplates(DefaultTemplates(), templates), *episode)
+}
+
+// templatesForUser returns the templates to write the user's events with
+func templatesForUser(userID string) (EventTemplates, error) {
+	if userID == "" {
+		return DefaultTemplates(), nil
+	}
+
+	_, effective, err := UserTemplates(userID)
+	return effective, err
+}
+
+// renderTemplates returns the summary and description for episode
+func renderTemplates(templates EventTemplates, episode tvshowdata.Episode) (string, string, error) {
+	data := newTemplateData(episode)
+
+	summary, err := renderTemplate("summary", templates.Summary, data)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.TrimSpace(summary) == "" {
+		return "", "", gerrors.New("summary template renders an empty summary")
+	}
+
+	description, err := renderTemplate("description", templates.Description, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return summary, description, nil
+}
+
+// renderTemplate parses and executes a single template
+func renderTemplate(name, text string, data TemplateData) (string, error) {
+	if len(text) > maxTemplateLength {
+		return "", gerrors.New(fmt.Sprintf("%s template is longer than %d characters",
+			name, maxTemplateLength))
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").
+		Funcs(template.FuncMap{"printf": limitedSprintf}).Parse(text)
+	if err != nil {
+		return "", gerrors.Wrapf(err, "invalid %s template", name)
+	}
+	if err := checkTemplateNodes(name, tmpl); err != nil {
+		return "", err
+	}
+
+	// templates can't be stopped part way, so give up waiting instead
+	out := &limitedWriter{limit: maxTemplateLength}
+	done := make(chan error, 1)
+	go func() { done <- tmpl.Execute(out, data) }()
+	select {
+	case err = <-done:
+	case <-time.After(renderTimeout):
+		return "", gerrors.New(fmt.Sprintf("%s template took too long to render", name))
+	}
+
+	if err == errRenderTooLong {
+		return "", gerrors.New(fmt.Sprintf("%s template renders more than %d characters",
+			name, maxTemplateLength))
+	}
+	if err != nil {
+		return "", gerrors.Wrapf(err, "invalid %s template", name)
+	}
+
+	return out.buf.String(), nil
+}
+
+// checkTemplateNodes rejects actions that could run for an unbounded time.
+// There is nothing in TemplateData to range over, so range only loops over
+// numbers, and template calls can recurse
+func checkTemplateNodes(name string, tmpl *template.Template) error {
+	if len(tmpl.Templates()) > 1 {
+		return gerrors.New(fmt.Sprintf("%s template can't define templates", name))
+	}
+
+	return checkNode(name, tmpl.Tree.Root)
+}
+
+func checkNode(name string, node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNode(name, child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkBranch(name, &n.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(name, &n.BranchNode)
+	case *parse.RangeNode:
+		return gerrors.New(fmt.Sprintf("%s template can't use range", name))
+	case *parse.TemplateNode:
+		return gerrors.New(fmt.Sprintf("%s template can't call templates", name))
+	}
+
+	return nil
+}
+
+func checkBranch(name string, branch *parse.BranchNode) error {
+	if err := checkNode(name, branch.List); err != nil {
+		return err
+	}
+	return checkNode(name, branch.ElseList)
+}
+
+// limitedSprintf is printf for templates, without widths or precisions
+// that would pad past maxTemplateLength
+func limitedSprintf(format string, args ...interface{}) (string, error) {
+	for _, verb := range printfVerb.FindAllStringSubmatch(format, -1) {
+		for _, size := range verb[1:] {
+			if size == "" {
+				continue
+			}
+			if n, err := strconv.Atoi(size); err != nil || n > maxTemplateLength {
+				return "", errRenderTooLong
+			}
+		}
+	}
+
+	return fmt.Sprintf(format, args...), nil
+}
+
+// mergeTemplates fills empty fields of overrides from defaults
+func mergeTemplates(defaults, overrides EventTemplates) EventTemplates {
+	merged := defaults
+	if overrides.Summary != "" {
+		merged.Summary = overrides.Summary
+	}
+	if overrides.Description != "" {
+		merged.Description = overrides.Description
+	}
+
+	return merged
+}
+
+func newTemplateData(episode tvshowdata.Episode) TemplateData {
+	return TemplateData{
+		ShowName:     episode.ShowName,
+		Title:        episode.Title,
+		Season:       episode.Season,
+		Episode:      episode.Episode,
+		SxxEyy:       fmt.Sprintf("S%02dE%02d", episode.Season, episode.Episode),
+		Network:      episode.Network,
+		WhereToWatch: episode.WhereToWatch.Name,
+		Streaming:    episode.WhereToWatch.Streaming,
+		Runtime:      episode.RuntimeMinutes,
+		AirDate:      episode.AirDate.Time,
+		DateOnly:     episode.DateOnly(),
+		Special:      episode.IsSpecial(),
+		SlotPart:     episode.SlotPart,
+		SlotParts:    episode.SlotParts,
+		Link:         episode.ShowURL,
+
+		Synopsis:    episode.Synopsis,
+		EpisodeLink: episode.Links.Episode,
+		IMDbLink:    episode.Links.IMDbURL(),
+		ImageURL:    episode.ImageURL,
+	}
+}
@@ -0,0 +1,115 @@
+package gcalwrapper
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+func TestValidateTemplates(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      EventTemplates
+		wantErr bool
+	}{
+		{"defaults", DefaultTemplates(), false},
+		{"all fields", EventTemplates{
+			Summary:     "{{.ShowName}} {{.SxxEyy}}",
+			Description: "{{.Title}} on {{.Network}}, {{.Runtime}} min {{.Link}} {{.AirDate.Year}}",
+		}, false},
+		{"bad syntax", EventTemplates{Summary: "{{.ShowName", Description: ""}, true},
+		{"unknown field", EventTemplates{Summary: "{{.Nope}}", Description: ""}, true},
+		{"empty summary", EventTemplates{Summary: "{{if false}}x{{end}}", Description: ""}, true},
+		{"too long", EventTemplates{Summary: strings.Repeat("x", maxTemplateLength+1),
+			Description: ""}, true},
+		{"printf", EventTemplates{Summary: `{{printf "%s %03d%%" .ShowName .Episode}}`}, false},
+		{"renders too long", EventTemplates{Summary: `{{printf "%1500s" "x"}}{{printf "%1500s" "x"}}`}, true},
+		{"huge printf width", EventTemplates{Summary: `{{printf "%999999999d" 1}}`}, true},
+		{"printf star width", EventTemplates{Summary: `{{printf "%*d" 999999999 1}}`}, true},
+		{"range", EventTemplates{Summary: `{{range 1000000000}}x{{end}}`}, true},
+		{"nested range", EventTemplates{Summary: `{{if true}}{{with 1}}{{range 10}}{{end}}{{end}}{{end}}x`}, true},
+		{"recursive template", EventTemplates{
+			Summary: `{{define "x"}}{{template "x" .}}{{template "x" .}}{{end}}{{template "x" .}}`}, true},
+	}
+
+	for _, c := range cases {
+		err := ValidateTemplates(c.in)
+		gotErr := (err != nil)
+
+		if gotErr != c.wantErr {
+			t.Errorf("incorrect output error for '%s': expected '%t', got '%t' (%v)",
+				c.name, c.wantErr, gotErr, err)
+		}
+	}
+}
+
+func TestUserTemplates(t *testing.T) {
+	oldStore := userTemplates
+	userTemplates = newMemoryTemplateStore()
+	defer func() { userTemplates = oldStore }()
+
+	episode := tvshowdata.Episode{Season: 3, Episode: 7, Title: "B", ShowName: "A",
+		RuntimeMinutes: 30, Network: "TBS", ShowURL: "https://example.com/a",
+		AirDate: tvshowdata.Time{Time: time.Date(2119, 1, 1, 0, 0, 0, 0, time.UTC)}}
+
+	if err := SaveUserTemplates("u1", EventTemplates{Summary: "{{.Nope}}"}); err == nil {
+		t.Errorf("expected invalid template to be rejected")
+	}
+	if err := SaveUserTemplates("u1", EventTemplates{Summary: "{{.ShowName}} {{.SxxEyy}}"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		userID          string
+		wantSummary     string
+		wantDescription string
+	}{
+		// description falls back to the default
+		{"u1", "A S03E07", "A: \"B\"\nSeason 3, Episode 7\n\nhttps://example.com/a"},
+		{"u2", "A: \"B\"", "A: \"B\"\nSeason 3, Episode 7\n\nhttps://example.com/a"},
+		{"", "A: \"B\"", "A: \"B\"\nSeason 3, Episode 7\n\nhttps://example.com/a"},
+	}
+
+	for _, c := range cases {
+		templates, err := templatesForUser(c.userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := formatEpisodeWithTemplates(episode, templates)
+
+		if got.Summary != c.wantSummary {
+			t.Errorf("incorrect summary for '%s': expected '%s', got '%s'",
+				c.userID, c.wantSummary, got.Summary)
+		}
+
+		if got.Description != c.wantDescription {
+			t.Errorf("incorrect description for '%s': expected '%s', got '%s'",
+				c.userID, c.wantDescription, got.Description)
+		}
+	}
+
+	if err := ResetUserTemplates("u1"); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := templatesForUser("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatEpisodeWithTemplates(episode, templates); got.Summary != "A: \"B\"" {
+		t.Errorf("incorrect summary after reset: expected 'A: \"B\"', got '%s'", got.Summary)
+	}
+}
+
+func TestPreviewTemplates(t *testing.T) {
+	summary, description, err := PreviewTemplates(
+		EventTemplates{Summary: "{{.ShowName}}", Description: "{{.Link}}"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary != sampleEpisode.ShowName || description != sampleEpisode.ShowURL {
+		t.Errorf("incorrect preview: got '%s' / '%s'", summary, description)
+	}
+}
@@ -0,0 +1,144 @@
+// Quota-aware throttling for Google Calendar calls
+
+package gcalwrapper
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// longest wait between retries of a throttled call
+	maxBackoff = 32 * time.Second
+	// total tries for a single call or batch item before giving up on it
+	maxCallAttempts = 5
+)
+
+var (
+	// per-user call rate, kept under Google's default per-user quota
+	userCallsPerSecond rate.Limit = 10
+	// first retry waits about this long, doubling each attempt after
+	backoffBase = time.Second
+
+	// how long a user's limiter is kept after its last use
+	limiterIdleTTL = 10 * time.Minute
+
+	// one limiter per user so a big add can't starve everyone else
+	userLimiters   = make(map[string]*userLimit)
+	userLimitersMu sync.Mutex
+	// when idle limiters were last dropped from userLimiters
+	lastLimiterSweep time.Time
+)
+
+// userLimit is a user's call rate limiter, and when it was last used
+type userLimit struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// userLimiter returns the call rate limiter for userID
+func userLimiter(userID string) *rate.Limiter {
+	userLimitersMu.Lock()
+	defer userLimitersMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(lastLimiterSweep) > limiterIdleTTL {
+		evictIdleLimiters(now)
+	}
+
+	limit, ok := userLimiters[userID]
+	if !ok {
+		// a full batch counts as maxBatchSize calls against the quota
+		limit = &userLimit{limiter: rate.NewLimiter(userCallsPerSecond, maxBatchSize)}
+		userLimiters[userID] = limit
+	}
+	limit.lastUsed = now
+
+	return limit.limiter
+}
+
+// evictIdleLimiters drops limiters unused for limiterIdleTTL. They have
+// refilled long before then, so a new limiter allows the same calls.
+// userLimitersMu must be held
+func evictIdleLimiters(now time.Time) {
+	for userID, limit := range userLimiters {
+		if now.Sub(limit.lastUsed) > limiterIdleTTL {
+			delete(userLimiters, userID)
+		}
+	}
+	lastLimiterSweep = now
+}
+
+// waitForQuota blocks until userID may make n more calendar calls, or ctx
+// is done
+func waitForQuota(ctx context.Context, userID string, n int) error {
+	return userLimiter(userID).WaitN(ctx, n)
+}
+
+// sleepContext waits for d, returning early with an error if ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isRateLimitError reports if err is Google telling us to slow down
+func isRateLimitError(err error) bool {
+	gErr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	if gErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	if gErr.Code != http.StatusForbidden {
+		return false
+	}
+
+	for _, item := range gErr.Errors {
+		if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRetryableError reports if a failed call may succeed if sent again
+func isRetryableError(err error) bool {
+	if isRateLimitError(err) {
+		return true
+	}
+
+	gErr, ok := err.(*googleapi.Error)
+	return ok && gErr.Code >= http.StatusInternalServerError
+}
+
+// backoffDelay is the wait before retry number attempt (starting at 1):
+// exponential from backoffBase, capped at maxBackoff, with random jitter
+// so throttled callers don't all come back at once
+func backoffDelay(attempt int) time.Duration {
+	delay := backoffBase
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	// wait somewhere between half and all of the delay
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,198 @@
+package gcalwrapper
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+)
+
+// drop backoff and per-user rate limits for the duration of a test
+func useFastBackoff(t *testing.T) {
+	oldBase, oldRate := backoffBase, userCallsPerSecond
+	backoffBase = time.Millisecond
+	userCallsPerSecond = rate.Inf
+
+	userLimitersMu.Lock()
+	userLimiters = make(map[string]*userLimit)
+	userLimitersMu.Unlock()
+
+	t.Cleanup(func() {
+		backoffBase, userCallsPerSecond = oldBase, oldRate
+		userLimitersMu.Lock()
+		userLimiters = make(map[string]*userLimit)
+		userLimitersMu.Unlock()
+	})
+}
+
+func TestIsRateLimitError(t *testing.T) {
+	cases := []struct {
+		name          string
+		in            error
+		wantRateLimit bool
+		wantRetry     bool
+	}{
+		{
+			name:          "429",
+			in:            &googleapi.Error{Code: http.StatusTooManyRequests},
+			wantRateLimit: true,
+			wantRetry:     true,
+		},
+		{
+			name: "403 rateLimitExceeded",
+			in: &googleapi.Error{Code: http.StatusForbidden,
+				Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}},
+			wantRateLimit: true,
+			wantRetry:     true,
+		},
+		{
+			name: "403 userRateLimitExceeded",
+			in: &googleapi.Error{Code: http.StatusForbidden,
+				Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}},
+			wantRateLimit: true,
+			wantRetry:     true,
+		},
+		{
+			name: "403 forbidden",
+			in: &googleapi.Error{Code: http.StatusForbidden,
+				Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}},
+			wantRateLimit: false,
+			wantRetry:     false,
+		},
+		{
+			name:          "503",
+			in:            &googleapi.Error{Code: http.StatusServiceUnavailable},
+			wantRateLimit: false,
+			wantRetry:     true,
+		},
+		{
+			name:          "400",
+			in:            &googleapi.Error{Code: http.StatusBadRequest},
+			wantRateLimit: false,
+			wantRetry:     false,
+		},
+		{
+			name:          "not an api error",
+			in:            errors.New("test error"),
+			wantRateLimit: false,
+			wantRetry:     false,
+		},
+	}
+
+	for _, c := range cases {
+		if got := isRateLimitError(c.in); got != c.wantRateLimit {
+			t.Errorf("incorrect rate limit output for '%s': expected '%t', got '%t'",
+				c.name, c.wantRateLimit, got)
+		}
+
+		if got := isRetryableError(c.in); got != c.wantRetry {
+			t.Errorf("incorrect retry output for '%s': expected '%t', got '%t'",
+				c.name, c.wantRetry, got)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	cases := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{1, backoffBase / 2, backoffBase},
+		{2, backoffBase, 2 * backoffBase},
+		{4, 4 * backoffBase, 8 * backoffBase},
+		{20, maxBackoff / 2, maxBackoff},
+	}
+
+	for _, c := range cases {
+		// jitter is random, so sample a few times
+		for i := 0; i < 20; i++ {
+			got := backoffDelay(c.attempt)
+			if got < c.min || got > c.max {
+				t.Errorf("incorrect output for attempt %d: expected between '%s' and '%s', got '%s'",
+					c.attempt, c.min, c.max, got)
+				break
+			}
+		}
+	}
+}
+
+func TestUserLimiterIsPerUser(t *testing.T) {
+	if userLimiter("u1") != userLimiter("u1") {
+		t.Errorf("expected the same limiter for the same user")
+	}
+	if userLimiter("u1") == userLimiter("u2") {
+		t.Errorf("expected different limiters for different users")
+	}
+}
+
+func TestUserLimiterEvictsIdle(t *testing.T) {
+	useFastBackoff(t)
+
+	userLimiter("idle")
+	userLimiter("busy")
+	userLimitersMu.Lock()
+	userLimiters["idle"].lastUsed = time.Now().Add(-2 * limiterIdleTTL)
+	lastLimiterSweep = time.Now().Add(-2 * limiterIdleTTL)
+	userLimitersMu.Unlock()
+
+	userLimiter("busy")
+
+	userLimitersMu.Lock()
+	defer userLimitersMu.Unlock()
+	if _, ok := userLimiters["idle"]; ok {
+		t.Errorf("expected the idle limiter to be evicted")
+	}
+	if _, ok := userLimiters["busy"]; !ok {
+		t.Errorf("expected the busy limiter to be kept")
+	}
+}
+
+func TestAddEventsBatchCancelled(t *testing.T) {
+	useFastBackoff(t)
+	backoffBase = time.Hour
+
+	// nothing succeeds, so the batch would back off for an hour
+	fake := &fakeBatch{respond: func(call batchCall) int {
+		return http.StatusTooManyRequests
+	}}
+	useFakeBatch(t, fake)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result := addEventsBatch(ctx, http.DefaultClient, "u1", makeEvents(2))
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected cancelling to stop the backoff, waited '%s'", elapsed)
+	}
+	if result.Failed != 2 || result.Created != 0 {
+		t.Errorf("incorrect result: expected 2 failed, got '%+v'", result)
+	}
+}
+
+func TestAddEventsBatchThrottled(t *testing.T) {
+	// every item is rate limited the first time it is seen
+	var seenMu sync.Mutex
+	seen := make(map[string]bool)
+	fake := &fakeBatch{respond: func(call batchCall) int {
+		seenMu.Lock()
+		defer seenMu.Unlock()
+		if !seen[call.summary] {
+			seen[call.summary] = true
+			return http.StatusTooManyRequests
+		}
+		return http.StatusOK
+	}}
+	useFakeBatch(t, fake)
+
+	result := addEventsBatch(context.Background(), http.DefaultClient, "u1", makeEvents(4))
+	if result.Created != 4 || result.Throttled != 4 || result.Failed != 0 {
+		t.Errorf("incorrect result: expected 4 created and throttled, got '%+v'", result)
+	}
+}
@@ -0,0 +1,235 @@
+// Per-user OAuth2 token storage shared by every calendar provider
+
+package gcalwrapper
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/swayne275/gerrors"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// ProviderGoogle is the token storage key for Google Calendar
+	ProviderGoogle = "google"
+	// ProviderOutlook is the token storage key for Microsoft Graph (Outlook)
+	ProviderOutlook = "outlook"
+
+	// how long a login has to come back through the oauth callback
+	oauthStateTTL = 10 * time.Minute
+	// cookie tying a login's oauth state to the browser that started it
+	oauthNonceCookieName = "showcal_oauth_nonce"
+)
+
+// TokenStore holds OAuth2 tokens for each user and calendar provider
+type TokenStore interface {
+	GetToken(userID, provider string) (oauth2.Token, bool, error)
+	SetToken(userID, provider string, token oauth2.Token) error
+}
+
+// memoryTokenStore is the default, process-local TokenStore
+type memoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]oauth2.Token
+}
+
+type pendingLogin struct {
+	userID string
+	// must come back in the browser's nonce cookie
+	nonce   string
+	expires time.Time
+}
+
+var (
+	tokens TokenStore = newMemoryTokenStore()
+
+	// oauth state string -> user that started the login
+	pendingLogins   = make(map[string]pendingLogin)
+	pendingLoginsMu sync.Mutex
+)
+
+func newMemoryTokenStore() *memoryTokenStore {
+	return &memoryTokenStore{tokens: make(map[string]oauth2.Token)}
+}
+
+// GetToken returns the stored token for userID with provider, if any
+func (s *memoryTokenStore) GetToken(userID, provider string) (oauth2.Token, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[userID+"/"+provider]
+	return token, ok, nil
+}
+
+// SetToken stores token for userID with provider, replacing any previous one
+func (s *memoryTokenStore) SetToken(userID, provider string, token oauth2.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[userID+"/"+provider] = token
+	return nil
+}
+
+// SetTokenStore replaces the default in-memory token storage
+func SetTokenStore(store TokenStore) {
+	tokens = store
+}
+
+// HasToken reports if the user has authorized showCal with provider
+func HasToken(userID, provider string) (bool, error) {
+	_, ok, err := tokens.GetToken(userID, provider)
+	return ok, err
+}
+
+// loginUser returns the user r is logged in as, or a new user ID to log in
+// as. The new user only gets a session once the login finishes
+func loginUser(r *http.Request) (string, error) {
+	if userID, ok := UserFromRequest(r); ok {
+		return userID, nil
+	}
+
+	userID, err := randomString()
+	if err != nil {
+		return "", gerrors.Wrapf(err, "Error in loginUser()")
+	}
+
+	return userID, nil
+}
+
+// newOAuthState returns a one-time oauth state string tied to userID, and
+// the nonce the browser finishing the login must have
+func newOAuthState(userID string) (string, string, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", "", gerrors.Wrapf(err, "Error in newOAuthState()")
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", gerrors.Wrapf(err, "Error in newOAuthState()")
+	}
+
+	pendingLoginsMu.Lock()
+	defer pendingLoginsMu.Unlock()
+
+	now := time.Now()
+	for key, login := range pendingLogins {
+		if now.After(login.expires) {
+			delete(pendingLogins, key)
+		}
+	}
+	pendingLogins[state] = pendingLogin{userID: userID, nonce: nonce,
+		expires: now.Add(oauthStateTTL)}
+
+	return state, nonce, nil
+}
+
+// consumeOAuthState returns the user who started the login for state, if
+// nonce is the one it was started with
+func consumeOAuthState(state, nonce string) (string, bool) {
+	pendingLoginsMu.Lock()
+	defer pendingLoginsMu.Unlock()
+
+	login, ok := pendingLogins[state]
+	if !ok {
+		return "", false
+	}
+	delete(pendingLogins, state)
+
+	if time.Now().After(login.expires) {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(login.nonce), []byte(nonce)) != 1 {
+		return "", false
+	}
+
+	return login.userID, true
+}
+
+// beginOAuthLogin redirects the user to config's consent page
+func beginOAuthLogin(w http.ResponseWriter, r *http.Request, config *oauth2.Config) {
+	userID, err := loginUser(r)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Unable to start login", http.StatusInternalServerError)
+		return
+	}
+
+	state, nonce, err := newOAuthState(userID)
+	if err != nil {
+		fmt.Println(err)
+		http.Error(w, "Unable to start login", http.StatusInternalServerError)
+		return
+	}
+	// lax, as the provider sends the browser back with a top level GET
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthNonceCookieName,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(oauthStateTTL / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	url := config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+// finishOAuthLogin exchanges the callback code and stores the user's token.
+// Only the browser that started the login can finish it
+func finishOAuthLogin(w http.ResponseWriter, r *http.Request, config *oauth2.Config,
+	provider string) {
+	nonce := ""
+	if cookie, err := r.Cookie(oauthNonceCookieName); err == nil {
+		nonce = cookie.Value
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthNonceCookieName, Path: "/", MaxAge: -1,
+		HttpOnly: true, SameSite: http.SameSiteLaxMode})
+
+	state := r.FormValue("state")
+	userID, ok := consumeOAuthState(state, nonce)
+	if !ok || nonce == "" {
+		fmt.Printf("invalid oauth state for %s, got '%s'\n", provider, state)
+		http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+		return
+	}
+
+	code := r.FormValue("code")
+	token, err := config.Exchange(context.Background(), code)
+	if err != nil {
+		fmt.Printf("%s oauthConf.Exchange() failed with '%s'\n", provider, err)
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	if err := tokens.SetToken(userID, provider, *token); err != nil {
+		fmt.Println("finishOAuthLogin():", err)
+		http.Error(w, "Unable to save login", http.StatusInternalServerError)
+		return
+	}
+	if current, ok := UserFromRequest(r); !ok || current != userID {
+		if err := StartSession(w, userID); err != nil {
+			fmt.Println("finishOAuthLogin():", err)
+			http.Error(w, "Unable to save login", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	fmt.Fprintf(w, "Logged in with %s", provider)
+}
+
+// get a random hex string suitable for IDs and oauth state
+func randomString() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
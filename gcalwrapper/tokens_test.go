@@ -0,0 +1,100 @@
+package gcalwrapper
+
+import (
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	store := newMemoryTokenStore()
+	if err := store.SetToken("u1", ProviderGoogle, oauth2.Token{AccessToken: "g"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		userID   string
+		provider string
+		want     string
+		wantOk   bool
+	}{
+		{"u1", ProviderGoogle, "g", true},
+		{"u1", ProviderOutlook, "", false},
+		{"u2", ProviderGoogle, "", false},
+	}
+
+	for _, c := range cases {
+		got, ok, err := store.GetToken(c.userID, c.provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok != c.wantOk {
+			t.Errorf("incorrect ok for '%s/%s': expected '%t', got '%t'",
+				c.userID, c.provider, c.wantOk, ok)
+		}
+
+		if got.AccessToken != c.want {
+			t.Errorf("incorrect output for '%s/%s': expected '%s', got '%s'",
+				c.userID, c.provider, c.want, got.AccessToken)
+		}
+	}
+}
+
+func TestOAuthState(t *testing.T) {
+	state, nonce, err := newOAuthState("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, ok := consumeOAuthState(state, nonce)
+	if !ok || userID != "u1" {
+		t.Errorf("incorrect output for first use: expected 'u1', got '%s' (%t)", userID, ok)
+	}
+
+	// states are single use
+	if _, ok := consumeOAuthState(state, nonce); ok {
+		t.Errorf("expected state to be rejected on second use")
+	}
+	if _, ok := consumeOAuthState("random", nonce); ok {
+		t.Errorf("expected unknown state to be rejected")
+	}
+
+	// another browser can't finish the login
+	state, _, err = newOAuthState("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := consumeOAuthState(state, "other"); ok {
+		t.Errorf("expected state to be rejected with another nonce")
+	}
+	if _, ok := consumeOAuthState(state, ""); ok {
+		t.Errorf("expected state to be rejected without a nonce")
+	}
+}
+
+func TestNotifyingTokenSourceSavesRefreshes(t *testing.T) {
+	oldTokens := tokens
+	tokens = newMemoryTokenStore()
+	defer func() { tokens = oldTokens }()
+
+	current := &oauth2.Token{AccessToken: "old"}
+	src := &notifyingTokenSource{userID: "u1", provider: ProviderGoogle,
+		src: oauth2.StaticTokenSource(current), saved: "old"}
+
+	if _, err := src.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := tokens.GetToken("u1", ProviderGoogle); ok {
+		t.Errorf("did not expect an unchanged token to be saved")
+	}
+
+	// as if refreshed
+	src.src = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "new", RefreshToken: "r"})
+	if _, err := src.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, _ := tokens.GetToken("u1", ProviderGoogle); !ok || got.AccessToken != "new" {
+		t.Errorf("incorrect saved token: expected 'new', got '%s' (%t)", got.AccessToken, ok)
+	}
+}
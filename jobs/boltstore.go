@@ -0,0 +1,238 @@
+// Durable job storage in a Bolt database file
+
+package jobs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// job ID -> JSON encoded Job
+	jobsBucket = []byte("jobs")
+	// RunAt (big endian unix nanos) + job ID -> nothing, for queued jobs
+	queueBucket = []byte("queue")
+)
+
+// BoltStore keeps jobs in a Bolt database, with an index of queued jobs
+// ordered by when they are due
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens (creating if needed) the job database at path
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to open job database %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{jobsBucket, queueBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "Unable to set up job database")
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the database file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Enqueue saves job unless its ID is taken
+func (s *BoltStore) Enqueue(job Job) (bool, error) {
+	added := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(jobsBucket).Get([]byte(job.ID)) != nil {
+			return nil
+		}
+
+		added = true
+		return putJob(tx, job)
+	})
+
+	return added, err
+}
+
+// Claim marks the queued job due soonest running
+func (s *BoltStore) Claim(now time.Time) (Job, bool, error) {
+	var job Job
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		queue := tx.Bucket(queueBucket)
+		for !found {
+			key, _ := queue.Cursor().First()
+			if key == nil || queueKeyTime(key).After(now) {
+				return nil
+			}
+			key = append([]byte(nil), key...)
+
+			var err error
+			job, found, err = getJob(tx, string(key[8:]))
+			if err != nil {
+				return err
+			}
+			if !found {
+				// the job is gone, so its entry would block the rest of the queue
+				if err := queue.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+
+		job.Status = StatusRunning
+		job.UpdatedAt = now.UTC()
+		return putJob(tx, job)
+	})
+
+	return job, found, err
+}
+
+// Save replaces the stored job
+func (s *BoltStore) Save(job Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJob(tx, job)
+	})
+}
+
+// Get returns the job with id
+func (s *BoltStore) Get(id string) (Job, bool, error) {
+	var job Job
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, found, err = getJob(tx, id)
+		return err
+	})
+
+	return job, found, err
+}
+
+// List returns jobs with status (all if empty), oldest first
+func (s *BoltStore) List(status string) ([]Job, error) {
+	jobs := []Job{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, value []byte) error {
+			var job Job
+			if err := json.Unmarshal(value, &job); err != nil {
+				return err
+			}
+			if status == "" || job.Status == status {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	sortJobs(jobs)
+
+	return jobs, err
+}
+
+// RequeueRunning returns running jobs to the queue
+func (s *BoltStore) RequeueRunning() (int, error) {
+	running, err := s.List(StatusRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for _, job := range running {
+			job.Status = StatusQueued
+			if err := putJob(tx, job); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return len(running), err
+}
+
+// DeleteFinished removes jobs that succeeded before t
+func (s *BoltStore) DeleteFinished(t time.Time) (int, error) {
+	succeeded, err := s.List(StatusSucceeded)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for _, job := range succeeded {
+			if !job.FinishedAt.Before(t) {
+				continue
+			}
+			if err := tx.Bucket(jobsBucket).Delete([]byte(job.ID)); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// getJob reads the job with id in tx
+func getJob(tx *bolt.Tx, id string) (Job, bool, error) {
+	value := tx.Bucket(jobsBucket).Get([]byte(id))
+	if value == nil {
+		return Job{}, false, nil
+	}
+
+	var job Job
+	if err := json.Unmarshal(value, &job); err != nil {
+		return Job{}, false, errors.Wrapf(err, "Unable to decode job %s", id)
+	}
+
+	return job, true, nil
+}
+
+// putJob writes job in tx, keeping the queue index in step
+func putJob(tx *bolt.Tx, job Job) error {
+	old, found, err := getJob(tx, job.ID)
+	if err != nil {
+		return err
+	}
+	queue := tx.Bucket(queueBucket)
+	if found && old.Status == StatusQueued {
+		if err := queue.Delete(queueKey(old)); err != nil {
+			return err
+		}
+	}
+	if job.Status == StatusQueued {
+		if err := queue.Put(queueKey(job), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(jobsBucket).Put([]byte(job.ID), value)
+}
+
+// queueKey sorts queued jobs by when they are due
+func queueKey(job Job) []byte {
+	key := make([]byte, 8, 8+len(job.ID))
+	binary.BigEndian.PutUint64(key, uint64(job.RunAt.UnixNano()))
+	return append(key, job.ID...)
+}
+
+func queueKeyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
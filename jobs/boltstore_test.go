@@ -0,0 +1,140 @@
+package jobs
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// testStore checks the Store contract shared by every implementation
+func testStore(t *testing.T, name string, s Store) {
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	newJob := func(id string, runAt time.Time) Job {
+		return Job{ID: id, Type: "t", Status: StatusQueued, MaxAttempts: 1,
+			RunAt: runAt, CreatedAt: runAt}
+	}
+
+	for _, job := range []Job{newJob("b", now.Add(time.Minute)), newJob("a", now),
+		newJob("later", now.Add(time.Hour))} {
+		if added, err := s.Enqueue(job); err != nil || !added {
+			t.Fatalf("%s: unable to enqueue '%s': %v", name, job.ID, err)
+		}
+	}
+	if added, _ := s.Enqueue(newJob("a", now)); added {
+		t.Errorf("%s: expected duplicate id to be ignored", name)
+	}
+
+	// claimed in order of when they are due
+	for _, want := range []string{"a", "b", ""} {
+		job, ok, err := s.Claim(now.Add(2 * time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.ID != want || ok != (want != "") {
+			t.Errorf("%s: incorrect claim: expected '%s', got '%s'", name, want, job.ID)
+		}
+	}
+
+	requeued, err := s.RequeueRunning()
+	if err != nil || requeued != 2 {
+		t.Errorf("%s: incorrect requeue: expected '2', got '%d' (%v)", name, requeued, err)
+	}
+
+	job, _, _ := s.Claim(now.Add(2 * time.Minute))
+	job.Status = StatusSucceeded
+	job.FinishedAt = now
+	if err := s.Save(job); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, _ := s.Get(job.ID); !ok || got.Status != StatusSucceeded {
+		t.Errorf("%s: incorrect saved job: got '%+v'", name, got)
+	}
+
+	queued, _ := s.List(StatusQueued)
+	if len(queued) != 2 {
+		t.Errorf("%s: incorrect queued jobs: expected '2', got '%d'", name, len(queued))
+	}
+
+	deleted, err := s.DeleteFinished(now.Add(time.Second))
+	if err != nil || deleted != 1 {
+		t.Errorf("%s: incorrect delete: expected '1', got '%d' (%v)", name, deleted, err)
+	}
+	if all, _ := s.List(""); len(all) != 2 {
+		t.Errorf("%s: incorrect jobs after delete: expected '2', got '%d'", name, len(all))
+	}
+}
+
+func TestStores(t *testing.T) {
+	testStore(t, "memory", newMemoryStore())
+
+	boltStore, err := OpenBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+	testStore(t, "bolt", boltStore)
+}
+
+func TestBoltStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	first, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := Job{ID: "x", Type: "t", Status: StatusQueued, RunAt: time.Now()}
+	if _, err := first.Enqueue(job); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := first.Claim(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	// the job was interrupted while running, so goes back in the queue
+	second, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if requeued, _ := second.RequeueRunning(); requeued != 1 {
+		t.Errorf("incorrect requeue: expected '1', got '%d'", requeued)
+	}
+	if got, ok, _ := second.Claim(time.Now()); !ok || got.ID != "x" {
+		t.Errorf("expected job to be claimable after reopen, got '%+v'", got)
+	}
+}
+
+func TestBoltStoreClaimSkipsOrphans(t *testing.T) {
+	s, err := OpenBoltStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	orphan := Job{ID: "orphan", Type: "t", Status: StatusQueued, RunAt: now.Add(-time.Minute)}
+	// queued without its job record, as if the record was lost
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Put(queueKey(orphan), []byte{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Enqueue(Job{ID: "x", Type: "t", Status: StatusQueued, RunAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"x", ""} {
+		job, ok, err := s.Claim(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.ID != want || ok != (want != "") {
+			t.Errorf("incorrect claim: expected '%s', got '%s'", want, job.ID)
+		}
+	}
+}
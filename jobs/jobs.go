@@ -0,0 +1,423 @@
+// Durable background jobs, with retries, dead-lettering and recurring
+// schedules, so work survives restarts
+
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	// StatusDead jobs ran out of attempts, and wait for a manual retry
+	StatusDead = "dead"
+)
+
+const (
+	defaultMaxAttempts = 5
+	maxRetryDelay      = 10 * time.Minute
+	// succeeded jobs are kept this long for inspection
+	finishedRetention = 7 * 24 * time.Hour
+)
+
+// Job is a unit of background work, run by the Handler for its Type
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	// RunAt is when a queued job is next due
+	RunAt      time.Time `json:"run_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	// Progress is whatever the handler last reported with SetProgress
+	Progress json.RawMessage `json:"progress,omitempty"`
+}
+
+// Handler does the work for a job. Returning an error retries the job with
+// backoff until it runs out of attempts
+type Handler func(ctx context.Context, job Job) error
+
+// Store persists jobs
+type Store interface {
+	// Enqueue saves a new job, reporting false if its ID already exists
+	Enqueue(job Job) (bool, error)
+	// Claim marks the queued job due soonest (as of now) running
+	Claim(now time.Time) (Job, bool, error)
+	Save(job Job) error
+	Get(id string) (Job, bool, error)
+	// List returns jobs with status (all jobs if empty), oldest first
+	List(status string) ([]Job, error)
+	// RequeueRunning returns jobs left running by a stopped process to the
+	// queue, reporting how many there were
+	RequeueRunning() (int, error)
+	// DeleteFinished removes jobs that succeeded before t
+	DeleteFinished(t time.Time) (int, error)
+}
+
+// schedule enqueues a job of jobType once per interval
+type schedule struct {
+	name     string
+	interval time.Duration
+	jobType  string
+	payload  json.RawMessage
+}
+
+var (
+	store Store = newMemoryStore()
+
+	handlersMu sync.RWMutex
+	handlers   = make(map[string]Handler)
+
+	schedulesMu sync.Mutex
+	schedules   []schedule
+
+	// serializes read-modify-writes of a running job's progress and outcome
+	progressMu sync.Mutex
+
+	// wakes an idle worker when a job is enqueued
+	wake = make(chan struct{}, 1)
+
+	// how often idle workers and the scheduler check for due jobs, and the
+	// first retry delay, swapped out in tests
+	pollInterval   = time.Second
+	retryBaseDelay = 2 * time.Second
+)
+
+// SetStore replaces the default in-memory job storage
+func SetStore(s Store) {
+	store = s
+}
+
+// Register sets the handler for jobs of jobType
+func Register(jobType string, handler Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	handlers[jobType] = handler
+}
+
+// Enqueue queues a job of jobType to run as soon as a worker is free
+func Enqueue(jobType string, payload interface{}) (Job, error) {
+	return EnqueueAt(jobType, payload, time.Now())
+}
+
+// EnqueueAt queues a job of jobType to run at runAt
+func EnqueueAt(jobType string, payload interface{}, runAt time.Time) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, errors.Wrap(err, "Error in EnqueueAt()")
+	}
+
+	job, _, err := enqueue(id, jobType, payload, runAt)
+	return job, err
+}
+
+// enqueue saves a new job with id, doing nothing if it already exists
+func enqueue(id, jobType string, payload interface{}, runAt time.Time) (Job, bool, error) {
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, false, errors.Wrap(err, "Unable to encode job payload")
+	}
+
+	now := time.Now().UTC()
+	job := Job{
+		ID:          id,
+		Type:        jobType,
+		Payload:     rawPayload,
+		Status:      StatusQueued,
+		MaxAttempts: defaultMaxAttempts,
+		RunAt:       runAt.UTC(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	added, err := store.Enqueue(job)
+	if err != nil {
+		return Job{}, false, errors.Wrap(err, "Unable to save job")
+	}
+	if added {
+		notifyWorkers()
+	}
+
+	return job, added, nil
+}
+
+// Get returns the job with id
+func Get(id string) (Job, bool, error) {
+	return store.Get(id)
+}
+
+// List returns jobs with status, or all jobs if status is empty
+func List(status string) ([]Job, error) {
+	return store.List(status)
+}
+
+// SetProgress records progress for job id, for clients polling its status
+func SetProgress(id string, progress interface{}) error {
+	rawProgress, err := json.Marshal(progress)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode job progress")
+	}
+
+	progressMu.Lock()
+	defer progressMu.Unlock()
+
+	job, ok, err := store.Get(id)
+	if err != nil {
+		return errors.Wrap(err, "Error in SetProgress()")
+	}
+	if !ok {
+		return errors.New(fmt.Sprintf("No job with id '%s'", id))
+	}
+
+	job.Progress = rawProgress
+	job.UpdatedAt = time.Now().UTC()
+	return store.Save(job)
+}
+
+// Retry queues a dead job to run again with a fresh set of attempts
+func Retry(id string) (Job, error) {
+	job, ok, err := store.Get(id)
+	if err != nil {
+		return Job{}, errors.Wrap(err, "Error in Retry()")
+	}
+	if !ok {
+		return Job{}, errors.New(fmt.Sprintf("No job with id '%s'", id))
+	}
+	if job.Status != StatusDead {
+		return Job{}, errors.New(fmt.Sprintf("Job '%s' is %s, only dead jobs can be retried",
+			id, job.Status))
+	}
+
+	job.Status = StatusQueued
+	job.Attempts = 0
+	job.RunAt = time.Now().UTC()
+	job.UpdatedAt = job.RunAt
+	job.FinishedAt = time.Time{}
+	if err := store.Save(job); err != nil {
+		return Job{}, errors.Wrap(err, "Error in Retry()")
+	}
+	notifyWorkers()
+
+	return job, nil
+}
+
+// Every enqueues a job of jobType at the start of each interval. The job ID
+// is derived from name and the interval, so restarts don't run it twice
+func Every(name string, interval time.Duration, jobType string, payload interface{}) error {
+	if interval <= 0 {
+		return errors.New("Schedule interval must be positive")
+	}
+
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode job payload")
+	}
+
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+
+	schedules = append(schedules, schedule{name: name, interval: interval,
+		jobType: jobType, payload: rawPayload})
+	return nil
+}
+
+// Start runs workers goroutines processing jobs, and the scheduler for
+// recurring jobs, until the returned stop function is called. stop waits
+// for running jobs to finish
+func Start(workers int) (func(), error) {
+	if workers < 1 {
+		return nil, errors.New("Need at least one job worker")
+	}
+
+	requeued, err := store.RequeueRunning()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to requeue interrupted jobs")
+	}
+	if requeued > 0 {
+		fmt.Printf("jobs: requeued %d interrupted jobs\n", requeued)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runSchedules(ctx)
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+		})
+	}
+
+	return stop, nil
+}
+
+// work claims and runs due jobs until ctx is done
+func work(ctx context.Context) {
+	for {
+		job, ok, err := store.Claim(time.Now())
+		if err != nil {
+			fmt.Println("jobs: error claiming job", err)
+		}
+		if ok {
+			runJob(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// runJob runs the handler for job and records the outcome
+func runJob(ctx context.Context, job Job) {
+	job.Attempts++
+	err := callHandler(ctx, job)
+
+	now := time.Now().UTC()
+	job.UpdatedAt = now
+	switch {
+	case err == nil:
+		job.Status = StatusSucceeded
+		job.FinishedAt = now
+		job.LastError = ""
+	case job.Attempts >= job.MaxAttempts:
+		job.Status = StatusDead
+		job.FinishedAt = now
+		job.LastError = err.Error()
+		fmt.Printf("jobs: %s job %s is dead after %d attempts: %s\n",
+			job.Type, job.ID, job.Attempts, err)
+	default:
+		job.Status = StatusQueued
+		job.RunAt = now.Add(retryDelay(job.Attempts))
+		job.LastError = err.Error()
+	}
+
+	// save even if ctx is done, so the attempt isn't lost
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if latest, ok, err := store.Get(job.ID); err == nil && ok {
+		job.Progress = latest.Progress
+	}
+	if err := store.Save(job); err != nil {
+		fmt.Println("jobs: error saving job", job.ID, err)
+	}
+}
+
+// callHandler runs the handler for job, turning panics into errors
+func callHandler(ctx context.Context, job Job) (err error) {
+	handlersMu.RLock()
+	handler, ok := handlers[job.Type]
+	handlersMu.RUnlock()
+	if !ok {
+		return errors.New(fmt.Sprintf("No handler for job type '%s'", job.Type))
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("job panicked: %v", r))
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+// runSchedules enqueues recurring jobs as they come due, and clears out old
+// finished jobs, until ctx is done
+func runSchedules(ctx context.Context) {
+	var lastCleanup time.Time
+	for {
+		now := time.Now()
+		enqueueSchedules(now)
+
+		if now.Sub(lastCleanup) > time.Hour {
+			lastCleanup = now
+			if _, err := store.DeleteFinished(now.Add(-finishedRetention)); err != nil {
+				fmt.Println("jobs: error deleting finished jobs", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// enqueueSchedules queues the job for the current interval of each schedule
+func enqueueSchedules(now time.Time) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+
+	for _, s := range schedules {
+		slot := now.Truncate(s.interval)
+		id := fmt.Sprintf("%s@%d", s.name, slot.Unix())
+		if _, _, err := enqueue(id, s.jobType, s.payload, slot); err != nil {
+			fmt.Println("jobs: error enqueueing scheduled job", id, err)
+		}
+	}
+}
+
+// retryDelay is the exponential backoff before attempt+1, with jitter
+func retryDelay(attempt int) time.Duration {
+	delay := float64(retryBaseDelay) * math.Pow(2, float64(attempt-1))
+	if delay > float64(maxRetryDelay) {
+		delay = float64(maxRetryDelay)
+	}
+
+	// somewhere between half and all of the delay
+	return time.Duration(delay/2 + mathrand.Float64()*delay/2)
+}
+
+// notifyWorkers wakes an idle worker, if there is one
+func notifyWorkers() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,187 @@
+package jobs
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// swap in a fresh store, handlers and fast timings for the duration of a test
+func useTestJobs(t *testing.T) {
+	oldStore, oldPoll, oldRetry := store, pollInterval, retryBaseDelay
+	store, pollInterval, retryBaseDelay = newMemoryStore(), 5*time.Millisecond, time.Millisecond
+
+	handlersMu.Lock()
+	oldHandlers := handlers
+	handlers = make(map[string]Handler)
+	handlersMu.Unlock()
+
+	schedulesMu.Lock()
+	oldSchedules := schedules
+	schedules = nil
+	schedulesMu.Unlock()
+
+	t.Cleanup(func() {
+		store, pollInterval, retryBaseDelay = oldStore, oldPoll, oldRetry
+		handlersMu.Lock()
+		handlers = oldHandlers
+		handlersMu.Unlock()
+		schedulesMu.Lock()
+		schedules = oldSchedules
+		schedulesMu.Unlock()
+	})
+}
+
+// waitForStatus polls until job id has status, failing after a few seconds
+func waitForStatus(t *testing.T, id, status string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _, _ := Get(id)
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job '%s' never reached '%s', got '%+v'", id, status, job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWorkersRetryAndDeadLetter(t *testing.T) {
+	useTestJobs(t)
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	Register("flaky", func(ctx context.Context, job Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[job.ID]++
+		if calls[job.ID] < 3 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	Register("broken", func(ctx context.Context, job Job) error {
+		panic("boom")
+	})
+
+	stop, err := Start(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	cases := []struct {
+		jobType      string
+		wantStatus   string
+		wantAttempts int
+	}{
+		{"flaky", StatusSucceeded, 3},
+		{"broken", StatusDead, defaultMaxAttempts},
+		{"unknown", StatusDead, defaultMaxAttempts},
+	}
+
+	for _, c := range cases {
+		job, err := Enqueue(c.jobType, map[string]int{"n": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := waitForStatus(t, job.ID, c.wantStatus)
+		if got.Attempts != c.wantAttempts {
+			t.Errorf("incorrect attempts for '%s': expected '%d', got '%d'",
+				c.jobType, c.wantAttempts, got.Attempts)
+		}
+		if c.wantStatus == StatusDead && got.LastError == "" {
+			t.Errorf("expected an error recorded for '%s'", c.jobType)
+		}
+	}
+
+	// a dead job can be retried once its handler is fixed
+	dead, _ := List(StatusDead)
+	if len(dead) != 2 {
+		t.Fatalf("incorrect number of dead jobs: expected '2', got '%d'", len(dead))
+	}
+	Register("unknown", func(ctx context.Context, job Job) error { return nil })
+	for _, job := range dead {
+		if job.Type != "unknown" {
+			continue
+		}
+		if _, err := Retry(job.ID); err != nil {
+			t.Fatal(err)
+		}
+		waitForStatus(t, job.ID, StatusSucceeded)
+	}
+
+	succeeded, _ := List(StatusSucceeded)
+	if _, err := Retry(succeeded[0].ID); err == nil {
+		t.Errorf("expected error retrying a job that isn't dead")
+	}
+}
+
+func TestEnqueueAtWaitsUntilDue(t *testing.T) {
+	useTestJobs(t)
+
+	job, err := EnqueueAt("later", nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := store.Claim(time.Now()); ok {
+		t.Errorf("did not expect job to be due yet")
+	}
+	claimed, ok, _ := store.Claim(time.Now().Add(2 * time.Hour))
+	if !ok || claimed.ID != job.ID || claimed.Status != StatusRunning {
+		t.Errorf("expected job to be claimed once due, got '%+v'", claimed)
+	}
+}
+
+func TestEveryEnqueuesOncePerInterval(t *testing.T) {
+	useTestJobs(t)
+
+	if err := Every("sync", 0, "sync", nil); err == nil {
+		t.Errorf("expected error for zero interval")
+	}
+	if err := Every("sync", time.Hour, "sync", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2019, 6, 1, 10, 15, 0, 0, time.UTC)
+	enqueueSchedules(now)
+	enqueueSchedules(now.Add(30 * time.Minute))
+	enqueueSchedules(now.Add(time.Hour))
+
+	all, _ := List("")
+	if len(all) != 2 {
+		t.Fatalf("incorrect number of scheduled jobs: expected '2', got '%d'", len(all))
+	}
+	if !all[0].RunAt.Equal(now.Truncate(time.Hour)) {
+		t.Errorf("incorrect run time: expected '%s', got '%s'", now.Truncate(time.Hour), all[0].RunAt)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	oldBase := retryBaseDelay
+	retryBaseDelay = time.Second
+	defer func() { retryBaseDelay = oldBase }()
+
+	cases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{3, 4 * time.Second},
+		{30, maxRetryDelay},
+	}
+
+	for _, c := range cases {
+		got := retryDelay(c.attempt)
+		if got < c.max/2 || got > c.max {
+			t.Errorf("incorrect delay for attempt %d: expected between '%s' and '%s', got '%s'",
+				c.attempt, c.max/2, c.max, got)
+		}
+	}
+}
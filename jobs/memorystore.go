@@ -0,0 +1,131 @@
+// In-memory job storage, used when no durable store is configured
+
+package jobs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore is the default, process-local Store
+type memoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{jobs: make(map[string]Job)}
+}
+
+// Enqueue saves job unless its ID is taken
+func (s *memoryStore) Enqueue(job Job) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; ok {
+		return false, nil
+	}
+	s.jobs[job.ID] = job
+	return true, nil
+}
+
+// Claim marks the queued job due soonest running
+func (s *memoryStore) Claim(now time.Time) (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next Job
+	found := false
+	for _, job := range s.jobs {
+		if job.Status != StatusQueued || job.RunAt.After(now) {
+			continue
+		}
+		if !found || job.RunAt.Before(next.RunAt) {
+			next = job
+			found = true
+		}
+	}
+	if !found {
+		return Job{}, false, nil
+	}
+
+	next.Status = StatusRunning
+	next.UpdatedAt = now.UTC()
+	s.jobs[next.ID] = next
+	return next, true, nil
+}
+
+// Save replaces the stored job
+func (s *memoryStore) Save(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// Get returns the job with id
+func (s *memoryStore) Get(id string) (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	return job, ok, nil
+}
+
+// List returns jobs with status (all if empty), oldest first
+func (s *memoryStore) List(status string) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []Job{}
+	for _, job := range s.jobs {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, job)
+		}
+	}
+	sortJobs(jobs)
+	return jobs, nil
+}
+
+// RequeueRunning returns running jobs to the queue
+func (s *memoryStore) RequeueRunning() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for id, job := range s.jobs {
+		if job.Status == StatusRunning {
+			job.Status = StatusQueued
+			s.jobs[id] = job
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteFinished removes jobs that succeeded before t
+func (s *memoryStore) DeleteFinished(t time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for id, job := range s.jobs {
+		if job.Status == StatusSucceeded && job.FinishedAt.Before(t) {
+			delete(s.jobs, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// sortJobs orders jobs oldest first
+func sortJobs(jobs []Job) {
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+}
@@ -1,10 +1,38 @@
 package main
 
-import "github.com/swayne275/showcal-backend-go/clientapi"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/clientapi"
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/jobs"
+	"github.com/swayne275/showcal-backend-go/showindex"
+	"github.com/swayne275/showcal-backend-go/storage"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"github.com/swayne275/showcal-backend-go/webhooks"
+	"golang.org/x/net/context"
+)
 
 const (
 	// ServerPort is where the web server is hosted
 	ServerPort = "8080"
+
+	// how often followed shows are checked for new episodes
+	subscriptionSyncInterval = time.Hour
+	// how often popular shows are added to the autocomplete index
+	showIndexRefreshInterval = 12 * time.Hour
+
+	// background job defaults, overridden by the 'jobsdb' and 'jobworkers'
+	// environment variables
+	defaultJobsDB     = "showcal-jobs.db"
+	defaultJobWorkers = 4
+
+	// user database, overridden by the 'showcaldb' environment variable
+	defaultDB = "showcal.db"
 )
 
 func main() {
@@ -12,8 +40,90 @@ func main() {
 	//const queryID = 2550 // American Dad
 	//const queryID = 3564 // Friends
 
-	err := clientapi.StartClientAPI(ServerPort)
+	// server-wide event templates, empty keeps the built in format
+	err := gcalwrapper.SetDefaultTemplates(gcalwrapper.EventTemplates{
+		Summary:     os.Getenv("summarytemplate"),
+		Description: os.Getenv("descriptiontemplate"),
+	})
 	if err != nil {
 		panic(err)
 	}
+	// synopses, images and links from TVMaze, unless 'enrichepisodes' is false
+	if enrich, err := strconv.ParseBool(os.Getenv("enrichepisodes")); err != nil || enrich {
+		gcalwrapper.SetEpisodeEnricher(tvshowdata.EnrichEpisodes)
+		clientapi.SetEpisodeEnricher(tvshowdata.EnrichEpisodes)
+	}
+
+	db := os.Getenv("showcaldb")
+	if db == "" {
+		db = defaultDB
+	}
+	repo, err := storage.OpenSQLite(db)
+	if err != nil {
+		panic(err)
+	}
+	defer repo.Close()
+	storage.Use(repo)
+
+	stopJobs, err := startJobs()
+	if err != nil {
+		panic(err)
+	}
+	defer stopJobs()
+
+	// the index is only in memory, so fill it now rather than at the next
+	// scheduled refresh
+	go func() {
+		added, err := showindex.Refresh(context.Background())
+		if err != nil {
+			fmt.Println("error refreshing show index", err)
+		}
+		fmt.Printf("showindex: refreshed %d shows at startup\n", added)
+	}()
+
+	err = clientapi.StartClientAPI(ServerPort)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// startJobs opens the job database and starts the background workers
+func startJobs() (func(), error) {
+	path := os.Getenv("jobsdb")
+	if path == "" {
+		path = defaultJobsDB
+	}
+	workers, err := strconv.Atoi(os.Getenv("jobworkers"))
+	if err != nil {
+		workers = defaultJobWorkers
+	}
+
+	jobStore, err := jobs.OpenBoltStore(path)
+	if err != nil {
+		return nil, err
+	}
+	jobs.SetStore(jobStore)
+
+	gcalwrapper.RegisterJobs()
+	subscriptions.RegisterJobs()
+	webhooks.RegisterJobs()
+	showindex.RegisterJobs()
+	err = jobs.Every("subscriptions-sync", subscriptionSyncInterval, subscriptions.SyncAllJob, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = jobs.Every("showindex-refresh", showIndexRefreshInterval, showindex.RefreshJob, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stop, err := jobs.Start(workers)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		stop()
+		jobStore.Close()
+	}, nil
 }
@@ -0,0 +1,204 @@
+// Per-user notifications for live clients, with a short history so a
+// reconnecting client can catch up on what it missed
+
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// event types
+const (
+	EpisodeCreated = "episode_created"
+	EpisodeUpdated = "episode_updated"
+	EpisodeDeleted = "episode_deleted"
+	EpisodeFailed  = "episode_failed"
+	// AirDateChanged is sent when a followed show moves an episode
+	AirDateChanged = "air_date_changed"
+	// TokenExpired is sent when a calendar login needs to be redone
+	TokenExpired = "token_expired"
+)
+
+const (
+	// events kept per user for clients resuming with Last-Event-ID
+	historySize = 100
+	// events queued for a slow listener before it is disconnected
+	listenerBuffer = 32
+)
+
+// Event is a notification for a user. IDs increase across all users, and
+// start from the time the process started so they keep increasing across
+// restarts
+type Event struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Listener receives a user's events as they are published. C is closed if
+// the listener falls too far behind, and the client should resume from the
+// last event it saw
+type Listener struct {
+	C <-chan Event
+
+	c      chan Event
+	userID string
+	closed bool
+}
+
+// history is a ring buffer of a user's most recent events
+type history struct {
+	events []Event
+	start  int
+	count  int
+}
+
+type userStream struct {
+	history   history
+	listeners map[*Listener]struct{}
+}
+
+type hub struct {
+	mu sync.Mutex
+	// IDs before epoch came from an earlier process
+	epoch   uint64
+	lastID  uint64
+	streams map[string]*userStream
+}
+
+var defaultHub = newHub()
+
+func newHub() *hub {
+	// microseconds stay well inside the integers JSON clients can hold
+	epoch := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	return &hub{epoch: epoch, lastID: epoch, streams: make(map[string]*userStream)}
+}
+
+// Publish sends an event of eventType with data to the user's listeners
+func Publish(userID, eventType string, data interface{}) {
+	defaultHub.publish(userID, eventType, data)
+}
+
+// Listen starts receiving the user's events. If lastEventID is not zero,
+// the events after it that are still in the history are returned too. IDs
+// from an earlier process, or ones never sent, get the whole history
+func Listen(userID string, lastEventID uint64) (*Listener, []Event) {
+	return defaultHub.listen(userID, lastEventID)
+}
+
+// Close stops the listener receiving events
+func (l *Listener) Close() {
+	defaultHub.remove(l)
+}
+
+func (h *hub) stream(userID string) *userStream {
+	stream, ok := h.streams[userID]
+	if !ok {
+		stream = &userStream{
+			history:   history{events: make([]Event, historySize)},
+			listeners: make(map[*Listener]struct{}),
+		}
+		h.streams[userID] = stream
+	}
+
+	return stream
+}
+
+func (h *hub) publish(userID, eventType string, data interface{}) {
+	if userID == "" {
+		return
+	}
+
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("notify: unable to encode event data", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	event := Event{ID: h.lastID, Type: eventType, Time: time.Now().UTC(), Data: rawData}
+	stream := h.stream(userID)
+	stream.history.add(event)
+
+	for listener := range stream.listeners {
+		select {
+		case listener.c <- event:
+		default:
+			// too far behind, so let the client reconnect and catch up
+			h.closeListener(stream, listener)
+		}
+	}
+}
+
+func (h *hub) listen(userID string, lastEventID uint64) (*Listener, []Event) {
+	c := make(chan Event, listenerBuffer)
+	listener := &Listener{C: c, c: c, userID: userID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stream := h.stream(userID)
+	stream.listeners[listener] = struct{}{}
+
+	var missed []Event
+	if lastEventID > 0 {
+		if lastEventID < h.epoch || lastEventID > h.lastID {
+			lastEventID = 0
+		}
+		missed = stream.history.since(lastEventID)
+	}
+
+	return listener, missed
+}
+
+func (h *hub) remove(listener *Listener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if stream, ok := h.streams[listener.userID]; ok {
+		h.closeListener(stream, listener)
+	}
+}
+
+func (h *hub) closeListener(stream *userStream, listener *Listener) {
+	if listener.closed {
+		return
+	}
+
+	listener.closed = true
+	close(listener.c)
+	delete(stream.listeners, listener)
+}
+
+// add appends event, dropping the oldest once full
+func (r *history) add(event Event) {
+	size := len(r.events)
+	if r.count < size {
+		r.events[(r.start+r.count)%size] = event
+		r.count++
+		return
+	}
+
+	r.events[r.start] = event
+	r.start = (r.start + 1) % size
+}
+
+// since returns the events after id, oldest first
+func (r *history) since(id uint64) []Event {
+	var events []Event
+	for i := 0; i < r.count; i++ {
+		event := r.events[(r.start+i)%len(r.events)]
+		if event.ID > id {
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
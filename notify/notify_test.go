@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	cases := []struct {
+		name      string
+		published int
+		since     uint64
+		wantFirst uint64
+		wantCount int
+	}{
+		{"partly full", 3, 1, 2, 2},
+		{"caught up", 3, 3, 0, 0},
+		{"wrapped", historySize + 10, 0, 11, historySize},
+		{"resume within wrapped", historySize + 10, historySize, historySize + 1, 10},
+	}
+
+	for _, c := range cases {
+		r := history{events: make([]Event, historySize)}
+		for id := 1; id <= c.published; id++ {
+			r.add(Event{ID: uint64(id)})
+		}
+
+		got := r.since(c.since)
+		if len(got) != c.wantCount {
+			t.Errorf("incorrect number of events for '%s': expected '%d', got '%d'",
+				c.name, c.wantCount, len(got))
+			continue
+		}
+		if c.wantCount > 0 && got[0].ID != c.wantFirst {
+			t.Errorf("incorrect first event for '%s': expected '%d', got '%d'",
+				c.name, c.wantFirst, got[0].ID)
+		}
+	}
+}
+
+func TestListenAndResume(t *testing.T) {
+	h := newHub()
+
+	listener, missed := h.listen("u1", 0)
+	if len(missed) != 0 {
+		t.Errorf("did not expect history for a new listener, got '%d' events", len(missed))
+	}
+
+	h.publish("u1", EpisodeCreated, map[string]string{"key": "A/S01E01"})
+	h.publish("u2", EpisodeCreated, map[string]string{"key": "B/S01E01"})
+	h.publish("u1", TokenExpired, map[string]string{"calendar": "google"})
+
+	first := <-listener.C
+	second := <-listener.C
+	if first.Type != EpisodeCreated || second.Type != TokenExpired ||
+		string(first.Data) != `{"key":"A/S01E01"}` {
+		t.Errorf("incorrect events: got '%+v' and '%+v'", first, second)
+	}
+	h.remove(listener)
+	h.remove(listener)
+
+	// resuming only returns what came after the last event seen
+	_, missed = h.listen("u1", first.ID)
+	if len(missed) != 1 || missed[0].ID != second.ID {
+		t.Errorf("incorrect missed events: got '%+v'", missed)
+	}
+
+	// ids the process didn't send replay everything it has
+	cases := []struct {
+		name        string
+		lastEventID uint64
+	}{
+		{"earlier process", h.epoch - 1},
+		{"never sent", second.ID + 1},
+	}
+	for _, c := range cases {
+		_, missed = h.listen("u1", c.lastEventID)
+		if len(missed) != 2 || missed[0].ID != first.ID {
+			t.Errorf("incorrect missed events for '%s': got '%+v'", c.name, missed)
+		}
+	}
+}
+
+func TestSlowListenerIsClosed(t *testing.T) {
+	h := newHub()
+	listener, _ := h.listen("u1", 0)
+
+	for i := 0; i <= listenerBuffer; i++ {
+		h.publish("u1", EpisodeCreated, i)
+	}
+
+	count := 0
+	for range listener.C {
+		count++
+	}
+	if count != listenerBuffer {
+		t.Errorf("incorrect events before close: expected '%d', got '%d'", listenerBuffer, count)
+	}
+}
@@ -0,0 +1,201 @@
+// Combined agenda of upcoming episodes across the shows a user follows
+
+package schedule
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+const (
+	// DefaultDays is how far ahead a schedule looks when not told
+	DefaultDays = 7
+	// MaxDays is the furthest ahead a schedule looks
+	MaxDays = 30
+
+	// shows fetched from episodate at once
+	maxConcurrentFetches = 4
+	// how long a show's upcoming episodes are reused for
+	cacheTTL = 15 * time.Minute
+)
+
+// dates used for the day groups
+const dateFormat = "2006-01-02"
+
+// Day is the episodes airing on one date in the schedule's timezone
+type Day struct {
+	Date     string               `json:"date"`
+	Episodes []tvshowdata.Episode `json:"episodes"`
+}
+
+// FailedShow is a show whose episodes couldn't be fetched
+type FailedShow struct {
+	ShowID int64  `json:"show_id"`
+	Error  string `json:"error"`
+}
+
+// Schedule is the upcoming episodes for a set of shows, grouped by day.
+// Shows that failed are listed, so the rest can still be shown
+type Schedule struct {
+	Timezone string       `json:"timezone"`
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Days     []Day        `json:"days"`
+	Failed   []FailedShow `json:"failed,omitempty"`
+	Partial  bool         `json:"partial"`
+}
+
+type cachedShow struct {
+	episodes []tvshowdata.Episode
+	expires  time.Time
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = make(map[int64]cachedShow)
+)
+
+// fetchEpisodes gets a show's upcoming episodes, swapped out in tests
+var fetchEpisodes = tvshowdata.FetchUpcomingEpisodes
+
+// Build returns the episodes of showIDs airing from now until the end of
+// the given number of days in loc, today being the first
+func Build(showIDs []int64, days int, loc *time.Location, now time.Time) Schedule {
+	if days < 1 {
+		days = DefaultDays
+	}
+	if days > MaxDays {
+		days = MaxDays
+	}
+
+	local := now.In(loc)
+	end := time.Date(local.Year(), local.Month(), local.Day()+days, 0, 0, 0, 0, loc)
+	result := Schedule{Timezone: loc.String(), From: now, To: end, Days: []Day{}}
+
+	episodes, failed := fetchAll(showIDs, now)
+	var upcoming []tvshowdata.Episode
+	for _, episode := range episodes {
+		if !episode.LatestAirTime().Before(now) && episode.AirDate.Before(end) {
+			upcoming = append(upcoming, episode)
+		}
+	}
+	sortEpisodes(upcoming, loc)
+
+	for _, episode := range upcoming {
+		date := airDay(episode, loc)
+		if len(result.Days) == 0 || result.Days[len(result.Days)-1].Date != date {
+			result.Days = append(result.Days, Day{Date: date})
+		}
+		day := &result.Days[len(result.Days)-1]
+		day.Episodes = append(day.Episodes, episode)
+	}
+
+	result.Failed = failed
+	result.Partial = len(failed) > 0
+	return result
+}
+
+// fetchAll gets the upcoming episodes of every show, a few at a time, and
+// lists the shows that failed in ID order
+func fetchAll(showIDs []int64, now time.Time) ([]tvshowdata.Episode, []FailedShow) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		episodes []tvshowdata.Episode
+		failed   []FailedShow
+	)
+	slots := make(chan struct{}, maxConcurrentFetches)
+
+	seen := make(map[int64]bool, len(showIDs))
+	for _, id := range showIDs {
+		// a show followed into two calendars is only listed once
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			showEpisodes, err := showEpisodes(id, now)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed = append(failed, FailedShow{ShowID: id, Error: err.Error()})
+				return
+			}
+			episodes = append(episodes, showEpisodes...)
+		}(id)
+	}
+	wg.Wait()
+
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].ShowID < failed[j].ShowID
+	})
+	return episodes, failed
+}
+
+// showEpisodes returns a show's upcoming episodes, from the cache if they
+// were fetched recently. Failures aren't cached, so they're retried
+func showEpisodes(id int64, now time.Time) ([]tvshowdata.Episode, error) {
+	cacheMu.Lock()
+	cached, ok := cache[id]
+	cacheMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.episodes, nil
+	}
+
+	upcoming, err := fetchEpisodes(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	for key, old := range cache {
+		if !now.Before(old.expires) {
+			delete(cache, key)
+		}
+	}
+	cache[id] = cachedShow{episodes: upcoming.Episodes, expires: now.Add(cacheTTL)}
+
+	return upcoming.Episodes, nil
+}
+
+// airDay is the date episode airs on in loc
+func airDay(episode tvshowdata.Episode, loc *time.Location) string {
+	if episode.DateOnly() {
+		// the day is the same everywhere when there's no time
+		return episode.AirDate.UTC().Format(dateFormat)
+	}
+	return episode.AirDate.In(loc).Format(dateFormat)
+}
+
+// sortEpisodes orders episodes by their day in loc, then air time, show and
+// episode number so the order doesn't depend on which fetch finished first.
+// Date only episodes can fall on a different day than their air time would
+// in loc, so the day comes first
+func sortEpisodes(episodes []tvshowdata.Episode, loc *time.Location) {
+	sort.Slice(episodes, func(i, j int) bool {
+		a, b := episodes[i], episodes[j]
+		aDay, bDay := airDay(a, loc), airDay(b, loc)
+		switch {
+		case aDay != bDay:
+			return aDay < bDay
+		case !a.AirDate.Equal(b.AirDate.Time):
+			return a.AirDate.Before(b.AirDate.Time)
+		case a.ShowName != b.ShowName:
+			return a.ShowName < b.ShowName
+		case a.Season != b.Season:
+			return a.Season < b.Season
+		}
+		return a.Episode < b.Episode
+	})
+}
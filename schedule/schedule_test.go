@@ -0,0 +1,183 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+// 2019-09-01 20:00 in New York
+var now = time.Date(2019, 9, 2, 0, 0, 0, 0, time.UTC)
+
+func episode(showID int64, name string, number int64, airDate time.Time) tvshowdata.Episode {
+	return tvshowdata.Episode{ShowID: showID, ShowName: name, Season: 1, Episode: number,
+		AirDate: tvshowdata.Time{Time: airDate}, RuntimeMinutes: 30}
+}
+
+// useFetch swaps in fetch and empties the cache for a test
+func useFetch(t *testing.T, fetch func(int64) (tvshowdata.Episodes, error)) {
+	oldFetch := fetchEpisodes
+	fetchEpisodes = fetch
+	cache = make(map[int64]cachedShow)
+	t.Cleanup(func() {
+		fetchEpisodes = oldFetch
+		cache = make(map[int64]cachedShow)
+	})
+}
+
+// summary lists the days and the show/episode numbers on each
+func summary(s Schedule) string {
+	var days []string
+	for _, day := range s.Days {
+		var eps []string
+		for _, ep := range day.Episodes {
+			eps = append(eps, fmt.Sprintf("%d.%d", ep.ShowID, ep.Episode))
+		}
+		days = append(days, day.Date+"="+strings.Join(eps, ","))
+	}
+
+	return strings.Join(days, " ")
+}
+
+func TestBuild(t *testing.T) {
+	shows := map[int64][]tvshowdata.Episode{
+		1: {
+			episode(1, "Alpha", 1, now.Add(-time.Hour)),
+			episode(1, "Alpha", 2, now.Add(2*time.Hour)),
+			episode(1, "Alpha", 3, now.Add(50*time.Hour)),
+			episode(1, "Alpha", 4, now.Add(30*24*time.Hour)),
+		},
+		2: {
+			episode(2, "Beta", 7, now.Add(2*time.Hour)),
+			episode(2, "Beta", 8, now.Add(5*time.Hour)),
+		},
+	}
+	useFetch(t, func(id int64) (tvshowdata.Episodes, error) {
+		if id == 3 {
+			return tvshowdata.Episodes{}, errors.New("api down")
+		}
+		return tvshowdata.Episodes{Episodes: shows[id]}, nil
+	})
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone data", err)
+	}
+
+	cases := []struct {
+		name        string
+		showIDs     []int64
+		days        int
+		loc         *time.Location
+		want        string
+		wantPartial bool
+	}{
+		{"utc", []int64{2, 1}, 7, time.UTC, "2019-09-02=1.2,2.7,2.8 2019-09-04=1.3", false},
+		{"new york days", []int64{1, 2}, 7, newYork, "2019-09-01=1.2,2.7 2019-09-02=2.8 2019-09-03=1.3", false},
+		{"today only", []int64{1, 2}, 1, time.UTC, "2019-09-02=1.2,2.7,2.8", false},
+		{"today only in new york", []int64{1, 2}, 1, newYork, "2019-09-01=1.2,2.7", false},
+		{"followed twice", []int64{2, 2}, 7, time.UTC, "2019-09-02=2.7,2.8", false},
+		{"partial", []int64{3, 1}, 7, time.UTC, "2019-09-02=1.2 2019-09-04=1.3", true},
+		{"nothing followed", nil, 7, time.UTC, "", false},
+	}
+
+	for _, c := range cases {
+		got := Build(c.showIDs, c.days, c.loc, now)
+		if summary(got) != c.want {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%s'", c.name, c.want, summary(got))
+		}
+		if got.Partial != c.wantPartial {
+			t.Errorf("incorrect partial for '%s': expected '%t', got '%t'", c.name, c.wantPartial, got.Partial)
+		}
+		if c.wantPartial && (len(got.Failed) != 1 || got.Failed[0].ShowID != 3) {
+			t.Errorf("incorrect failures for '%s': got '%+v'", c.name, got.Failed)
+		}
+	}
+}
+
+func TestBuildDateOnlyBehindUTC(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone data", err)
+	}
+
+	dateOnly := func(number int64, date time.Time) tvshowdata.Episode {
+		ep := episode(5, "Gamma", number, date)
+		ep.AirDatePrecision = tvshowdata.PrecisionDate
+		return ep
+	}
+	useFetch(t, func(id int64) (tvshowdata.Episodes, error) {
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{
+			// midnight UTC is the evening before in New York
+			dateOnly(1, now),
+			episode(5, "Gamma", 2, now.Add(2*time.Hour)),
+			dateOnly(3, now.Add(24*time.Hour)),
+			episode(5, "Gamma", 4, now.Add(30*time.Hour)),
+		}}, nil
+	})
+
+	got := Build([]int64{5}, 7, newYork, now)
+	want := "2019-09-01=5.2 2019-09-02=5.1 2019-09-03=5.3,5.4"
+	if summary(got) != want {
+		t.Errorf("incorrect output: expected '%s', got '%s'", want, summary(got))
+	}
+}
+
+func TestBuildCachesAndBoundsFetches(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		calls   = make(map[int64]int)
+		running int
+		most    int
+	)
+	useFetch(t, func(id int64) (tvshowdata.Episodes, error) {
+		mu.Lock()
+		calls[id]++
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if id == 1 {
+			return tvshowdata.Episodes{}, errors.New("api down")
+		}
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{episode(id, "Show", 1, now.Add(time.Hour))}}, nil
+	})
+
+	var ids []int64
+	for id := int64(1); id <= 12; id++ {
+		ids = append(ids, id)
+	}
+	Build(ids, 7, time.UTC, now)
+	got := Build(ids, 7, time.UTC, now.Add(time.Minute))
+
+	if most > maxConcurrentFetches {
+		t.Errorf("incorrect concurrency: expected at most '%d', got '%d'", maxConcurrentFetches, most)
+	}
+	if calls[2] != 1 {
+		t.Errorf("incorrect fetches for cached show: expected '1', got '%d'", calls[2])
+	}
+	// failures are retried rather than cached
+	if calls[1] != 2 {
+		t.Errorf("incorrect fetches for failed show: expected '2', got '%d'", calls[1])
+	}
+	if len(got.Days) != 1 || len(got.Days[0].Episodes) != 11 {
+		t.Errorf("incorrect output: got '%s'", summary(got))
+	}
+
+	Build(ids, 7, time.UTC, now.Add(cacheTTL+time.Minute))
+	if calls[2] != 2 {
+		t.Errorf("incorrect fetches after expiry: expected '2', got '%d'", calls[2])
+	}
+}
@@ -0,0 +1,389 @@
+// In-process index of show names for fast, typo tolerant autocomplete
+
+package showindex
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+// lookup modes
+const (
+	// ModePrefix only matches names with a word starting with the query
+	ModePrefix = "prefix"
+	// ModeFuzzy follows the prefix matches with names that are a typo or
+	// two away from the query
+	ModeFuzzy = "fuzzy"
+)
+
+const (
+	// DefaultLimit is the number of matches when no limit is given
+	DefaultLimit = 10
+	// MaxLimit is the most matches a lookup returns
+	MaxLimit = 50
+
+	// shows kept, so the index can't grow without bound
+	maxShows = 100000
+	// prefix entries scanned per lookup, enough to rank short queries
+	maxPrefixScan = 2000
+	// fuzzy candidates compared per lookup, most shared trigrams first
+	maxFuzzyCandidates = 500
+	// queries shorter than this only get prefix matches
+	minFuzzyLength = 3
+)
+
+// Match is a show found by a lookup
+type Match struct {
+	tvshowdata.Show
+	// Fuzzy is set if the name only matched with typos
+	Fuzzy bool `json:"fuzzy"`
+}
+
+// entry is a lookup key for a show: its normalized name, or the name from
+// one of its later words on
+type entry struct {
+	key  string
+	id   int64
+	full bool
+}
+
+// Index finds shows by name. It is safe for concurrent use
+type Index struct {
+	mu    sync.RWMutex
+	shows map[int64]tvshowdata.Show
+	names map[int64]string
+	// sorted by key for prefix lookups
+	entries  []entry
+	trigrams map[string]map[int64]struct{}
+}
+
+var defaultIndex = New()
+
+// New returns an empty Index
+func New() *Index {
+	return &Index{
+		shows:    make(map[int64]tvshowdata.Show),
+		names:    make(map[int64]string),
+		trigrams: make(map[string]map[int64]struct{}),
+	}
+}
+
+// Add indexes shows in the default index
+func Add(shows ...tvshowdata.Show) {
+	defaultIndex.Add(shows...)
+}
+
+// Lookup finds shows in the default index
+func Lookup(query, mode string, limit int) []Match {
+	return defaultIndex.Lookup(query, mode, limit)
+}
+
+// Size is the number of shows in the default index
+func Size() int {
+	return defaultIndex.Size()
+}
+
+// Add indexes shows, replacing any already indexed with the same ID
+func (idx *Index) Add(shows ...tvshowdata.Show) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	renamed := make(map[int64]bool)
+	var added []entry
+	for _, show := range shows {
+		name := tvshowdata.NormalizeName(show.Name)
+		if show.ID == 0 || name == "" {
+			continue
+		}
+
+		oldName, exists := idx.names[show.ID]
+		if !exists && len(idx.shows) >= maxShows {
+			continue
+		}
+		idx.shows[show.ID] = show
+		if exists && oldName == name {
+			continue
+		}
+		if exists {
+			renamed[show.ID] = true
+			for _, gram := range trigrams(oldName) {
+				delete(idx.trigrams[gram], show.ID)
+			}
+		}
+
+		idx.names[show.ID] = name
+		added = append(added, nameEntries(show.ID, name)...)
+		for _, gram := range trigrams(name) {
+			if idx.trigrams[gram] == nil {
+				idx.trigrams[gram] = make(map[int64]struct{})
+			}
+			idx.trigrams[gram][show.ID] = struct{}{}
+		}
+	}
+
+	if len(renamed) > 0 {
+		kept := idx.entries[:0]
+		for _, e := range idx.entries {
+			if !renamed[e.id] {
+				kept = append(kept, e)
+			}
+		}
+		idx.entries = kept
+	}
+	if len(added) > 0 {
+		// only the new entries need sorting, the rest already are
+		sort.Slice(added, func(i, j int) bool {
+			return added[i].key < added[j].key
+		})
+		idx.entries = mergeEntries(idx.entries, added)
+	}
+}
+
+// mergeEntries merges two slices sorted by key into a new sorted slice
+func mergeEntries(a, b []entry) []entry {
+	merged := make([]entry, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].key < a[0].key {
+			merged = append(merged, b[0])
+			b = b[1:]
+		} else {
+			merged = append(merged, a[0])
+			a = a[1:]
+		}
+	}
+	merged = append(merged, a...)
+
+	return append(merged, b...)
+}
+
+// Size is the number of shows indexed
+func (idx *Index) Size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.shows)
+}
+
+// Lookup returns up to limit shows matching query, best first: names
+// starting with it, then names with a word starting with it, then for
+// ModeFuzzy, names within a typo or two
+func (idx *Index) Lookup(query, mode string, limit int) []Match {
+	if limit < 1 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	q := tvshowdata.NormalizeName(query)
+	matches := []Match{}
+	if q == "" {
+		return matches
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	found := idx.prefixMatches(q)
+	for _, id := range found {
+		matches = append(matches, Match{Show: idx.shows[id]})
+		if len(matches) == limit {
+			return matches
+		}
+	}
+
+	if mode != ModeFuzzy || len([]rune(q)) < minFuzzyLength {
+		return matches
+	}
+
+	seen := make(map[int64]bool, len(found))
+	for _, id := range found {
+		seen[id] = true
+	}
+	for _, id := range idx.fuzzyMatches(q, seen) {
+		matches = append(matches, Match{Show: idx.shows[id], Fuzzy: true})
+		if len(matches) == limit {
+			break
+		}
+	}
+
+	return matches
+}
+
+// prefixMatches returns the shows with a key starting with q, whole name
+// matches first
+func (idx *Index) prefixMatches(q string) []int64 {
+	start := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].key >= q
+	})
+
+	fullName := make(map[int64]bool)
+	var ids []int64
+	for i := start; i < len(idx.entries) && i-start < maxPrefixScan; i++ {
+		e := idx.entries[i]
+		if !strings.HasPrefix(e.key, q) {
+			break
+		}
+		if _, seen := fullName[e.id]; !seen {
+			ids = append(ids, e.id)
+		}
+		fullName[e.id] = fullName[e.id] || e.full
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		if fullName[ids[i]] != fullName[ids[j]] {
+			return fullName[ids[i]]
+		}
+		return idx.better(ids[i], ids[j])
+	})
+	return ids
+}
+
+// fuzzyMatches returns the shows not in seen with a word that starts within
+// the allowed edit distance of q, closest first
+func (idx *Index) fuzzyMatches(q string, seen map[int64]bool) []int64 {
+	shared := make(map[int64]int)
+	for _, gram := range trigrams(q) {
+		for id := range idx.trigrams[gram] {
+			if !seen[id] {
+				shared[id]++
+			}
+		}
+	}
+
+	candidates := make([]int64, 0, len(shared))
+	for id := range shared {
+		candidates = append(candidates, id)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if shared[candidates[i]] != shared[candidates[j]] {
+			return shared[candidates[i]] > shared[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > maxFuzzyCandidates {
+		candidates = candidates[:maxFuzzyCandidates]
+	}
+
+	query := []rune(q)
+	allowed := maxDistance(len(query))
+	distances := make(map[int64]int)
+	var ids []int64
+	for _, id := range candidates {
+		best := allowed + 1
+		for _, e := range nameEntries(id, idx.names[id]) {
+			if d := prefixDistance(query, []rune(e.key)); d < best {
+				best = d
+			}
+		}
+		if best <= allowed {
+			distances[id] = best
+			ids = append(ids, id)
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		if distances[ids[i]] != distances[ids[j]] {
+			return distances[ids[i]] < distances[ids[j]]
+		}
+		return idx.better(ids[i], ids[j])
+	})
+	return ids
+}
+
+// better breaks ties between equally good matches: running shows, then
+// shorter names, then alphabetical
+func (idx *Index) better(a, b int64) bool {
+	runningA := idx.shows[a].StillRunning.IsRunning()
+	runningB := idx.shows[b].StillRunning.IsRunning()
+	if runningA != runningB {
+		return runningA
+	}
+	if len(idx.names[a]) != len(idx.names[b]) {
+		return len(idx.names[a]) < len(idx.names[b])
+	}
+	if idx.names[a] != idx.names[b] {
+		return idx.names[a] < idx.names[b]
+	}
+	return a < b
+}
+
+// nameEntries returns the lookup keys for a normalized name
+func nameEntries(id int64, name string) []entry {
+	entries := []entry{{key: name, id: id, full: true}}
+	for i := 0; i < len(name); i++ {
+		if name[i] == ' ' {
+			entries = append(entries, entry{key: name[i+1:], id: id})
+		}
+	}
+
+	return entries
+}
+
+// trigrams returns the distinct three letter sequences in s
+func trigrams(s string) []string {
+	runes := []rune(s)
+	seen := make(map[string]bool)
+	var grams []string
+	for i := 0; i+3 <= len(runes); i++ {
+		gram := string(runes[i : i+3])
+		if !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+
+	return grams
+}
+
+// maxDistance is the number of typos allowed in a query of n letters
+func maxDistance(n int) int {
+	if n <= 5 {
+		return 1
+	}
+	return 2
+}
+
+// prefixDistance is the fewest edits that turn query into a prefix of key
+func prefixDistance(query, key []rune) int {
+	// row[j] is the distance between the query so far and key[:j]
+	row := make([]int, len(key)+1)
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(query); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(key); j++ {
+			cost := 1
+			if query[i-1] == key[j-1] {
+				cost = 0
+			}
+			current := min3(row[j]+1, row[j-1]+1, prev+cost)
+			prev, row[j] = row[j], current
+		}
+	}
+
+	// the query may match any prefix of the key
+	best := row[0]
+	for _, d := range row {
+		if d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
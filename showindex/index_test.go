@@ -0,0 +1,161 @@
+package showindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+func show(id int64, name string, running bool) tvshowdata.Show {
+	status := "Ended"
+	if running {
+		status = "Running"
+	}
+
+	var s tvshowdata.Show
+	raw := fmt.Sprintf(`{"id":%d,"name":%q,"status":%q}`, id, name, status)
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func testIndex() *Index {
+	idx := New()
+	idx.Add(
+		show(1, "The Office", false),
+		show(2, "The Office (US)", true),
+		show(3, "Office Girls", false),
+		show(4, "Friends", false),
+		show(5, "Friends from College", true),
+		show(6, "The Simpsons", true),
+		show(7, "American Dad!", true),
+		show(8, "Grey's Anatomy", true),
+	)
+	return idx
+}
+
+func names(matches []Match) string {
+	var out []string
+	for _, match := range matches {
+		name := match.Name
+		if match.Fuzzy {
+			name += "~"
+		}
+		out = append(out, name)
+	}
+
+	return strings.Join(out, ",")
+}
+
+func TestLookup(t *testing.T) {
+	idx := testIndex()
+
+	cases := []struct {
+		query string
+		mode  string
+		limit int
+		want  string
+	}{
+		{"office", ModePrefix, 0, "Office Girls,The Office (US),The Office"},
+		{"the off", ModePrefix, 0, "The Office (US),The Office"},
+		{"FRIENDS", ModePrefix, 0, "Friends from College,Friends"},
+		{"college", ModePrefix, 0, "Friends from College"},
+		{"grey", ModePrefix, 0, "Grey's Anatomy"},
+		{"greys", ModeFuzzy, 0, "Grey's Anatomy~"},
+		{"simpsosn", ModePrefix, 0, ""},
+		{"simpsosn", ModeFuzzy, 0, "The Simpsons~"},
+		{"ofice", ModeFuzzy, 0, "The Office (US)~,The Office~,Office Girls~"},
+		{"freinds", ModeFuzzy, 0, "Friends from College~,Friends~"},
+		{"fri", ModeFuzzy, 0, "Friends from College,Friends"},
+		{"amercan da", ModeFuzzy, 0, "American Dad!~"},
+		{"xyzzy", ModeFuzzy, 0, ""},
+		{"office", ModeFuzzy, 2, "Office Girls,The Office (US)"},
+		{"  ", ModeFuzzy, 0, ""},
+	}
+
+	for _, c := range cases {
+		if got := names(idx.Lookup(c.query, c.mode, c.limit)); got != c.want {
+			t.Errorf("incorrect output for '%s' (%s): expected '%s', got '%s'", c.query, c.mode, c.want, got)
+		}
+	}
+}
+
+func TestAddReplaces(t *testing.T) {
+	idx := testIndex()
+	idx.Add(show(1, "The Office UK", true), show(0, "No ID", true), show(9, "!!!", true))
+
+	if idx.Size() != 8 {
+		t.Errorf("incorrect size: expected '8', got '%d'", idx.Size())
+	}
+	if got := names(idx.Lookup("the office u", ModePrefix, 0)); got != "The Office UK,The Office (US)" {
+		t.Errorf("incorrect output after rename: got '%s'", got)
+	}
+	// the old name is gone from the prefix entries and trigrams
+	for _, m := range idx.Lookup("the office", ModeFuzzy, 0) {
+		if m.ID == 1 && m.Name != "The Office UK" {
+			t.Errorf("incorrect name after rename: got '%s'", m.Name)
+		}
+	}
+}
+
+func TestAddKeepsEntriesSorted(t *testing.T) {
+	idx := New()
+	idx.Add(show(1, "Mad Men", false), show(2, "Breaking Bad", false))
+	idx.Add(show(3, "Atlanta", true), show(4, "Zoo", false), show(2, "Better Call Saul", false))
+	idx.Add(show(5, "Lost", false))
+
+	for i := 1; i < len(idx.entries); i++ {
+		if idx.entries[i-1].key > idx.entries[i].key {
+			t.Fatalf("entries out of order at %d: '%s' before '%s'",
+				i, idx.entries[i-1].key, idx.entries[i].key)
+		}
+	}
+	if got := names(idx.Lookup("b", ModePrefix, 0)); got != "Better Call Saul" {
+		t.Errorf("incorrect output for 'b': got '%s'", got)
+	}
+}
+
+func TestPrefixDistance(t *testing.T) {
+	cases := []struct {
+		query string
+		key   string
+		want  int
+	}{
+		{"office", "office girls", 0},
+		{"ofice", "office", 1},
+		{"offcie", "office", 2},
+		{"simpsosn", "simpsons", 1},
+		{"abc", "xyz", 3},
+		{"abc", "", 3},
+	}
+
+	for _, c := range cases {
+		if got := prefixDistance([]rune(c.query), []rune(c.key)); got != c.want {
+			t.Errorf("incorrect output for '%s' in '%s': expected '%d', got '%d'", c.query, c.key, c.want, got)
+		}
+	}
+}
+
+func TestLookupSpeed(t *testing.T) {
+	idx := New()
+	var shows []tvshowdata.Show
+	for i := int64(1); i <= 20000; i++ {
+		shows = append(shows, show(i, fmt.Sprintf("Show Number %d of the Week", i), i%2 == 0))
+	}
+	idx.Add(shows...)
+
+	start := time.Now()
+	const lookups = 100
+	for i := 0; i < lookups; i++ {
+		idx.Lookup("show number 123", ModePrefix, 10)
+	}
+	// generous, so slow test machines don't fail it
+	if perLookup := time.Since(start) / lookups; perLookup > 5*time.Millisecond {
+		t.Errorf("prefix lookups too slow: %v each", perLookup)
+	}
+}
@@ -0,0 +1,55 @@
+// Keeping the index filled from episodate's popular shows
+
+package showindex
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/jobs"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/net/context"
+)
+
+const (
+	// RefreshJob is the job type that adds popular shows to the index
+	RefreshJob = "showindex.refresh"
+
+	// popular show pages read per refresh, at 20 shows each
+	refreshPages = 50
+)
+
+// getPopularShows fetches a page of popular shows, swapped out in tests
+var getPopularShows = tvshowdata.GetPopularShows
+
+// RegisterJobs sets up the background job that refreshes the index
+func RegisterJobs() {
+	jobs.Register(RefreshJob, func(ctx context.Context, job jobs.Job) error {
+		added, err := Refresh(ctx)
+		fmt.Printf("showindex: refreshed %d shows, %d indexed\n", added, Size())
+		return err
+	})
+}
+
+// Refresh adds episodate's most popular shows to the default index,
+// returning how many were read. Shows from pages read before an error are
+// kept
+func Refresh(ctx context.Context) (int, error) {
+	added := 0
+	for page, pages := 1, 1; page <= pages && page <= refreshPages; page++ {
+		if err := ctx.Err(); err != nil {
+			return added, err
+		}
+
+		shows, total, err := getPopularShows(page)
+		if err != nil {
+			return added, errors.Wrapf(err, "Unable to refresh show index at page %d", page)
+		}
+
+		Add(shows.Shows...)
+		added += len(shows.Shows)
+		pages = total
+	}
+
+	return added, nil
+}
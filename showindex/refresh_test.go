@@ -0,0 +1,46 @@
+package showindex
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/net/context"
+)
+
+func TestRefresh(t *testing.T) {
+	oldIndex, oldGet := defaultIndex, getPopularShows
+	defer func() { defaultIndex, getPopularShows = oldIndex, oldGet }()
+
+	cases := []struct {
+		name      string
+		pages     int
+		failPage  int
+		wantAdded int
+		wantErr   bool
+	}{
+		{"all pages", 3, 0, 6, false},
+		{"error keeps earlier pages", 3, 2, 2, true},
+	}
+
+	for _, c := range cases {
+		defaultIndex = New()
+		getPopularShows = func(page int) (tvshowdata.Shows, int, error) {
+			if page == c.failPage {
+				return tvshowdata.Shows{}, 0, errors.New("api down")
+			}
+			id := int64(page * 10)
+			return tvshowdata.Shows{Shows: []tvshowdata.Show{show(id, "Popular A", true),
+				show(id+1, "Popular B", true)}}, c.pages, nil
+		}
+
+		added, err := Refresh(context.Background())
+		if added != c.wantAdded || Size() != c.wantAdded {
+			t.Errorf("incorrect output for '%s': expected '%d', got '%d' (size %d)",
+				c.name, c.wantAdded, added, Size())
+		}
+		if (err != nil) != c.wantErr {
+			t.Errorf("incorrect error for '%s': expected '%t', got '%v'", c.name, c.wantErr, err)
+		}
+	}
+}
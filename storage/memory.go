@@ -0,0 +1,304 @@
+// Process-local Repository, for tests and running without a database
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/oauth2"
+)
+
+// MemoryRepository is a Repository that keeps everything in memory
+type MemoryRepository struct {
+	mu          sync.RWMutex
+	users       map[string]User
+	tokens      map[string]oauth2.Token
+	sessions    map[string]session
+	events      map[string]string
+	preferences map[string]map[string]string
+	follows     map[string]subscriptions.Subscription
+	episodes    map[string]map[string]tvshowdata.Episode
+}
+
+type session struct {
+	userID  string
+	expires time.Time
+}
+
+// NewMemoryRepository returns an empty MemoryRepository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		users:       make(map[string]User),
+		tokens:      make(map[string]oauth2.Token),
+		sessions:    make(map[string]session),
+		events:      make(map[string]string),
+		preferences: make(map[string]map[string]string),
+		follows:     make(map[string]subscriptions.Subscription),
+		episodes:    make(map[string]map[string]tvshowdata.Episode),
+	}
+}
+
+// Close does nothing
+func (r *MemoryRepository) Close() error {
+	return nil
+}
+
+// GetUser returns the user with id
+func (r *MemoryRepository) GetUser(id string) (User, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	return user, ok, nil
+}
+
+// GetToken returns the user's OAuth token for provider
+func (r *MemoryRepository) GetToken(userID, provider string) (oauth2.Token, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	token, ok := r.tokens[providerKey(userID, provider)]
+	return token, ok, nil
+}
+
+// SetToken saves the user's OAuth token for provider
+func (r *MemoryRepository) SetToken(userID, provider string, token oauth2.Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ensureUser(userID)
+	r.tokens[providerKey(userID, provider)] = token
+	return nil
+}
+
+// GetSession returns the user for an unexpired session
+func (r *MemoryRepository) GetSession(key string) (string, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.sessions[key]
+	if !ok || !time.Now().Before(s.expires) {
+		return "", false, nil
+	}
+	return s.userID, true, nil
+}
+
+// SetSession saves the session for userID, dropping expired ones
+func (r *MemoryRepository) SetSession(key, userID string, expires time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, s := range r.sessions {
+		if !now.Before(s.expires) {
+			delete(r.sessions, k)
+		}
+	}
+	r.ensureUser(userID)
+	r.sessions[key] = session{userID: userID, expires: expires}
+	return nil
+}
+
+// DeleteSession forgets the session
+func (r *MemoryRepository) DeleteSession(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, key)
+	return nil
+}
+
+// GetEventID returns the calendar event synced for the user's episode key
+func (r *MemoryRepository) GetEventID(userID, provider, key string) (string, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	eventID, ok := r.events[eventKey(userID, provider, key)]
+	return eventID, ok, nil
+}
+
+// SetEventID records the calendar event synced for the user's episode key
+func (r *MemoryRepository) SetEventID(userID, provider, key, eventID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ensureUser(userID)
+	r.events[eventKey(userID, provider, key)] = eventID
+	return nil
+}
+
+// DeleteEventID forgets the calendar event synced for the user's episode key
+func (r *MemoryRepository) DeleteEventID(userID, provider, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.events, eventKey(userID, provider, key))
+	return nil
+}
+
+// GetTemplates returns the user's event templates, if they set any
+func (r *MemoryRepository) GetTemplates(userID string) (gcalwrapper.EventTemplates, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	prefs := r.preferences[userID]
+	summary, hasSummary := prefs[summaryTemplatePref]
+	description, hasDescription := prefs[descriptionTemplatePref]
+	if !hasSummary && !hasDescription {
+		return gcalwrapper.EventTemplates{}, false, nil
+	}
+
+	return gcalwrapper.EventTemplates{Summary: summary, Description: description}, true, nil
+}
+
+// SetTemplates saves the user's event templates
+func (r *MemoryRepository) SetTemplates(userID string, templates gcalwrapper.EventTemplates) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ensureUser(userID)
+	prefs, ok := r.preferences[userID]
+	if !ok {
+		prefs = make(map[string]string)
+		r.preferences[userID] = prefs
+	}
+	prefs[summaryTemplatePref] = templates.Summary
+	prefs[descriptionTemplatePref] = templates.Description
+	return nil
+}
+
+// DeleteTemplates resets the user to the default event templates
+func (r *MemoryRepository) DeleteTemplates(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.preferences[userID], summaryTemplatePref)
+	delete(r.preferences[userID], descriptionTemplatePref)
+	return nil
+}
+
+// AddSubscription saves sub, keeping the original time if already followed
+func (r *MemoryRepository) AddSubscription(sub subscriptions.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ensureUser(sub.UserID)
+	key := followKey(sub.UserID, sub.ShowID, sub.Provider)
+	if _, ok := r.follows[key]; !ok {
+		r.follows[key] = sub
+	}
+	return nil
+}
+
+// RemoveSubscription forgets a subscription and its synced episodes
+func (r *MemoryRepository) RemoveSubscription(userID string, showID int64, provider string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := followKey(userID, showID, provider)
+	_, ok := r.follows[key]
+	delete(r.follows, key)
+	delete(r.episodes, key)
+	return ok, nil
+}
+
+// UserSubscriptions returns the user's subscriptions, oldest first
+func (r *MemoryRepository) UserSubscriptions(userID string) ([]subscriptions.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var subs []subscriptions.Subscription
+	for _, sub := range r.follows {
+		if sub.UserID == userID {
+			subs = append(subs, sub)
+		}
+	}
+	sortFollows(subs)
+	return subs, nil
+}
+
+// AllSubscriptions returns every subscription, oldest first
+func (r *MemoryRepository) AllSubscriptions() ([]subscriptions.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var subs []subscriptions.Subscription
+	for _, sub := range r.follows {
+		subs = append(subs, sub)
+	}
+	sortFollows(subs)
+	return subs, nil
+}
+
+// SyncedEpisodes returns the episodes last written for sub
+func (r *MemoryRepository) SyncedEpisodes(sub subscriptions.Subscription) (map[string]tvshowdata.Episode, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	episodes := make(map[string]tvshowdata.Episode)
+	for key, episode := range r.episodes[followKey(sub.UserID, sub.ShowID, sub.Provider)] {
+		episodes[key] = episode
+	}
+	return episodes, nil
+}
+
+// SetSyncedEpisodes replaces the episodes last written for sub
+func (r *MemoryRepository) SetSyncedEpisodes(sub subscriptions.Subscription, episodes map[string]tvshowdata.Episode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := followKey(sub.UserID, sub.ShowID, sub.Provider)
+	if _, ok := r.follows[key]; !ok {
+		// unsubscribed while syncing
+		return nil
+	}
+
+	saved := make(map[string]tvshowdata.Episode, len(episodes))
+	for episodeKey, episode := range episodes {
+		saved[episodeKey] = episode
+	}
+	r.episodes[key] = saved
+	return nil
+}
+
+// ensureUser creates the user if they are new. Call with mu held
+func (r *MemoryRepository) ensureUser(userID string) {
+	if _, ok := r.users[userID]; !ok {
+		r.users[userID] = User{ID: userID, CreatedAt: time.Now().UTC()}
+	}
+}
+
+func providerKey(userID, provider string) string {
+	return userID + "/" + provider
+}
+
+func eventKey(userID, provider, key string) string {
+	return userID + "/" + provider + "/" + key
+}
+
+func followKey(userID string, showID int64, provider string) string {
+	return fmt.Sprintf("%s/%s/%d", userID, provider, showID)
+}
+
+// sortFollows orders subs the same way the SQLite repository does
+func sortFollows(subs []subscriptions.Subscription) {
+	sort.Slice(subs, func(i, j int) bool {
+		a, b := subs[i], subs[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		if a.UserID != b.UserID {
+			return a.UserID < b.UserID
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return a.ShowID < b.ShowID
+	})
+}
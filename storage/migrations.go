@@ -0,0 +1,135 @@
+// Versioned schema migrations for the SQLite repository
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// migration moves the schema from version-1 to version
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations in the order they run. Never edit one that has shipped, add
+// a new one instead
+var migrations = []migration{
+	{1, "create users and oauth identities", `
+		CREATE TABLE users (
+			id         TEXT PRIMARY KEY,
+			created_at INTEGER NOT NULL
+		);
+		CREATE TABLE oauth_identities (
+			user_id       TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider      TEXT NOT NULL,
+			access_token  TEXT NOT NULL,
+			token_type    TEXT NOT NULL,
+			refresh_token TEXT NOT NULL,
+			expiry        INTEGER NOT NULL,
+			PRIMARY KEY (user_id, provider)
+		);`},
+	{2, "create synced events", `
+		CREATE TABLE synced_events (
+			user_id     TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider    TEXT NOT NULL,
+			episode_key TEXT NOT NULL,
+			event_id    TEXT NOT NULL,
+			PRIMARY KEY (user_id, provider, episode_key)
+		);`},
+	{3, "create follows", `
+		CREATE TABLE follows (
+			user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider   TEXT NOT NULL,
+			show_id    INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (user_id, provider, show_id)
+		);
+		CREATE TABLE follow_episodes (
+			user_id     TEXT NOT NULL,
+			provider    TEXT NOT NULL,
+			show_id     INTEGER NOT NULL,
+			episode_key TEXT NOT NULL,
+			episode     TEXT NOT NULL,
+			PRIMARY KEY (user_id, provider, show_id, episode_key),
+			FOREIGN KEY (user_id, provider, show_id)
+				REFERENCES follows(user_id, provider, show_id) ON DELETE CASCADE
+		);`},
+	{4, "create preferences", `
+		CREATE TABLE preferences (
+			user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name    TEXT NOT NULL,
+			value   TEXT NOT NULL,
+			PRIMARY KEY (user_id, name)
+		);`},
+	{5, "create sessions", `
+		CREATE TABLE sessions (
+			id         TEXT PRIMARY KEY,
+			user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			expires_at INTEGER NOT NULL
+		);
+		CREATE INDEX sessions_expires_at ON sessions (expires_at);`},
+}
+
+// migrate brings the schema up to date, each migration in its own
+// transaction, returning the resulting version
+func migrate(db *sql.DB) (int, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to create schema_migrations")
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return current, errors.Wrap(err, "Unable to start migration")
+		}
+		if _, err := tx.Exec(m.sql); err != nil {
+			tx.Rollback()
+			return current, errors.Wrapf(err, "Migration %d (%s) failed", m.version, m.name)
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at)
+			VALUES (?, ?, ?)`, m.version, m.name, time.Now().UnixNano())
+		if err != nil {
+			tx.Rollback()
+			return current, errors.Wrapf(err, "Unable to record migration %d", m.version)
+		}
+		if err := tx.Commit(); err != nil {
+			return current, errors.Wrapf(err, "Unable to commit migration %d", m.version)
+		}
+
+		current = m.version
+		fmt.Printf("storage: migrated schema to version %d (%s)\n", m.version, m.name)
+	}
+
+	return current, nil
+}
+
+// schemaVersion is the latest migration applied to db
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to read schema version")
+	}
+
+	return version, nil
+}
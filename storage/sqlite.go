@@ -0,0 +1,377 @@
+// Repository backed by a SQLite database file
+
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/oauth2"
+
+	// registers the pure Go "sqlite" driver
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteRepository is a Repository kept in a SQLite database
+type SQLiteRepository struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens (creating if needed) the database at path and migrates
+// it to the latest schema
+func OpenSQLite(path string) (*SQLiteRepository, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to open database")
+	}
+	// sqlite allows one writer at a time, so share a single connection
+	db.SetMaxOpenConns(1)
+
+	if _, err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteRepository{db: db}, nil
+}
+
+// Close closes the database
+func (r *SQLiteRepository) Close() error {
+	return r.db.Close()
+}
+
+// GetUser returns the user with id
+func (r *SQLiteRepository) GetUser(id string) (User, bool, error) {
+	var createdAt int64
+	err := r.db.QueryRow(`SELECT created_at FROM users WHERE id = ?`, id).Scan(&createdAt)
+	if err == sql.ErrNoRows {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, errors.Wrap(err, "Error in GetUser()")
+	}
+
+	return User{ID: id, CreatedAt: fromUnixNano(createdAt)}, true, nil
+}
+
+// GetToken returns the user's OAuth token for provider
+func (r *SQLiteRepository) GetToken(userID, provider string) (oauth2.Token, bool, error) {
+	var token oauth2.Token
+	var expiry int64
+	err := r.db.QueryRow(`SELECT access_token, token_type, refresh_token, expiry
+		FROM oauth_identities WHERE user_id = ? AND provider = ?`, userID, provider).
+		Scan(&token.AccessToken, &token.TokenType, &token.RefreshToken, &expiry)
+	if err == sql.ErrNoRows {
+		return oauth2.Token{}, false, nil
+	}
+	if err != nil {
+		return oauth2.Token{}, false, errors.Wrap(err, "Error in GetToken()")
+	}
+
+	token.Expiry = fromUnixNano(expiry)
+	return token, true, nil
+}
+
+// SetToken saves the user's OAuth token for provider
+func (r *SQLiteRepository) SetToken(userID, provider string, token oauth2.Token) error {
+	return r.withUser(userID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO oauth_identities
+			(user_id, provider, access_token, token_type, refresh_token, expiry)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (user_id, provider) DO UPDATE SET
+				access_token = excluded.access_token, token_type = excluded.token_type,
+				refresh_token = excluded.refresh_token, expiry = excluded.expiry`,
+			userID, provider, token.AccessToken, token.TokenType, token.RefreshToken,
+			toUnixNano(token.Expiry))
+		return err
+	})
+}
+
+// GetSession returns the user for an unexpired session
+func (r *SQLiteRepository) GetSession(key string) (string, bool, error) {
+	var userID string
+	err := r.db.QueryRow(`SELECT user_id FROM sessions WHERE id = ? AND expires_at > ?`,
+		key, time.Now().UnixNano()).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err, "Error in GetSession()")
+	}
+
+	return userID, true, nil
+}
+
+// SetSession saves the session for userID, dropping expired ones
+func (r *SQLiteRepository) SetSession(key, userID string, expires time.Time) error {
+	return r.withUser(userID, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, time.Now().UnixNano()); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO sessions (id, user_id, expires_at) VALUES (?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, expires_at = excluded.expires_at`,
+			key, userID, expires.UnixNano())
+		return err
+	})
+}
+
+// DeleteSession forgets the session
+func (r *SQLiteRepository) DeleteSession(key string) error {
+	_, err := r.db.Exec(`DELETE FROM sessions WHERE id = ?`, key)
+	return errors.Wrap(err, "Error in DeleteSession()")
+}
+
+// GetEventID returns the calendar event synced for the user's episode key
+func (r *SQLiteRepository) GetEventID(userID, provider, key string) (string, bool, error) {
+	var eventID string
+	err := r.db.QueryRow(`SELECT event_id FROM synced_events
+		WHERE user_id = ? AND provider = ? AND episode_key = ?`, userID, provider, key).
+		Scan(&eventID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err, "Error in GetEventID()")
+	}
+
+	return eventID, true, nil
+}
+
+// SetEventID records the calendar event synced for the user's episode key
+func (r *SQLiteRepository) SetEventID(userID, provider, key, eventID string) error {
+	return r.withUser(userID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO synced_events (user_id, provider, episode_key, event_id)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (user_id, provider, episode_key) DO UPDATE SET event_id = excluded.event_id`,
+			userID, provider, key, eventID)
+		return err
+	})
+}
+
+// DeleteEventID forgets the calendar event synced for the user's episode key
+func (r *SQLiteRepository) DeleteEventID(userID, provider, key string) error {
+	_, err := r.db.Exec(`DELETE FROM synced_events
+		WHERE user_id = ? AND provider = ? AND episode_key = ?`, userID, provider, key)
+	return errors.Wrap(err, "Error in DeleteEventID()")
+}
+
+// GetTemplates returns the user's event templates, if they set any
+func (r *SQLiteRepository) GetTemplates(userID string) (gcalwrapper.EventTemplates, bool, error) {
+	rows, err := r.db.Query(`SELECT name, value FROM preferences
+		WHERE user_id = ? AND name IN (?, ?)`, userID, summaryTemplatePref, descriptionTemplatePref)
+	if err != nil {
+		return gcalwrapper.EventTemplates{}, false, errors.Wrap(err, "Error in GetTemplates()")
+	}
+	defer rows.Close()
+
+	var templates gcalwrapper.EventTemplates
+	found := false
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return gcalwrapper.EventTemplates{}, false, errors.Wrap(err, "Error in GetTemplates()")
+		}
+		found = true
+		if name == summaryTemplatePref {
+			templates.Summary = value
+		} else {
+			templates.Description = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return gcalwrapper.EventTemplates{}, false, errors.Wrap(err, "Error in GetTemplates()")
+	}
+
+	return templates, found, nil
+}
+
+// SetTemplates saves the user's event templates
+func (r *SQLiteRepository) SetTemplates(userID string, templates gcalwrapper.EventTemplates) error {
+	return r.withUser(userID, func(tx *sql.Tx) error {
+		prefs := map[string]string{
+			summaryTemplatePref:     templates.Summary,
+			descriptionTemplatePref: templates.Description,
+		}
+		for name, value := range prefs {
+			_, err := tx.Exec(`INSERT INTO preferences (user_id, name, value) VALUES (?, ?, ?)
+				ON CONFLICT (user_id, name) DO UPDATE SET value = excluded.value`,
+				userID, name, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteTemplates resets the user to the default event templates
+func (r *SQLiteRepository) DeleteTemplates(userID string) error {
+	_, err := r.db.Exec(`DELETE FROM preferences WHERE user_id = ? AND name IN (?, ?)`,
+		userID, summaryTemplatePref, descriptionTemplatePref)
+	return errors.Wrap(err, "Error in DeleteTemplates()")
+}
+
+// AddSubscription saves sub, keeping the original time if already followed
+func (r *SQLiteRepository) AddSubscription(sub subscriptions.Subscription) error {
+	return r.withUser(sub.UserID, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO follows (user_id, provider, show_id, created_at)
+			VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			sub.UserID, sub.Provider, sub.ShowID, toUnixNano(sub.CreatedAt))
+		return err
+	})
+}
+
+// RemoveSubscription forgets a subscription and its synced episodes
+func (r *SQLiteRepository) RemoveSubscription(userID string, showID int64, provider string) (bool, error) {
+	res, err := r.db.Exec(`DELETE FROM follows WHERE user_id = ? AND provider = ? AND show_id = ?`,
+		userID, provider, showID)
+	if err != nil {
+		return false, errors.Wrap(err, "Error in RemoveSubscription()")
+	}
+
+	removed, err := res.RowsAffected()
+	return removed > 0, errors.Wrap(err, "Error in RemoveSubscription()")
+}
+
+// UserSubscriptions returns the user's subscriptions, oldest first
+func (r *SQLiteRepository) UserSubscriptions(userID string) ([]subscriptions.Subscription, error) {
+	return r.querySubscriptions(`SELECT user_id, provider, show_id, created_at FROM follows
+		WHERE user_id = ? ORDER BY created_at, provider, show_id`, userID)
+}
+
+// AllSubscriptions returns every subscription, oldest first
+func (r *SQLiteRepository) AllSubscriptions() ([]subscriptions.Subscription, error) {
+	return r.querySubscriptions(`SELECT user_id, provider, show_id, created_at FROM follows
+		ORDER BY created_at, user_id, provider, show_id`)
+}
+
+// SyncedEpisodes returns the episodes last written for sub
+func (r *SQLiteRepository) SyncedEpisodes(sub subscriptions.Subscription) (map[string]tvshowdata.Episode, error) {
+	rows, err := r.db.Query(`SELECT episode_key, episode FROM follow_episodes
+		WHERE user_id = ? AND provider = ? AND show_id = ?`, sub.UserID, sub.Provider, sub.ShowID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error in SyncedEpisodes()")
+	}
+	defer rows.Close()
+
+	episodes := make(map[string]tvshowdata.Episode)
+	for rows.Next() {
+		var key string
+		var raw []byte
+		if err := rows.Scan(&key, &raw); err != nil {
+			return nil, errors.Wrap(err, "Error in SyncedEpisodes()")
+		}
+		var episode tvshowdata.Episode
+		if err := json.Unmarshal(raw, &episode); err != nil {
+			return nil, errors.Wrapf(err, "Invalid synced episode '%s'", key)
+		}
+		episodes[key] = episode
+	}
+
+	return episodes, errors.Wrap(rows.Err(), "Error in SyncedEpisodes()")
+}
+
+// SetSyncedEpisodes replaces the episodes last written for sub
+func (r *SQLiteRepository) SetSyncedEpisodes(sub subscriptions.Subscription, episodes map[string]tvshowdata.Episode) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		var followed int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM follows
+			WHERE user_id = ? AND provider = ? AND show_id = ?`, sub.UserID, sub.Provider, sub.ShowID).
+			Scan(&followed)
+		if err != nil {
+			return err
+		}
+		if followed == 0 {
+			// unsubscribed while syncing
+			return nil
+		}
+
+		_, err = tx.Exec(`DELETE FROM follow_episodes WHERE user_id = ? AND provider = ? AND show_id = ?`,
+			sub.UserID, sub.Provider, sub.ShowID)
+		if err != nil {
+			return err
+		}
+		for key, episode := range episodes {
+			raw, err := json.Marshal(episode)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO follow_episodes
+				(user_id, provider, show_id, episode_key, episode) VALUES (?, ?, ?, ?, ?)`,
+				sub.UserID, sub.Provider, sub.ShowID, key, raw)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *SQLiteRepository) querySubscriptions(query string, args ...interface{}) ([]subscriptions.Subscription, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to load subscriptions")
+	}
+	defer rows.Close()
+
+	var subs []subscriptions.Subscription
+	for rows.Next() {
+		var sub subscriptions.Subscription
+		var createdAt int64
+		if err := rows.Scan(&sub.UserID, &sub.Provider, &sub.ShowID, &createdAt); err != nil {
+			return nil, errors.Wrap(err, "Unable to load subscriptions")
+		}
+		sub.CreatedAt = fromUnixNano(createdAt)
+		subs = append(subs, sub)
+	}
+
+	return subs, errors.Wrap(rows.Err(), "Unable to load subscriptions")
+}
+
+// withUser runs fn in a transaction, first creating the user if they are new
+func (r *SQLiteRepository) withUser(userID string, fn func(tx *sql.Tx) error) error {
+	return r.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO users (id, created_at) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			userID, time.Now().UnixNano())
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+func (r *SQLiteRepository) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Unable to start transaction")
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "Database write failed")
+	}
+
+	return errors.Wrap(tx.Commit(), "Unable to commit transaction")
+}
+
+// times are stored as unix nanoseconds so they sort correctly, with zero
+// for the zero time
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
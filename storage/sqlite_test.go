@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "showcal.db")
+	first, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.SetToken("u", "google", oauth2.Token{AccessToken: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := schemaVersion(first.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].version
+	if version != latest {
+		t.Errorf("incorrect schema version: expected '%d', got '%d'", latest, version)
+	}
+	first.Close()
+
+	// reopening doesn't rerun migrations, and keeps the data
+	second, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	var applied int
+	if err := second.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("incorrect migrations applied: expected '%d', got '%d'", len(migrations), applied)
+	}
+	if token, ok, _ := second.GetToken("u", "google"); !ok || token.AccessToken != "a" {
+		t.Errorf("incorrect token after reopen: expected 'a', got '%s'", token.AccessToken)
+	}
+}
+
+func TestMigrationVersionsIncrease(t *testing.T) {
+	for idx, m := range migrations {
+		if m.version != idx+1 {
+			t.Errorf("incorrect version for migration '%s': expected '%d', got '%d'", m.name, idx+1, m.version)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Persistent storage for users, their logins, followed shows, synced
+// calendar events and preferences
+
+package storage
+
+import (
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+)
+
+// preference names
+const (
+	summaryTemplatePref     = "summary_template"
+	descriptionTemplatePref = "description_template"
+)
+
+// User is someone who has logged in to showCal
+type User struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Repository holds everything showCal persists about users. It can back
+// each of the stores the other packages use
+type Repository interface {
+	// OAuth identities, per user and calendar provider
+	gcalwrapper.TokenStore
+	// login sessions
+	gcalwrapper.SessionStore
+	// episode to calendar event mappings
+	gcalwrapper.EventStore
+	// preferences
+	gcalwrapper.TemplateStore
+	// followed shows
+	subscriptions.Store
+
+	// GetUser returns the user with id, who exists once anything is saved
+	// for them
+	GetUser(id string) (User, bool, error)
+	Close() error
+}
+
+// Use makes repo the store for users' logins, sessions, synced events, templates and
+// followed shows
+func Use(repo Repository) {
+	gcalwrapper.SetTokenStore(repo)
+	gcalwrapper.SetSessionStore(repo)
+	gcalwrapper.SetEventStore(repo)
+	gcalwrapper.SetTemplateStore(repo)
+	subscriptions.SetStore(repo)
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/subscriptions"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/oauth2"
+)
+
+// testRepository checks the Repository contract shared by every implementation
+func testRepository(t *testing.T, name string, r Repository) {
+	if _, ok, err := r.GetUser("u1"); ok || err != nil {
+		t.Errorf("%s: expected no user before anything is saved (%v)", name, err)
+	}
+
+	// oauth identities
+	expiry := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	token := oauth2.Token{AccessToken: "a", TokenType: "Bearer", RefreshToken: "r", Expiry: expiry}
+	if err := r.SetToken("u1", "google", token); err != nil {
+		t.Fatalf("%s: unable to save token: %v", name, err)
+	}
+	token.AccessToken = "b"
+	if err := r.SetToken("u1", "google", token); err != nil {
+		t.Fatalf("%s: unable to replace token: %v", name, err)
+	}
+	got, ok, err := r.GetToken("u1", "google")
+	if err != nil {
+		t.Fatalf("%s: unable to get token: %v", name, err)
+	}
+	if !ok || got.AccessToken != "b" || got.RefreshToken != "r" || !got.Expiry.Equal(expiry) {
+		t.Errorf("%s: incorrect token: expected '%v', got '%v'", name, token, got)
+	}
+	if _, ok, _ := r.GetToken("u1", "outlook"); ok {
+		t.Errorf("%s: expected no outlook token", name)
+	}
+	if user, ok, err := r.GetUser("u1"); !ok || err != nil || user.ID != "u1" || user.CreatedAt.IsZero() {
+		t.Errorf("%s: expected user to be created with their login, got '%v' (%v)", name, user, err)
+	}
+
+	// sessions
+	if err := r.SetSession("s1", "u1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("%s: unable to save session: %v", name, err)
+	}
+	if err := r.SetSession("s2", "u1", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("%s: unable to save session: %v", name, err)
+	}
+	if userID, ok, err := r.GetSession("s1"); !ok || err != nil || userID != "u1" {
+		t.Errorf("%s: incorrect session: expected 'u1', got '%s' (%v)", name, userID, err)
+	}
+	if _, ok, _ := r.GetSession("s2"); ok {
+		t.Errorf("%s: expected expired session to be rejected", name)
+	}
+	if err := r.DeleteSession("s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := r.GetSession("s1"); ok {
+		t.Errorf("%s: expected session to be deleted", name)
+	}
+
+	// synced events
+	if err := r.SetEventID("u1", "google", "k", "e1"); err != nil {
+		t.Fatalf("%s: unable to save event: %v", name, err)
+	}
+	if err := r.SetEventID("u1", "google", "k", "e2"); err != nil {
+		t.Fatalf("%s: unable to replace event: %v", name, err)
+	}
+	if eventID, ok, _ := r.GetEventID("u1", "google", "k"); !ok || eventID != "e2" {
+		t.Errorf("%s: incorrect event id: expected 'e2', got '%s'", name, eventID)
+	}
+	if _, ok, _ := r.GetEventID("u1", "outlook", "k"); ok {
+		t.Errorf("%s: expected events to be kept per provider", name)
+	}
+	if err := r.DeleteEventID("u1", "google", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := r.GetEventID("u1", "google", "k"); ok {
+		t.Errorf("%s: expected event id to be deleted", name)
+	}
+
+	// preferences
+	if _, ok, _ := r.GetTemplates("u1"); ok {
+		t.Errorf("%s: expected no templates before they are set", name)
+	}
+	templates := gcalwrapper.EventTemplates{Summary: "{{.Title}}", Description: ""}
+	if err := r.SetTemplates("u1", templates); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok, _ := r.GetTemplates("u1"); !ok || got != templates {
+		t.Errorf("%s: incorrect templates: expected '%v', got '%v'", name, templates, got)
+	}
+	if err := r.DeleteTemplates("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := r.GetTemplates("u1"); ok {
+		t.Errorf("%s: expected templates to be deleted", name)
+	}
+
+	// follows
+	now := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	follows := []subscriptions.Subscription{
+		{UserID: "u2", ShowID: 7, Provider: "google", CreatedAt: now.Add(time.Minute)},
+		{UserID: "u1", ShowID: 5, Provider: "google", CreatedAt: now},
+		{UserID: "u1", ShowID: 6, Provider: "outlook", CreatedAt: now.Add(time.Hour)},
+	}
+	for _, sub := range follows {
+		if err := r.AddSubscription(sub); err != nil {
+			t.Fatalf("%s: unable to follow: %v", name, err)
+		}
+	}
+	refollow := follows[1]
+	refollow.CreatedAt = now.Add(2 * time.Hour)
+	if err := r.AddSubscription(refollow); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err := r.AllSubscriptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		showID    int64
+		createdAt time.Time
+	}{
+		{5, now},
+		{7, now.Add(time.Minute)},
+		{6, now.Add(time.Hour)},
+	}
+	if len(subs) != len(cases) {
+		t.Fatalf("%s: incorrect subscription count: expected '%d', got '%d'", name, len(cases), len(subs))
+	}
+	for idx, c := range cases {
+		if subs[idx].ShowID != c.showID || !subs[idx].CreatedAt.Equal(c.createdAt) {
+			t.Errorf("%s: incorrect subscription %d: expected '%d' at '%v', got '%v'",
+				name, idx, c.showID, c.createdAt, subs[idx])
+		}
+	}
+	if subs, _ := r.UserSubscriptions("u1"); len(subs) != 2 {
+		t.Errorf("%s: incorrect subscriptions for u1: expected '2', got '%d'", name, len(subs))
+	}
+
+	airDate := tvshowdata.Time{Time: now.Add(24 * time.Hour)}
+	episodes := map[string]tvshowdata.Episode{
+		"a": {Season: 1, Episode: 1, Title: "Pilot", AirDate: airDate, ShowName: "Show"},
+		"b": {Season: 1, Episode: 2, Title: "Two", AirDate: airDate, ShowName: "Show"},
+	}
+	if err := r.SetSyncedEpisodes(follows[1], episodes); err != nil {
+		t.Fatal(err)
+	}
+	delete(episodes, "b")
+	if err := r.SetSyncedEpisodes(follows[1], episodes); err != nil {
+		t.Fatal(err)
+	}
+	synced, err := r.SyncedEpisodes(follows[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 1 || synced["a"].Title != "Pilot" || !synced["a"].AirDate.Equal(airDate.Time) {
+		t.Errorf("%s: incorrect synced episodes: expected '%v', got '%v'", name, episodes, synced)
+	}
+
+	// saving for a show that's no longer followed is ignored
+	gone := subscriptions.Subscription{UserID: "u1", ShowID: 99, Provider: "google"}
+	if err := r.SetSyncedEpisodes(gone, episodes); err != nil {
+		t.Fatal(err)
+	}
+	if synced, _ := r.SyncedEpisodes(gone); len(synced) != 0 {
+		t.Errorf("%s: expected no episodes for an unfollowed show, got '%v'", name, synced)
+	}
+
+	if removed, err := r.RemoveSubscription("u1", 5, "google"); !removed || err != nil {
+		t.Errorf("%s: expected follow to be removed (%v)", name, err)
+	}
+	if removed, _ := r.RemoveSubscription("u1", 5, "google"); removed {
+		t.Errorf("%s: expected second remove to report nothing removed", name)
+	}
+	if synced, _ := r.SyncedEpisodes(follows[1]); len(synced) != 0 {
+		t.Errorf("%s: expected synced episodes to be removed with the follow, got '%v'", name, synced)
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	testRepository(t, "memory", NewMemoryRepository())
+
+	sqlite, err := OpenSQLite(filepath.Join(t.TempDir(), "showcal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	testRepository(t, "sqlite", sqlite)
+}
@@ -0,0 +1,505 @@
+// Follows TV shows for users and keeps their calendars in sync as new
+// episodes are announced
+
+package subscriptions
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/jobs"
+	"github.com/swayne275/showcal-backend-go/notify"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"github.com/swayne275/showcal-backend-go/webhooks"
+	"golang.org/x/net/context"
+)
+
+// background job types
+const (
+	// SyncAllJob syncs every subscription, and is meant to be scheduled
+	SyncAllJob = "subscriptions.sync_all"
+	syncJob    = "subscriptions.sync"
+)
+
+// syncPayload identifies the subscription for a syncJob
+type syncPayload struct {
+	UserID   string `json:"user_id"`
+	ShowID   int64  `json:"show_id"`
+	Provider string `json:"calendar"`
+}
+
+// airDateNotification is the data sent when an episode moves
+type airDateNotification struct {
+	ShowID     int64     `json:"show_id"`
+	Calendar   string    `json:"calendar"`
+	Key        string    `json:"key"`
+	ShowName   string    `json:"show_name"`
+	Title      string    `json:"name"`
+	OldAirDate time.Time `json:"old_air_date"`
+	NewAirDate time.Time `json:"new_air_date"`
+}
+
+// episodeNotification is the data sent when an episode is added or cancelled
+type episodeNotification struct {
+	ShowID   int64     `json:"show_id"`
+	Calendar string    `json:"calendar"`
+	Key      string    `json:"key"`
+	ShowName string    `json:"show_name"`
+	Title    string    `json:"name"`
+	Season   int64     `json:"season"`
+	Episode  int64     `json:"episode"`
+	AirDate  time.Time `json:"air_date"`
+}
+
+// Subscription is a user following a show into one of their calendars
+type Subscription struct {
+	UserID    string    `json:"-"`
+	ShowID    int64     `json:"show_id"`
+	Provider  string    `json:"calendar"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SyncResult summarizes syncing a single subscription
+type SyncResult struct {
+	Subscription
+	Added   int    `json:"added"`
+	Updated int    `json:"updated"`
+	Removed int    `json:"removed"`
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Store persists subscriptions, and the episodes last written to the
+// calendar for each one (keyed by gcalwrapper.EpisodeKey)
+type Store interface {
+	AddSubscription(sub Subscription) error
+	// RemoveSubscription reports if the subscription existed
+	RemoveSubscription(userID string, showID int64, provider string) (bool, error)
+	UserSubscriptions(userID string) ([]Subscription, error)
+	AllSubscriptions() ([]Subscription, error)
+	SyncedEpisodes(sub Subscription) (map[string]tvshowdata.Episode, error)
+	SetSyncedEpisodes(sub Subscription, episodes map[string]tvshowdata.Episode) error
+}
+
+// memoryStore is the default, process-local Store
+type memoryStore struct {
+	mu       sync.RWMutex
+	subs     map[string]Subscription
+	episodes map[string]map[string]tvshowdata.Episode
+}
+
+var store Store = newMemoryStore()
+
+// calls out to other packages, swapped out in tests
+var (
+	fetchEpisodes  = tvshowdata.FetchUpcomingEpisodes
+	addEpisodes    = gcalwrapper.AddEpisodesToCalendar
+	removeEpisodes = gcalwrapper.RemoveEpisodesFromCalendar
+)
+
+// syncLocks keep the scheduler and on-demand syncs of a subscription from
+// racing on its snapshot, without one slow sync holding up everyone else's
+var (
+	syncLocks   = make(map[string]*syncLock)
+	syncLocksMu sync.Mutex
+)
+
+// syncLock is a subscription's lock, kept while anyone holds or waits for it
+type syncLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		subs:     make(map[string]Subscription),
+		episodes: make(map[string]map[string]tvshowdata.Episode),
+	}
+}
+
+func subscriptionKey(userID string, showID int64, provider string) string {
+	return fmt.Sprintf("%s/%s/%d", userID, provider, showID)
+}
+
+// AddSubscription saves sub, keeping the original time if already followed
+func (s *memoryStore) AddSubscription(sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := subscriptionKey(sub.UserID, sub.ShowID, sub.Provider)
+	if _, ok := s.subs[key]; !ok {
+		s.subs[key] = sub
+	}
+	return nil
+}
+
+// RemoveSubscription forgets a subscription and its synced episodes
+func (s *memoryStore) RemoveSubscription(userID string, showID int64, provider string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := subscriptionKey(userID, showID, provider)
+	_, ok := s.subs[key]
+	delete(s.subs, key)
+	delete(s.episodes, key)
+	return ok, nil
+}
+
+// UserSubscriptions returns the user's subscriptions, oldest first
+func (s *memoryStore) UserSubscriptions(userID string) ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var subs []Subscription
+	for _, sub := range s.subs {
+		if sub.UserID == userID {
+			subs = append(subs, sub)
+		}
+	}
+	sortSubscriptions(subs)
+	return subs, nil
+}
+
+// AllSubscriptions returns every subscription, oldest first
+func (s *memoryStore) AllSubscriptions() ([]Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subs := make([]Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	sortSubscriptions(subs)
+	return subs, nil
+}
+
+// SyncedEpisodes returns the episodes last written for sub
+func (s *memoryStore) SyncedEpisodes(sub Subscription) (map[string]tvshowdata.Episode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	episodes := make(map[string]tvshowdata.Episode)
+	for key, episode := range s.episodes[subscriptionKey(sub.UserID, sub.ShowID, sub.Provider)] {
+		episodes[key] = episode
+	}
+	return episodes, nil
+}
+
+// SetSyncedEpisodes replaces the episodes last written for sub
+func (s *memoryStore) SetSyncedEpisodes(sub Subscription, episodes map[string]tvshowdata.Episode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := subscriptionKey(sub.UserID, sub.ShowID, sub.Provider)
+	if _, ok := s.subs[key]; !ok {
+		// unsubscribed while syncing
+		return nil
+	}
+	s.episodes[key] = episodes
+	return nil
+}
+
+func sortSubscriptions(subs []Subscription) {
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+		return subscriptionKey(subs[i].UserID, subs[i].ShowID, subs[i].Provider) <
+			subscriptionKey(subs[j].UserID, subs[j].ShowID, subs[j].Provider)
+	})
+}
+
+// SetStore replaces the default in-memory subscription storage
+func SetStore(s Store) {
+	store = s
+}
+
+// Subscribe follows showID into the user's calendar with provider
+func Subscribe(userID string, showID int64, provider string) (Subscription, error) {
+	if userID == "" || showID < 1 || provider == "" {
+		return Subscription{}, errors.New("Invalid subscription")
+	}
+
+	sub := Subscription{UserID: userID, ShowID: showID, Provider: provider,
+		CreatedAt: time.Now().UTC()}
+	if err := store.AddSubscription(sub); err != nil {
+		return Subscription{}, errors.Wrap(err, "Error in Subscribe()")
+	}
+
+	return sub, nil
+}
+
+// Unsubscribe stops syncing showID for the user. Events already in the
+// calendar are left alone. Reports false if the user wasn't subscribed
+func Unsubscribe(userID string, showID int64, provider string) (bool, error) {
+	removed, err := store.RemoveSubscription(userID, showID, provider)
+	if err != nil {
+		return false, errors.Wrap(err, "Error in Unsubscribe()")
+	}
+
+	return removed, nil
+}
+
+// UserSubscriptions lists the shows the user follows
+func UserSubscriptions(userID string) ([]Subscription, error) {
+	subs, err := store.UserSubscriptions(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error in UserSubscriptions()")
+	}
+
+	return subs, nil
+}
+
+// SyncAll brings every subscription up to date, fetching each show once
+func SyncAll(ctx context.Context) []SyncResult {
+	subs, err := store.AllSubscriptions()
+	if err != nil {
+		fmt.Println("error in SyncAll()", err)
+		return nil
+	}
+
+	type fetched struct {
+		episodes tvshowdata.Episodes
+		err      error
+	}
+	shows := make(map[int64]fetched)
+
+	results := make([]SyncResult, 0, len(subs))
+	for _, sub := range subs {
+		show, ok := shows[sub.ShowID]
+		if !ok {
+			show.episodes, show.err = fetchEpisodes(sub.ShowID)
+			shows[sub.ShowID] = show
+		}
+
+		results = append(results, syncSubscription(ctx, sub, show.episodes, show.err))
+	}
+
+	return results
+}
+
+// SyncSubscription brings a single subscription up to date
+func SyncSubscription(ctx context.Context, sub Subscription) SyncResult {
+	episodes, err := fetchEpisodes(sub.ShowID)
+	return syncSubscription(ctx, sub, episodes, err)
+}
+
+// RegisterJobs sets up the background jobs that sync subscriptions
+func RegisterJobs() {
+	jobs.Register(SyncAllJob, func(ctx context.Context, job jobs.Job) error {
+		for _, result := range SyncAll(ctx) {
+			if result.Error != "" {
+				// the next scheduled run tries again
+				fmt.Printf("subscription sync failed for show %d: %s\n",
+					result.ShowID, result.Error)
+			}
+		}
+		return nil
+	})
+
+	jobs.Register(syncJob, func(ctx context.Context, job jobs.Job) error {
+		var payload syncPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return errors.Wrap(err, "Invalid subscription sync payload")
+		}
+
+		sub := Subscription{UserID: payload.UserID, ShowID: payload.ShowID,
+			Provider: payload.Provider}
+		if result := SyncSubscription(ctx, sub); result.Error != "" {
+			return errors.New(result.Error)
+		}
+		return nil
+	})
+}
+
+// lockSubscription locks sub for syncing, returning the function that
+// unlocks it
+func lockSubscription(sub Subscription) func() {
+	key := subscriptionKey(sub.UserID, sub.ShowID, sub.Provider)
+
+	syncLocksMu.Lock()
+	lock, ok := syncLocks[key]
+	if !ok {
+		lock = &syncLock{}
+		syncLocks[key] = lock
+	}
+	lock.users++
+	syncLocksMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		syncLocksMu.Lock()
+		defer syncLocksMu.Unlock()
+		lock.users--
+		if lock.users == 0 {
+			delete(syncLocks, key)
+		}
+	}
+}
+
+// EnqueueSync queues a background sync of sub, for new subscriptions that
+// shouldn't wait for the next scheduled run
+func EnqueueSync(sub Subscription) (jobs.Job, error) {
+	payload := syncPayload{UserID: sub.UserID, ShowID: sub.ShowID, Provider: sub.Provider}
+	return jobs.Enqueue(syncJob, payload)
+}
+
+// Diffs the upcoming episodes against what was last written for sub, then
+// creates, updates and deletes calendar events to match. Only successful
+// writes are recorded, so failures are retried on the next sync
+func syncSubscription(ctx context.Context, sub Subscription, upcoming tvshowdata.Episodes,
+	fetchErr error) SyncResult {
+	defer lockSubscription(sub)()
+
+	result := SyncResult{Subscription: sub}
+	if fetchErr != nil {
+		result.Error = errors.Wrap(fetchErr, "Error fetching show").Error()
+		return result
+	}
+
+	stored, err := store.SyncedEpisodes(sub)
+	if err != nil {
+		result.Error = errors.Wrap(err, "Error loading synced episodes").Error()
+		return result
+	}
+	// keys change as episodes gain provider IDs, so go by their current keys
+	previous := make(map[string]tvshowdata.Episode, len(stored))
+	for _, episode := range stored {
+		previous[gcalwrapper.EpisodeKey(episode)] = episode
+	}
+
+	now := time.Now()
+	synced := make(map[string]tvshowdata.Episode)
+	current := make(map[string]tvshowdata.Episode)
+	var changed tvshowdata.Episodes
+	for _, episode := range upcoming.Episodes {
+		key := gcalwrapper.EpisodeKey(episode)
+		current[key] = episode
+
+		old, ok := previous[key]
+		if ok && !episodeChanged(old, episode) {
+			synced[key] = old
+			continue
+		}
+		if ok {
+			// keep the old version until the update succeeds
+			synced[key] = old
+		}
+		changed.Episodes = append(changed.Episodes, episode)
+	}
+
+	// episodes that vanished before airing were cancelled or renumbered
+	var removed tvshowdata.Episodes
+	for key, old := range previous {
+		if _, ok := current[key]; !ok && old.AirDate.After(now) {
+			removed.Episodes = append(removed.Episodes, old)
+		}
+	}
+
+	var errs []string
+	if len(changed.Episodes) > 0 {
+		added, err := addEpisodes(ctx, sub.UserID, sub.Provider, changed)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		for _, event := range added.Events {
+			switch event.Status {
+			case gcalwrapper.EventCreated:
+				result.Added++
+				if _, ok := previous[event.Key]; !ok {
+					webhooks.Dispatch(sub.UserID, webhooks.EpisodeAdded,
+						newEpisodeNotification(sub, current[event.Key]))
+				}
+				synced[event.Key] = current[event.Key]
+			case gcalwrapper.EventUpdated:
+				result.Updated++
+				// added by hand before the show was followed, so nothing moved
+				if old, ok := previous[event.Key]; ok {
+					notifyAirDateChange(sub, old, current[event.Key])
+				}
+				synced[event.Key] = current[event.Key]
+			case gcalwrapper.EventFailed:
+				result.Failed++
+			}
+		}
+	}
+
+	if len(removed.Episodes) > 0 {
+		deleted, err := removeEpisodes(ctx, sub.UserID, sub.Provider, removed)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		for _, old := range removed.Episodes {
+			// keep trying to delete until the call succeeds
+			synced[gcalwrapper.EpisodeKey(old)] = old
+		}
+		for _, event := range deleted.Events {
+			switch event.Status {
+			case gcalwrapper.EventDeleted:
+				result.Removed++
+				webhooks.Dispatch(sub.UserID, webhooks.EpisodeCancelled,
+					newEpisodeNotification(sub, synced[event.Key]))
+				delete(synced, event.Key)
+			case gcalwrapper.EventSkipped:
+				delete(synced, event.Key)
+			case gcalwrapper.EventFailed:
+				result.Failed++
+			}
+		}
+	}
+
+	if err := store.SetSyncedEpisodes(sub, synced); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		result.Error = strings.Join(errs, "; ")
+	}
+
+	return result
+}
+
+// notifyAirDateChange tells the user a followed show moved an episode
+func notifyAirDateChange(sub Subscription, old, current tvshowdata.Episode) {
+	if old.AirDate.Equal(current.AirDate.Time) {
+		return
+	}
+
+	change := airDateNotification{
+		ShowID:     sub.ShowID,
+		Calendar:   sub.Provider,
+		Key:        gcalwrapper.EpisodeKey(current),
+		ShowName:   current.ShowName,
+		Title:      current.Title,
+		OldAirDate: old.AirDate.Time,
+		NewAirDate: current.AirDate.Time,
+	}
+	notify.Publish(sub.UserID, notify.AirDateChanged, change)
+	webhooks.Dispatch(sub.UserID, webhooks.EpisodeRescheduled, change)
+}
+
+func newEpisodeNotification(sub Subscription, episode tvshowdata.Episode) episodeNotification {
+	return episodeNotification{
+		ShowID:   sub.ShowID,
+		Calendar: sub.Provider,
+		Key:      gcalwrapper.EpisodeKey(episode),
+		ShowName: episode.ShowName,
+		Title:    episode.Title,
+		Season:   episode.Season,
+		Episode:  episode.Episode,
+		AirDate:  episode.AirDate.Time,
+	}
+}
+
+// episodeChanged reports if the calendar event for old needs updating
+func episodeChanged(old, current tvshowdata.Episode) bool {
+	return !old.AirDate.Equal(current.AirDate.Time) || old.Title != current.Title ||
+		old.RuntimeMinutes != current.RuntimeMinutes
+}
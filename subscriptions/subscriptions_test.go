@@ -0,0 +1,256 @@
+package subscriptions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/gcalwrapper"
+	"github.com/swayne275/showcal-backend-go/notify"
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+	"golang.org/x/net/context"
+)
+
+// fakeCalendar records calls in place of gcalwrapper, failing keys in fail
+type fakeCalendar struct {
+	saved   map[string]bool
+	added   []string
+	removed []string
+	fail    map[string]bool
+}
+
+func (f *fakeCalendar) add(ctx context.Context, userID, provider string, episodes tvshowdata.Episodes) (gcalwrapper.AddResult, error) {
+	result := gcalwrapper.AddResult{}
+	for _, episode := range episodes.Episodes {
+		key := gcalwrapper.EpisodeKey(episode)
+		f.added = append(f.added, key)
+		status := gcalwrapper.EventCreated
+		switch {
+		case f.fail[key]:
+			status = gcalwrapper.EventFailed
+		case f.saved[key]:
+			status = gcalwrapper.EventUpdated
+		default:
+			f.saved[key] = true
+		}
+		result.Events = append(result.Events, gcalwrapper.EventResult{Key: key, Status: status})
+	}
+
+	return result, nil
+}
+
+func (f *fakeCalendar) remove(ctx context.Context, userID, provider string, episodes tvshowdata.Episodes) (gcalwrapper.AddResult, error) {
+	result := gcalwrapper.AddResult{}
+	for _, episode := range episodes.Episodes {
+		key := gcalwrapper.EpisodeKey(episode)
+		f.removed = append(f.removed, key)
+		delete(f.saved, key)
+		result.Events = append(result.Events,
+			gcalwrapper.EventResult{Key: key, Status: gcalwrapper.EventDeleted})
+	}
+
+	return result, nil
+}
+
+// swap in fakes for the duration of a test
+func useFakes(t *testing.T, fetch func(int64) (tvshowdata.Episodes, error)) *fakeCalendar {
+	fake := &fakeCalendar{saved: make(map[string]bool), fail: make(map[string]bool)}
+	oldStore, oldFetch, oldAdd, oldRemove := store, fetchEpisodes, addEpisodes, removeEpisodes
+	store, fetchEpisodes, addEpisodes, removeEpisodes = newMemoryStore(), fetch, fake.add, fake.remove
+	t.Cleanup(func() {
+		store, fetchEpisodes, addEpisodes, removeEpisodes = oldStore, oldFetch, oldAdd, oldRemove
+	})
+
+	return fake
+}
+
+func episode(number int64, airDate time.Time, title string) tvshowdata.Episode {
+	return tvshowdata.Episode{Season: 1, Episode: number, Title: title, ShowName: "A",
+		AirDate: tvshowdata.Time{Time: airDate}, RuntimeMinutes: 30}
+}
+
+func TestSyncSubscription(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	var upcoming []tvshowdata.Episode
+	fake := useFakes(t, func(int64) (tvshowdata.Episodes, error) {
+		return tvshowdata.Episodes{Episodes: upcoming}, nil
+	})
+
+	sub, err := Subscribe("sync-user", 1, gcalwrapper.ProviderGoogle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, _ := notify.Listen("sync-user", 0)
+	defer listener.Close()
+
+	cases := []struct {
+		name     string
+		upcoming []tvshowdata.Episode
+		fail     string
+		want     SyncResult
+	}{
+		{"new episodes", []tvshowdata.Episode{episode(1, future, "B"), episode(2, future, "C")},
+			"", SyncResult{Added: 2}},
+		{"nothing changed", []tvshowdata.Episode{episode(1, future, "B"), episode(2, future, "C")},
+			"", SyncResult{}},
+		{"air date moved", []tvshowdata.Episode{episode(1, future.Add(time.Hour), "B"),
+			episode(2, future, "C")}, "", SyncResult{Updated: 1}},
+		{"cancelled", []tvshowdata.Episode{episode(1, future.Add(time.Hour), "B")},
+			"", SyncResult{Removed: 1}},
+		{"failed write", []tvshowdata.Episode{episode(1, future.Add(time.Hour), "B"),
+			episode(3, future, "D")}, "A/S01E03", SyncResult{Failed: 1}},
+		{"retried write", []tvshowdata.Episode{episode(1, future.Add(time.Hour), "B"),
+			episode(3, future, "D")}, "", SyncResult{Added: 1}},
+	}
+
+	for _, c := range cases {
+		upcoming = c.upcoming
+		fake.fail = map[string]bool{c.fail: true}
+		got := SyncSubscription(context.Background(), sub)
+
+		if got.Added != c.want.Added || got.Updated != c.want.Updated ||
+			got.Removed != c.want.Removed || got.Failed != c.want.Failed {
+			t.Errorf("incorrect output for '%s': expected '%+v', got '%+v'", c.name, c.want, got)
+		}
+	}
+
+	// only the moved episode is announced
+	select {
+	case event := <-listener.C:
+		if event.Type != notify.AirDateChanged {
+			t.Errorf("incorrect notification: expected '%s', got '%s'",
+				notify.AirDateChanged, event.Type)
+		}
+	default:
+		t.Errorf("expected an air date notification")
+	}
+	select {
+	case event := <-listener.C:
+		t.Errorf("unexpected notification: got '%+v'", event)
+	default:
+	}
+}
+
+func TestSyncAddedByHandNotRescheduled(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	fake := useFakes(t, func(int64) (tvshowdata.Episodes, error) {
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{episode(1, future, "B")}}, nil
+	})
+	// in the calendar before the show was followed
+	fake.saved[gcalwrapper.EpisodeKey(episode(1, future, "B"))] = true
+
+	sub, err := Subscribe("by-hand-user", 1, gcalwrapper.ProviderGoogle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, _ := notify.Listen("by-hand-user", 0)
+	defer listener.Close()
+
+	if got := SyncSubscription(context.Background(), sub); got.Updated != 1 {
+		t.Errorf("incorrect output: expected 1 updated, got '%+v'", got)
+	}
+	select {
+	case event := <-listener.C:
+		t.Errorf("unexpected notification: got '%+v'", event)
+	default:
+	}
+}
+
+func TestSyncAllFetchesEachShowOnce(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	fetches := make(map[int64]int)
+	useFakes(t, func(showID int64) (tvshowdata.Episodes, error) {
+		fetches[showID]++
+		if showID == 2 {
+			return tvshowdata.Episodes{}, errors.New("api down")
+		}
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{episode(1, future, "B")}}, nil
+	})
+
+	for _, userID := range []string{"u1", "u2"} {
+		for _, showID := range []int64{1, 2} {
+			if _, err := Subscribe(userID, showID, gcalwrapper.ProviderGoogle); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	results := SyncAll(context.Background())
+	if len(results) != 4 {
+		t.Fatalf("incorrect number of results: expected '4', got '%d'", len(results))
+	}
+	for _, result := range results {
+		gotErr := (result.Error != "")
+		if gotErr != (result.ShowID == 2) {
+			t.Errorf("incorrect error for show '%d': got '%s'", result.ShowID, result.Error)
+		}
+	}
+	if fetches[1] != 1 || fetches[2] != 1 {
+		t.Errorf("expected each show fetched once, got '%v'", fetches)
+	}
+}
+
+func TestSyncLocksPerSubscription(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	useFakes(t, func(int64) (tvshowdata.Episodes, error) {
+		return tvshowdata.Episodes{Episodes: []tvshowdata.Episode{episode(1, future, "B")}}, nil
+	})
+	started, release := make(chan struct{}), make(chan struct{})
+	addEpisodes = func(ctx context.Context, userID, provider string,
+		episodes tvshowdata.Episodes) (gcalwrapper.AddResult, error) {
+		if userID == "slow" {
+			close(started)
+			<-release
+		}
+		return gcalwrapper.AddResult{}, nil
+	}
+
+	slow, _ := Subscribe("slow", 1, gcalwrapper.ProviderGoogle)
+	fast, _ := Subscribe("fast", 1, gcalwrapper.ProviderGoogle)
+	done := make(chan struct{})
+	go func() {
+		SyncSubscription(context.Background(), slow)
+		close(done)
+	}()
+	<-started
+
+	// finishes while the other user's sync is still writing
+	SyncSubscription(context.Background(), fast)
+	close(release)
+	<-done
+
+	syncLocksMu.Lock()
+	defer syncLocksMu.Unlock()
+	if len(syncLocks) != 0 {
+		t.Errorf("expected unused locks to be dropped, got '%d'", len(syncLocks))
+	}
+}
+
+func TestSubscribeAndUnsubscribe(t *testing.T) {
+	useFakes(t, nil)
+
+	if _, err := Subscribe("u1", 0, gcalwrapper.ProviderGoogle); err == nil {
+		t.Errorf("expected error for invalid show")
+	}
+	first, _ := Subscribe("u1", 5, gcalwrapper.ProviderGoogle)
+	again, _ := Subscribe("u1", 5, gcalwrapper.ProviderGoogle)
+	subs, _ := UserSubscriptions("u1")
+	if len(subs) != 1 || !subs[0].CreatedAt.Equal(first.CreatedAt) || again.ShowID != 5 {
+		t.Errorf("expected resubscribing to keep one subscription, got '%+v'", subs)
+	}
+
+	cases := []struct {
+		showID int64
+		want   bool
+	}{
+		{5, true},
+		{5, false},
+	}
+	for _, c := range cases {
+		got, err := Unsubscribe("u1", c.showID, gcalwrapper.ProviderGoogle)
+		if err != nil || got != c.want {
+			t.Errorf("incorrect output for '%d': expected '%t', got '%t' (%v)",
+				c.showID, c.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// How precisely an episode's air date is known, and episodes sharing a slot
+
+package tvshowdata
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// AirDatePrecision is how much of an episode's AirDate is known
+type AirDatePrecision string
+
+// air date precisions
+const (
+	// PrecisionTime air dates are the time the episode airs
+	PrecisionTime AirDatePrecision = "time"
+	// PrecisionDate air dates are midnight UTC on the day it airs
+	PrecisionDate AirDatePrecision = "date"
+	// PrecisionUnknown episodes have no air date yet
+	PrecisionUnknown AirDatePrecision = "unknown"
+)
+
+// dates without a time, as sent for some episodes
+const dateOnlyFormat = "2006-01-02"
+
+// IsSpecial reports if the episode is a special, which episodate puts in
+// season 0
+func (e Episode) IsSpecial() bool {
+	return e.Season == 0
+}
+
+// DateOnly reports if only the day the episode airs is known
+func (e Episode) DateOnly() bool {
+	return e.AirDatePrecision == PrecisionDate
+}
+
+// LatestAirTime is the latest the episode could start: its AirDate, or
+// the end of its day when only the date is known
+func (e Episode) LatestAirTime() time.Time {
+	if e.DateOnly() {
+		return e.AirDate.Add(24*time.Hour - time.Nanosecond)
+	}
+	return e.AirDate.Time
+}
+
+// airDatePrecision works out the precision from the raw JSON air date
+func airDatePrecision(raw json.RawMessage) AirDatePrecision {
+	var airDate string
+	if err := json.Unmarshal(raw, &airDate); err != nil || airDate == "" {
+		return PrecisionUnknown
+	}
+	if _, err := time.Parse(dateOnlyFormat, airDate); err == nil {
+		return PrecisionDate
+	}
+
+	return PrecisionTime
+}
+
+// staggerSharedSlots finds episodes listed at the same time, like the two
+// parts of a double episode, and moves each part after the one before so
+// they follow each other in the slot
+func staggerSharedSlots(episodes []Episode) {
+	slots := make(map[time.Time][]int)
+	for idx, episode := range episodes {
+		if episode.AirDatePrecision == PrecisionTime {
+			slots[episode.AirDate.Time] = append(slots[episode.AirDate.Time], idx)
+		}
+	}
+
+	for _, parts := range slots {
+		if len(parts) < 2 {
+			continue
+		}
+		sort.Slice(parts, func(i, j int) bool {
+			a, b := episodes[parts[i]], episodes[parts[j]]
+			if a.Season != b.Season {
+				return a.Season < b.Season
+			}
+			return a.Episode < b.Episode
+		})
+
+		start := episodes[parts[0]].AirDate.Time
+		for number, idx := range parts {
+			episode := &episodes[idx]
+			episode.AirDate.Time = start
+			episode.SlotPart = number + 1
+			episode.SlotParts = len(parts)
+			start = start.Add(time.Duration(episode.RuntimeMinutes) * time.Minute)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package tvshowdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAirDatePrecision(t *testing.T) {
+	cases := []struct {
+		input string
+		want  AirDatePrecision
+	}{
+		{`"2019-09-03 02:00:00"`, PrecisionTime},
+		{`"2019-09-03T02:00:00Z"`, PrecisionTime},
+		{`"2019-09-03"`, PrecisionDate},
+		{`""`, PrecisionUnknown},
+		{`null`, PrecisionUnknown},
+		{``, PrecisionUnknown},
+	}
+
+	for _, c := range cases {
+		if got := airDatePrecision(json.RawMessage(c.input)); got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%s'", c.input, c.want, got)
+		}
+	}
+}
+
+func TestLatestAirTime(t *testing.T) {
+	day := time.Date(2019, 9, 3, 0, 0, 0, 0, time.UTC)
+
+	timed := Episode{AirDate: Time{day}, AirDatePrecision: PrecisionTime}
+	if got := timed.LatestAirTime(); !got.Equal(day) {
+		t.Errorf("incorrect output for timed episode: expected '%v', got '%v'", day, got)
+	}
+
+	dateOnly := Episode{AirDate: Time{day}, AirDatePrecision: PrecisionDate}
+	want := day.Add(24*time.Hour - time.Nanosecond)
+	if got := dateOnly.LatestAirTime(); !got.Equal(want) {
+		t.Errorf("incorrect output for date only episode: expected '%v', got '%v'", want, got)
+	}
+}
+
+func TestParseEpisodesAirDates(t *testing.T) {
+	input := "{\"tvShow\":{\"id\":1,\"name\":\"Show\",\"runtime\":30,\"episodes\":[" +
+		"{\"season\":2,\"episode\":2,\"name\":\"Finale Part 2\",\"air_date\":\"2119-09-03 02:00:00\"}," +
+		"{\"season\":2,\"episode\":1,\"name\":\"Finale Part 1\",\"air_date\":\"2119-09-03 02:00:00\"}," +
+		"{\"season\":0,\"episode\":0,\"name\":\"Holiday Special\",\"air_date\":\"2119-12-25\"}," +
+		"{\"season\":3,\"episode\":1,\"name\":\"Premiere\",\"air_date\":null}," +
+		"{\"season\":0,\"episode\":1,\"name\":\"Today\",\"air_date\":\"2019-09-01\"}]}}"
+	now := timeParseNoErr(timeStrFormat, "2019-09-01 12:00:00").Time
+
+	got, err := parseEpisodes(input, EpisodeFilter{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []string
+	for _, episode := range got.Episodes {
+		out = append(out, fmt.Sprintf("%s %s %s %d/%d", episode.Title,
+			episode.AirDate.Format(time.RFC3339), episode.AirDatePrecision,
+			episode.SlotPart, episode.SlotParts))
+	}
+	want := []string{
+		"Finale Part 2 2119-09-03T02:30:00Z time 2/2",
+		"Finale Part 1 2119-09-03T02:00:00Z time 1/2",
+		"Holiday Special 2119-12-25T00:00:00Z date 0/0",
+		"Today 2019-09-01T00:00:00Z date 0/0",
+	}
+	if strings.Join(out, "\n") != strings.Join(want, "\n") {
+		t.Errorf("incorrect output: expected '%v', got '%v'", want, out)
+	}
+
+	specials, err := parseEpisodes(input, EpisodeFilter{SpecialsOnly: true, IncludePast: true}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specials.Episodes) != 2 || !specials.Episodes[0].IsSpecial() {
+		t.Errorf("incorrect specials: expected '2', got '%+v'", specials.Episodes)
+	}
+}
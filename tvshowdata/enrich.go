@@ -0,0 +1,246 @@
+// Episode synopses, images and links from TVMaze, which episodate lacks
+
+package tvshowdata
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// TVMaze show lookup by name, with its episodes
+	upTVMazeShow = "https://api.tvmaze.com/singlesearch/shows?q=%s&embed=episodes"
+
+	// how long a show's TVMaze data is reused for
+	enrichCacheTTL = 6 * time.Hour
+	// shows kept in the enrichment cache
+	enrichCacheSize = 512
+	// enrichment is best effort, so don't hold up a response for TVMaze
+	tvMazeTimeout = 5 * time.Second
+)
+
+// EpisodeLinks are pages and external IDs for an episode. The IDs are for
+// the show, as TVMaze doesn't have per-episode ones
+type EpisodeLinks struct {
+	// Episode is the episode's TVMaze page
+	Episode string `json:"episode,omitempty"`
+	IMDbID  string `json:"imdb_id,omitempty"`
+	TVDBID  int64  `json:"tvdb_id,omitempty"`
+}
+
+// IMDbURL is the show's IMDb page, or "" without an IMDb ID
+func (l EpisodeLinks) IMDbURL() string {
+	if l.IMDbID == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://www.imdb.com/title/%s/", l.IMDbID)
+}
+
+// tvMazeShow is what enrichment uses from a TVMaze show
+type tvMazeShow struct {
+	imageURL string
+	// channel is the show's web channel, or else its network
+	channel Channel
+	imdbID  string
+	tvdbID  int64
+	// by season and episode number
+	episodes map[[2]int64]tvMazeEpisode
+}
+
+type tvMazeEpisode struct {
+	synopsis string
+	imageURL string
+	url      string
+}
+
+type cachedEnrichment struct {
+	// show is nil when TVMaze doesn't have it
+	show    *tvMazeShow
+	expires time.Time
+}
+
+var (
+	enrichCacheMu sync.Mutex
+	enrichCache   = make(map[string]cachedEnrichment)
+
+	tvMazeClient = &http.Client{Timeout: tvMazeTimeout}
+
+	htmlTags = regexp.MustCompile(`<[^>]*>`)
+)
+
+// fetchTVMazeShow gets the TVMaze show named showName, or "" if TVMaze
+// doesn't have one, swapped out in tests
+var fetchTVMazeShow = func(showName string) (string, error) {
+	showURL := fmt.Sprintf(upTVMazeShow, url.QueryEscape(showName))
+	resp, err := tvMazeClient.Get(showURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "error fetching data from TVMaze for url: %s", showURL)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		err := errors.New(fmt.Sprintf("error fetching data from TVMaze for url: %s: Got HTTP StatusCode: %d",
+			showURL, resp.StatusCode))
+		return "", err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading TVMaze response for url: %s", showURL)
+	}
+	return string(body), nil
+}
+
+// EnrichEpisodes fills in synopses, images and links for episodes from
+// TVMaze. It is best effort: episodes TVMaze doesn't know, or can't be
+// reached for, are returned as they were
+func EnrichEpisodes(episodes []Episode) []Episode {
+	enriched := make([]Episode, len(episodes))
+	copy(enriched, episodes)
+
+	shows := make(map[string]*tvMazeShow)
+	for idx := range enriched {
+		episode := &enriched[idx]
+		show, ok := shows[episode.ShowName]
+		if !ok {
+			var err error
+			if show, err = tvMazeShowData(episode.ShowName, time.Now()); err != nil {
+				fmt.Println("Error enriching episodes:", err)
+			}
+			shows[episode.ShowName] = show
+		}
+		if show != nil {
+			show.enrich(episode)
+		}
+	}
+
+	return enriched
+}
+
+// enrich fills in what episode is missing from the show's data
+func (s *tvMazeShow) enrich(episode *Episode) {
+	if episode.ShowImageURL == "" {
+		episode.ShowImageURL = s.imageURL
+	}
+	if episode.Links.IMDbID == "" {
+		episode.Links.IMDbID = s.imdbID
+	}
+	if episode.Links.TVDBID == 0 {
+		episode.Links.TVDBID = s.tvdbID
+	}
+	// episodate often lists web shows without a network, or as a TV one
+	if episode.WhereToWatch.Name == "" || (s.channel.Streaming && !episode.WhereToWatch.Streaming) {
+		episode.WhereToWatch = s.channel
+	}
+
+	details, ok := s.episodes[[2]int64{episode.Season, episode.Episode}]
+	if !ok {
+		return
+	}
+	if episode.Synopsis == "" {
+		episode.Synopsis = details.synopsis
+	}
+	if episode.ImageURL == "" {
+		episode.ImageURL = details.imageURL
+	}
+	if episode.Links.Episode == "" {
+		episode.Links.Episode = details.url
+	}
+}
+
+// tvMazeShowData returns the TVMaze data for showName, from the cache if it
+// was fetched recently. Shows TVMaze doesn't have are cached as nil, but
+// errors aren't cached, so they're retried
+func tvMazeShowData(showName string, now time.Time) (*tvMazeShow, error) {
+	key := NormalizeName(showName)
+	if key == "" {
+		return nil, nil
+	}
+
+	enrichCacheMu.Lock()
+	cached, ok := enrichCache[key]
+	enrichCacheMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.show, nil
+	}
+
+	resp, err := fetchTVMazeShow(showName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching TVMaze show '%s'", showName)
+	}
+	show, err := parseTVMazeShow(resp, showName)
+	if err != nil {
+		return nil, err
+	}
+
+	enrichCacheMu.Lock()
+	defer enrichCacheMu.Unlock()
+	if len(enrichCache) >= enrichCacheSize {
+		enrichCache = make(map[string]cachedEnrichment)
+	}
+	enrichCache[key] = cachedEnrichment{show: show, expires: now.Add(enrichCacheTTL)}
+
+	return show, nil
+}
+
+// Unmarshals a TVMaze show with embedded episodes. A different show to
+// showName (TVMaze's search is fuzzy) or an empty response means TVMaze
+// doesn't have it, and gives nil
+func parseTVMazeShow(showData, showName string) (*tvMazeShow, error) {
+	if strings.TrimSpace(showData) == "" || showData == "null" {
+		return nil, nil
+	}
+	if !gjson.Valid(showData) {
+		return nil, errors.New(fmt.Sprintf("invalid TVMaze data for '%s'", showName))
+	}
+	if NormalizeName(gjson.Get(showData, "name").String()) != NormalizeName(showName) {
+		return nil, nil
+	}
+
+	show := &tvMazeShow{
+		imageURL: gjson.Get(showData, "image.original").String(),
+		imdbID:   gjson.Get(showData, "externals.imdb").String(),
+		tvdbID:   gjson.Get(showData, "externals.thetvdb").Int(),
+		episodes: make(map[[2]int64]tvMazeEpisode),
+	}
+	if webChannel := gjson.Get(showData, "webChannel.name").String(); webChannel != "" {
+		show.channel = ChannelFor(webChannel, true)
+	} else {
+		show.channel = ChannelFor(gjson.Get(showData, "network.name").String(), false)
+	}
+
+	gjson.Get(showData, "_embedded.episodes").ForEach(func(key, value gjson.Result) bool {
+		number := [2]int64{value.Get("season").Int(), value.Get("number").Int()}
+		imageURL := value.Get("image.original").String()
+		if imageURL == "" {
+			imageURL = value.Get("image.medium").String()
+		}
+		show.episodes[number] = tvMazeEpisode{
+			synopsis: plainText(value.Get("summary").String()),
+			imageURL: imageURL,
+			url:      value.Get("url").String(),
+		}
+		return true
+	})
+
+	return show, nil
+}
+
+// plainText strips the HTML from a TVMaze summary
+func plainText(s string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTags.ReplaceAllString(s, "")))
+}
@@ -0,0 +1,128 @@
+package tvshowdata
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const tvMazeAmericanDad = `{"id":215,"name":"American Dad!","url":"https://www.tvmaze.com/shows/215/american-dad",
+"image":{"medium":"https://static.tvmaze.com/m/215.jpg","original":"https://static.tvmaze.com/o/215.jpg"},
+"externals":{"tvrage":2625,"thetvdb":73141,"imdb":"tt0397306"},
+"_embedded":{"episodes":[
+{"season":15,"number":21,"name":"Downtown","url":"https://www.tvmaze.com/episodes/1","summary":"<p>Stan &amp; Francine move <b>downtown</b>.</p>","image":{"medium":"https://static.tvmaze.com/m/e1.jpg","original":"https://static.tvmaze.com/o/e1.jpg"}},
+{"season":15,"number":22,"name":"Cheek","url":"https://www.tvmaze.com/episodes/2","summary":null,"image":null}]}}`
+
+func useTVMaze(t *testing.T, fetch func(string) (string, error)) {
+	oldFetch := fetchTVMazeShow
+	fetchTVMazeShow = fetch
+	enrichCache = make(map[string]cachedEnrichment)
+	t.Cleanup(func() {
+		fetchTVMazeShow = oldFetch
+		enrichCache = make(map[string]cachedEnrichment)
+	})
+}
+
+func TestEnrichEpisodes(t *testing.T) {
+	fetches := 0
+	useTVMaze(t, func(showName string) (string, error) {
+		fetches++
+		switch showName {
+		case "American Dad!":
+			return tvMazeAmericanDad, nil
+		case "Americn Dad":
+			// fuzzy TVMaze search found a different name
+			return tvMazeAmericanDad, nil
+		case "Down":
+			return "", errors.New("api down")
+		}
+		return "", nil
+	})
+
+	episodes := []Episode{
+		{ShowName: "American Dad!", Season: 15, Episode: 21},
+		{ShowName: "American Dad!", Season: 15, Episode: 22},
+		{ShowName: "American Dad!", Season: 15, Episode: 23, Synopsis: "kept"},
+		{ShowName: "Americn Dad", Season: 15, Episode: 21},
+		{ShowName: "Unknown", Season: 1, Episode: 1},
+		{ShowName: "Down", Season: 1, Episode: 1},
+	}
+	want := []Episode{
+		{ShowName: "American Dad!", Season: 15, Episode: 21, Synopsis: "Stan & Francine move downtown.",
+			ImageURL: "https://static.tvmaze.com/o/e1.jpg", ShowImageURL: "https://static.tvmaze.com/o/215.jpg",
+			Links: EpisodeLinks{Episode: "https://www.tvmaze.com/episodes/1", IMDbID: "tt0397306", TVDBID: 73141}},
+		{ShowName: "American Dad!", Season: 15, Episode: 22, ShowImageURL: "https://static.tvmaze.com/o/215.jpg",
+			Links: EpisodeLinks{Episode: "https://www.tvmaze.com/episodes/2", IMDbID: "tt0397306", TVDBID: 73141}},
+		{ShowName: "American Dad!", Season: 15, Episode: 23, Synopsis: "kept",
+			ShowImageURL: "https://static.tvmaze.com/o/215.jpg",
+			Links:        EpisodeLinks{IMDbID: "tt0397306", TVDBID: 73141}},
+		episodes[3],
+		episodes[4],
+		episodes[5],
+	}
+
+	got := EnrichEpisodes(episodes)
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Errorf("incorrect output for episode %d: expected '%+v', got '%+v'", idx, want[idx], got[idx])
+		}
+	}
+	if episodes[0].Synopsis != "" {
+		t.Errorf("input episodes were changed")
+	}
+	if fetches != 4 {
+		t.Errorf("incorrect fetches: expected '4', got '%d'", fetches)
+	}
+
+	// cached, apart from the failure
+	EnrichEpisodes(episodes)
+	if fetches != 5 {
+		t.Errorf("incorrect fetches after caching: expected '5', got '%d'", fetches)
+	}
+}
+
+func TestEpisodeLinksIMDbURL(t *testing.T) {
+	cases := []struct {
+		input EpisodeLinks
+		want  string
+	}{
+		{EpisodeLinks{IMDbID: "tt0397306"}, "https://www.imdb.com/title/tt0397306/"},
+		{EpisodeLinks{}, ""},
+	}
+
+	for _, c := range cases {
+		if got := c.input.IMDbURL(); got != c.want {
+			t.Errorf("incorrect output for '%+v': expected '%s', got '%s'", c.input, c.want, got)
+		}
+	}
+}
+
+func TestEnrichWebChannel(t *testing.T) {
+	useTVMaze(t, func(showName string) (string, error) {
+		switch showName {
+		case "Stranger Things":
+			return `{"name":"Stranger Things","network":null,"webChannel":{"id":1,"name":"Netflix"}}`, nil
+		case "Friends":
+			return `{"name":"Friends","network":{"id":1,"name":"NBC"},"webChannel":null}`, nil
+		}
+		return "", nil
+	})
+
+	cases := []struct {
+		input Episode
+		want  Channel
+	}{
+		// episodate had no network
+		{Episode{ShowName: "Stranger Things"}, ChannelFor("Netflix", true)},
+		// or listed a TV network
+		{Episode{ShowName: "Stranger Things", WhereToWatch: Channel{Name: "Netflix Kids"}}, ChannelFor("Netflix", true)},
+		{Episode{ShowName: "Friends"}, Channel{Name: "NBC"}},
+		{Episode{ShowName: "Friends", WhereToWatch: Channel{Name: "TBS"}}, Channel{Name: "TBS"}},
+	}
+
+	for _, c := range cases {
+		if got := EnrichEpisodes([]Episode{c.input})[0].WhereToWatch; got != c.want {
+			t.Errorf("incorrect output for '%+v': expected '%+v', got '%+v'", c.input, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// The next episode of a show, from episodate's countdown
+
+package tvshowdata
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+// NextEpisode is the next episode to air for a show, if any
+type NextEpisode struct {
+	ShowID   int64  `json:"show_id"`
+	ShowName string `json:"show_name"`
+	// Status is ShowStatusHiatus for running shows with nothing scheduled
+	Status ShowStatus `json:"status"`
+	// Episode is nil if nothing is scheduled
+	Episode *Episode `json:"episode"`
+}
+
+// GetNextEpisode gets the next episode to air for the show with queryID
+func GetNextEpisode(queryID int64) (NextEpisode, error) {
+	resp, err := httpGet(getShowDetailsURL(queryID))
+	if err != nil {
+		err = errors.Wrapf(err, "error calling httpGet wrapper in GetNextEpisode()")
+		return NextEpisode{}, err
+	}
+
+	return parseNextEpisode(resp, queryID, time.Now())
+}
+
+// Unmarshals the next episode from the countdown, falling back to the
+// episode list when the countdown has already aired
+func parseNextEpisode(showData string, queryID int64, now time.Time) (NextEpisode, error) {
+	errMsg := fmt.Sprintf("invalid next episode data for queryID %d", queryID)
+
+	show := gjson.Get(showData, "tvShow")
+	if show.IsArray() {
+		// unknown IDs get an empty list rather than an error
+		return NextEpisode{}, errors.Wrapf(ErrShowNotFound, "queryID %d", queryID)
+	}
+	if !show.IsObject() {
+		err := errors.New(fmt.Sprintf("%s: missing/invalid 'tvShow' in api response", errMsg))
+		return NextEpisode{}, err
+	}
+
+	next := NextEpisode{ShowID: show.Get("id").Int(), ShowName: show.Get("name").String()}
+	if next.ShowID == 0 || next.ShowName == "" {
+		err := errors.New(fmt.Sprintf("%s: Missing/invalid 'id' or 'name' in api response", errMsg))
+		return NextEpisode{}, err
+	}
+
+	countdown := show.Get("countdown")
+	if countdown.IsObject() {
+		episode := Episode{}
+		if err := json.Unmarshal([]byte(countdown.Raw), &episode); err != nil {
+			return NextEpisode{}, errors.Wrapf(err, "%s: invalid 'countdown'", errMsg)
+		}
+		if episode.LatestAirTime().After(now) {
+			episode.RuntimeMinutes = show.Get("runtime").Int()
+			episode.ShowName = next.ShowName
+			episode.Network = show.Get("network").String()
+			episode.WhereToWatch = ChannelFor(episode.Network, false)
+			episode.ShowURL = show.Get("url").String()
+			episode.ShowImageURL = show.Get("image_path").String()
+			episode.SetEpisodateIDs(next.ShowID)
+			next.Episode = &episode
+		}
+	}
+
+	if next.Episode == nil && show.Get("episodes").IsArray() {
+		// the countdown lags behind, so check the list
+		upcoming, err := parseEpisodes(showData, EpisodeFilter{Limit: 1}, now)
+		if err == nil && len(upcoming.Episodes) > 0 {
+			next.Episode = &upcoming.Episodes[0]
+		}
+	}
+
+	next.Status = nextStatus(ParseShowStatus(show.Get("status").String()), next.Episode != nil)
+	return next, nil
+}
+
+// nextStatus adjusts episodate's status for whether an episode is scheduled
+func nextStatus(status ShowStatus, scheduled bool) ShowStatus {
+	switch {
+	case !scheduled && status == ShowStatusRunning:
+		return ShowStatusHiatus
+	case scheduled && (status == ShowStatusToBeDetermined || status == ShowStatusUnknown):
+		// it has been determined after all
+		return ShowStatusRunning
+	}
+
+	return status
+}
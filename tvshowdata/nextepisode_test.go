@@ -0,0 +1,106 @@
+package tvshowdata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseNextEpisode(t *testing.T) {
+	now := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name         string
+		input        string
+		wantStatus   ShowStatus
+		wantEpisode  int64
+		wantErr      bool
+		wantNotFound bool
+	}{
+		{
+			name:        "countdown",
+			input:       "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"status\":\"Running\",\"runtime\":30,\"countdown\":{\"season\":15,\"episode\":21,\"name\":\"Downtown\",\"air_date\":\"2019-09-03 02:00:00\"},\"episodes\":[]}}",
+			wantStatus:  ShowStatusRunning,
+			wantEpisode: 21,
+		},
+		{
+			name:        "stale countdown",
+			input:       "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"status\":\"Running\",\"runtime\":30,\"countdown\":{\"season\":15,\"episode\":20,\"name\":\"Rogu\",\"air_date\":\"2019-08-27 02:00:00\"},\"episodes\":[{\"season\":15,\"episode\":22,\"name\":\"Cheek\",\"air_date\":\"2019-09-10 02:00:00\"},{\"season\":15,\"episode\":21,\"name\":\"Downtown\",\"air_date\":\"2019-09-03 02:00:00\"}]}}",
+			wantStatus:  ShowStatusRunning,
+			wantEpisode: 21,
+		},
+		{
+			name:       "hiatus",
+			input:      "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"status\":\"Running\",\"runtime\":30,\"countdown\":null,\"episodes\":[]}}",
+			wantStatus: ShowStatusHiatus,
+		},
+		{
+			name:       "to be determined",
+			input:      "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"status\":\"To Be Determined\",\"countdown\":null}}",
+			wantStatus: ShowStatusToBeDetermined,
+		},
+		{
+			name:       "ended",
+			input:      "{\"tvShow\":{\"id\":3564,\"name\":\"Friends\",\"status\":\"Ended\",\"runtime\":30,\"countdown\":null,\"episodes\":[]}}",
+			wantStatus: ShowStatusEnded,
+		},
+		{
+			name:       "canceled",
+			input:      "{\"tvShow\":{\"id\":3564,\"name\":\"Firefly\",\"status\":\"Canceled/Ended\",\"countdown\":null}}",
+			wantStatus: ShowStatusCanceled,
+		},
+		{
+			name:         "unknown show",
+			input:        "{\"tvShow\":[]}",
+			wantErr:      true,
+			wantNotFound: true,
+		},
+		{
+			name:    "no show",
+			input:   "{}",
+			wantErr: true,
+		},
+		{
+			name:    "no name",
+			input:   "{\"tvShow\":{\"id\":2550,\"countdown\":null}}",
+			wantErr: true,
+		},
+		{
+			name:    "bad countdown",
+			input:   "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"countdown\":{\"air_date\":\"soon\"}}}",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := parseNextEpisode(c.input, 1, now)
+		gotErr := (err != nil)
+
+		if gotErr != c.wantErr {
+			t.Errorf("incorrect output error for '%s': expected '%t', got '%t'", c.name, c.wantErr, gotErr)
+			continue
+		}
+		if gotNotFound := errors.Cause(err) == ErrShowNotFound; gotNotFound != c.wantNotFound {
+			t.Errorf("incorrect not found for '%s': expected '%t', got '%t'", c.name, c.wantNotFound, gotNotFound)
+		}
+		if c.wantErr {
+			continue
+		}
+
+		if got.Status != c.wantStatus {
+			t.Errorf("incorrect status for '%s': expected '%s', got '%s'", c.name, c.wantStatus, got.Status)
+		}
+		var gotEpisode int64
+		if got.Episode != nil {
+			gotEpisode = got.Episode.Episode
+			if got.Episode.ShowName != got.ShowName || got.Episode.ShowID != got.ShowID ||
+				got.Episode.RuntimeMinutes != 30 {
+				t.Errorf("incorrect episode for '%s': got '%+v'", c.name, *got.Episode)
+			}
+		}
+		if gotEpisode != c.wantEpisode {
+			t.Errorf("incorrect episode for '%s': expected '%d', got '%d'", c.name, c.wantEpisode, gotEpisode)
+		}
+	}
+}
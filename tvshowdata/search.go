@@ -0,0 +1,336 @@
+// Paginated, ranked show search over episodate's search results
+
+package tvshowdata
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// DefaultSearchPageSize is the page size when none is given
+	DefaultSearchPageSize = 20
+	// MaxSearchPageSize is the largest page a search returns
+	MaxSearchPageSize = 50
+
+	// MaxSearchResults is the most shows a search can page through
+	MaxSearchResults = maxSearchPages * searchPageShows
+
+	// episodate pages read per query at most, at searchPageShows each. All
+	// of them are read before ranking, so paging through the ranked shows
+	// doesn't skip or repeat any
+	maxSearchPages  = 5
+	searchPageShows = 20
+
+	// how long ranked results are kept for paging through
+	searchCacheTTL  = time.Minute
+	searchCacheSize = 256
+)
+
+// ErrInvalidCursor is the cause of errors for cursors not from a search
+var ErrInvalidCursor = errors.New("invalid search cursor")
+
+// SearchOptions picks the page and filters for SearchShows
+type SearchOptions struct {
+	// Page starts at 1, and is ignored if there's a Cursor
+	Page     int
+	PageSize int
+	// Cursor continues from a previous result's NextCursor
+	Cursor      string
+	RunningOnly bool
+	// Status keeps only shows with that status, unless empty
+	Status ShowStatus
+	// Network and Country match case insensitively, unless empty
+	Network string
+	Country string
+}
+
+// SearchResult is one page of ranked search results
+type SearchResult struct {
+	Shows    []Show `json:"shows"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
+	// Total is the number of matching shows, up to MaxSearchResults
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// cachedSearch is what has been read of episodate's results for a query
+type cachedSearch struct {
+	// shows are unfiltered, in episodate's order
+	shows []Show
+	// fetched pages, of pages episodate has
+	fetched int
+	pages   int
+	expires time.Time
+}
+
+// more reports if there are episodate pages left to read
+func (c cachedSearch) more() bool {
+	return c.fetched < c.pages && c.fetched < maxSearchPages
+}
+
+var (
+	searchCacheMu sync.Mutex
+	searchCache   = make(map[string]cachedSearch)
+)
+
+// fetchSearchPage gets one page of episodate search results, swapped out
+// in tests
+var fetchSearchPage = func(query string, page int) (string, error) {
+	url, err := getShowSearchURL(query)
+	if err != nil {
+		return "", err
+	}
+
+	return httpGet(fmt.Sprintf("%s&page=%d", url, page))
+}
+
+// GetPopularShows returns a page of episodate's most popular shows, and how
+// many pages there are
+func GetPopularShows(page int) (Shows, int, error) {
+	resp, err := httpGet(fmt.Sprintf(upPopularShows, page))
+	if err != nil {
+		err = errors.Wrapf(err, "error calling httpGet wrapper in GetPopularShows()")
+		return Shows{}, 0, err
+	}
+
+	shows, err := parseCandidateShows(resp)
+	if err != nil {
+		return Shows{}, 0, err
+	}
+
+	return shows, int(gjson.Get(resp, "pages").Int()), nil
+}
+
+// SearchShows returns a page of the shows matching query, best matches and
+// running shows first. The ranking is over every match read for the query,
+// so a cursor continues from the same order the earlier pages came from
+func SearchShows(query string, opts SearchOptions) (SearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return SearchResult{}, errors.New("Empty 'query' given")
+	}
+
+	pageSize := opts.PageSize
+	if pageSize < 1 {
+		pageSize = DefaultSearchPageSize
+	}
+	if pageSize > MaxSearchPageSize {
+		pageSize = MaxSearchPageSize
+	}
+
+	offset := 0
+	if opts.Cursor != "" {
+		var err error
+		if offset, err = decodeCursor(opts.Cursor); err != nil {
+			return SearchResult{}, err
+		}
+	} else if opts.Page > 1 {
+		offset = (opts.Page - 1) * pageSize
+		if opts.Page-1 > MaxSearchResults/pageSize {
+			// past the last page, and kept from overflowing
+			offset = MaxSearchResults
+		}
+	}
+	if offset > MaxSearchResults {
+		offset = MaxSearchResults
+	}
+
+	shows, err := rankedSearch(query, opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	result := SearchResult{Shows: []Show{}, Page: offset/pageSize + 1, PageSize: pageSize,
+		Total: len(shows)}
+	if offset < len(shows) {
+		end := offset + pageSize
+		if end > len(shows) {
+			end = len(shows)
+		}
+		result.Shows = append(result.Shows, shows[offset:end]...)
+		if end < len(shows) {
+			result.NextCursor = encodeCursor(end)
+		}
+	}
+
+	return result, nil
+}
+
+// rankedSearch returns the filtered matches for query in rank order, after
+// reading every episodate page up to maxSearchPages. Pages read are cached
+// for the query, whatever the filters
+func rankedSearch(query string, opts SearchOptions) ([]Show, error) {
+	key := NormalizeName(query)
+	now := time.Now()
+
+	searchCacheMu.Lock()
+	cached, ok := searchCache[key]
+	searchCacheMu.Unlock()
+	if !ok || now.After(cached.expires) {
+		cached = cachedSearch{pages: 1, expires: now.Add(searchCacheTTL)}
+	}
+
+	fetchedMore := false
+	for cached.more() {
+		page, pages, err := fetchSearchResults(query, cached.fetched+1)
+		if err != nil {
+			return nil, err
+		}
+		cached.shows = append(cached.shows[:len(cached.shows):len(cached.shows)], page...)
+		cached.fetched++
+		cached.pages = pages
+		fetchedMore = true
+	}
+	if fetchedMore {
+		storeSearch(key, cached, now)
+	}
+
+	// copied so the cached results can't be changed
+	shows := filterShows(cached.shows, opts)
+	rankShows(query, shows)
+
+	return shows, nil
+}
+
+// storeSearch caches what has been read for the query with key, unless
+// another search read further
+func storeSearch(key string, search cachedSearch, now time.Time) {
+	searchCacheMu.Lock()
+	defer searchCacheMu.Unlock()
+
+	if current, ok := searchCache[key]; ok && now.Before(current.expires) &&
+		current.fetched > search.fetched {
+		return
+	}
+	if len(searchCache) >= searchCacheSize {
+		for k, v := range searchCache {
+			if now.After(v.expires) {
+				delete(searchCache, k)
+			}
+		}
+		if len(searchCache) >= searchCacheSize {
+			searchCache = make(map[string]cachedSearch)
+		}
+	}
+	searchCache[key] = search
+}
+
+// filterShows returns a copy of the shows matching opts' filters
+func filterShows(all []Show, opts SearchOptions) []Show {
+	shows := []Show{}
+	for _, show := range all {
+		if opts.RunningOnly && !show.StillRunning.bool {
+			continue
+		}
+		if opts.Status != "" && show.Status != opts.Status {
+			continue
+		}
+		if opts.Network != "" && !strings.EqualFold(show.Network, opts.Network) {
+			continue
+		}
+		if opts.Country != "" && !strings.EqualFold(show.Country, opts.Country) {
+			continue
+		}
+		shows = append(shows, show)
+	}
+
+	return shows
+}
+
+// fetchSearchResults reads one page of results for query, in episodate's
+// order, and how many pages there are
+func fetchSearchResults(query string, page int) ([]Show, int, error) {
+	resp, err := fetchSearchPage(query, page)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error fetching search page %d", page)
+	}
+
+	haveCandidates, err := checkForCandidateShows(resp, query)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error checking search page %d", page)
+	}
+	if !haveCandidates {
+		// nothing more to read
+		return nil, page, nil
+	}
+
+	candidates, err := parseCandidateShows(resp)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "error parsing search page %d", page)
+	}
+
+	return candidates.Shows, int(gjson.Get(resp, "pages").Int()), nil
+}
+
+// rankShows orders shows by how well their name matches query, then running
+// shows first, otherwise keeping episodate's order
+func rankShows(query string, shows []Show) {
+	scores := make(map[int64]int, len(shows))
+	for _, show := range shows {
+		scores[show.ID] = matchScore(query, show.Name)
+	}
+
+	sort.SliceStable(shows, func(i, j int) bool {
+		if scores[shows[i].ID] != scores[shows[j].ID] {
+			return scores[shows[i].ID] > scores[shows[j].ID]
+		}
+		return shows[i].StillRunning.bool && !shows[j].StillRunning.bool
+	})
+}
+
+// matchScore rates how well name matches query: 4 for the same name, 3 if
+// it starts with the query, 2 if a word does, 1 if it appears anywhere
+func matchScore(query, name string) int {
+	query, name = NormalizeName(query), NormalizeName(name)
+	switch {
+	case query == "":
+		return 0
+	case name == query:
+		return 4
+	case strings.HasPrefix(name, query):
+		return 3
+	case strings.Contains(" "+name, " "+query):
+		return 2
+	case strings.Contains(name, query):
+		return 1
+	}
+
+	return 0
+}
+
+// NormalizeName lowercases s and reduces punctuation and runs of spaces to
+// single spaces, for comparing show names
+func NormalizeName(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	return strings.Join(fields, " ")
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,211 @@
+package tvshowdata
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// fakeSearch serves pages of shows in the episodate search format, counting
+// the fetches
+func fakeSearch(t *testing.T, pages [][]string) *int {
+	fetches := 0
+	oldFetch := fetchSearchPage
+	fetchSearchPage = func(query string, page int) (string, error) {
+		fetches++
+		if page > len(pages) {
+			return "", errors.New("no such page")
+		}
+		return fmt.Sprintf(`{"total":"%d","page":%d,"pages":%d,"tv_shows":[%s]}`,
+			len(pages)*20, page, len(pages), strings.Join(pages[page-1], ",")), nil
+	}
+	t.Cleanup(func() {
+		fetchSearchPage = oldFetch
+		searchCache = make(map[string]cachedSearch)
+	})
+
+	return &fetches
+}
+
+func searchShow(id int64, name, status, network, country string) string {
+	return fmt.Sprintf(`{"id":%d,"name":"%s","status":"%s","network":"%s","country":"%s"}`,
+		id, name, status, network, country)
+}
+
+func names(shows []Show) string {
+	var out []string
+	for _, show := range shows {
+		out = append(out, show.Name)
+	}
+
+	return strings.Join(out, ",")
+}
+
+func TestMatchScore(t *testing.T) {
+	cases := []struct {
+		query string
+		name  string
+		want  int
+	}{
+		{"friends", "Friends", 4},
+		{"american dad", "American Dad!", 4},
+		{"american", "American Dad!", 3},
+		{"dad", "American Dad!", 2},
+		{"mer", "American Dad!", 1},
+		{"simpsons", "American Dad!", 0},
+		{"!!", "American Dad!", 0},
+	}
+
+	for _, c := range cases {
+		if got := matchScore(c.query, c.name); got != c.want {
+			t.Errorf("incorrect output for '%s' in '%s': expected '%d', got '%d'", c.query, c.name, c.want, got)
+		}
+	}
+}
+
+func TestSearchShows(t *testing.T) {
+	fetches := fakeSearch(t, [][]string{
+		{
+			searchShow(1, "The Office Christmas", "Ended", "NBC", "US"),
+			searchShow(2, "Office Girls", "Canceled/Ended", "CTV", "TW"),
+			searchShow(3, "The Office", "Ended", "BBC Two", "UK"),
+		},
+		{
+			searchShow(4, "The Office", "Running", "NBC", "US"),
+			searchShow(5, "Office", "Running", "Netflix", "US"),
+		},
+	})
+
+	cases := []struct {
+		name string
+		opts SearchOptions
+		want string
+	}{
+		{"ranked", SearchOptions{}, "Office,Office Girls,The Office,The Office Christmas,The Office"},
+		{"running only", SearchOptions{RunningOnly: true}, "Office,The Office"},
+		{"status", SearchOptions{Status: ShowStatusEnded}, "The Office Christmas,The Office"},
+		{"canceled", SearchOptions{Status: ShowStatusCanceled}, "Office Girls"},
+		{"network", SearchOptions{Network: "nbc"}, "The Office,The Office Christmas"},
+		{"country", SearchOptions{Country: "UK"}, "The Office"},
+		{"page 2", SearchOptions{Page: 2, PageSize: 2}, "The Office,The Office Christmas"},
+		{"past the end", SearchOptions{Page: 9, PageSize: 2}, ""},
+	}
+
+	for _, c := range cases {
+		got, err := SearchShows("office", c.opts)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", c.name, err)
+			continue
+		}
+		if names(got.Shows) != c.want {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%s'", c.name, c.want, names(got.Shows))
+		}
+	}
+
+	// ties keep running shows first
+	got, _ := SearchShows("office", SearchOptions{Page: 2, PageSize: 2})
+	if got.Shows[0].ID != 4 || got.Page != 2 || got.Total != 5 {
+		t.Errorf("incorrect page: expected show '4' on page '2' of '5', got '%+v'", got)
+	}
+
+	// the query reads episodate's two pages once, whatever the filters and
+	// however many pages are asked for
+	if *fetches != 2 {
+		t.Errorf("incorrect fetches: expected '%d', got '%d'", 2, *fetches)
+	}
+
+	// huge pages are past the end, rather than overflowing the offset
+	got, err := SearchShows("office", SearchOptions{Page: 461168601842738792})
+	if err != nil || len(got.Shows) != 0 || got.NextCursor != "" {
+		t.Errorf("incorrect output for huge page: expected no shows, got '%+v' (%v)", got, err)
+	}
+}
+
+func TestSearchShowsReadsAllPages(t *testing.T) {
+	var page []string
+	for i := int64(1); i <= 20; i++ {
+		page = append(page, searchShow(i, fmt.Sprintf("Show %d", i), "Running", "", ""))
+	}
+	fetches := fakeSearch(t, [][]string{page, page, page})
+
+	cases := []struct {
+		name        string
+		opts        SearchOptions
+		wantFetches int
+	}{
+		{"first page", SearchOptions{PageSize: 10}, 3},
+		{"cached", SearchOptions{Page: 2, PageSize: 10}, 3},
+		{"filtered", SearchOptions{PageSize: 10, Status: ShowStatusEnded}, 3},
+	}
+
+	for _, c := range cases {
+		if _, err := SearchShows("show", c.opts); err != nil {
+			t.Fatal(err)
+		}
+		if *fetches != c.wantFetches {
+			t.Errorf("incorrect fetches for '%s': expected '%d', got '%d'", c.name, c.wantFetches, *fetches)
+		}
+	}
+}
+
+func TestSearchShowsCursor(t *testing.T) {
+	var page []string
+	for i := int64(1); i <= 20; i++ {
+		page = append(page, searchShow(i, fmt.Sprintf("Show %d", i), "Running", "", ""))
+	}
+	fakeSearch(t, [][]string{page, page[:5]})
+
+	var seen []int64
+	opts := SearchOptions{PageSize: 8}
+	for {
+		result, err := SearchShows("show", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, show := range result.Shows {
+			seen = append(seen, show.ID)
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		opts.Cursor = result.NextCursor
+	}
+
+	if len(seen) != 25 {
+		t.Errorf("incorrect shows across pages: expected '25', got '%d'", len(seen))
+	}
+
+	// better matches on a later episodate page mustn't shift pages already
+	// returned
+	searchCache = make(map[string]cachedSearch)
+	fakeSearch(t, [][]string{
+		{searchShow(1, "The Show Must Go On", "Ended", "", ""), searchShow(2, "Late Show", "Ended", "", "")},
+		{searchShow(3, "Show", "Running", "", ""), searchShow(4, "Show Time", "Running", "", "")},
+	})
+	var order []string
+	opts = SearchOptions{PageSize: 1}
+	for {
+		result, err := SearchShows("show", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, names(result.Shows))
+		if result.NextCursor == "" {
+			break
+		}
+		opts.Cursor = result.NextCursor
+	}
+	want := "Show,Show Time,The Show Must Go On,Late Show"
+	if got := strings.Join(order, ","); got != want {
+		t.Errorf("incorrect order across pages: expected '%s', got '%s'", want, got)
+	}
+
+	cursorCases := []string{"!", encodeCursor(-1), "bm90LWEtbnVtYmVy"}
+	for _, cursor := range cursorCases {
+		if _, err := SearchShows("show", SearchOptions{Cursor: cursor}); errors.Cause(err) != ErrInvalidCursor {
+			t.Errorf("incorrect output for cursor '%s': expected '%v', got '%v'", cursor, ErrInvalidCursor, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Full details about a single show, for show pages
+
+package tvshowdata
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+// ErrShowNotFound is the cause of errors for show IDs the API doesn't know
+var ErrShowNotFound = errors.New("show not found")
+
+// ShowDetails is everything the API provides about a show, apart from its
+// episodes
+type ShowDetails struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Network     string `json:"network,omitempty"`
+	Country     string `json:"country,omitempty"`
+	StartDate   string `json:"start_date,omitempty"`
+	EndDate     string `json:"end_date,omitempty"`
+	// StillRunning is Status as a bool, for clients from before Status
+	StillRunning Running    `json:"status"`
+	Status       ShowStatus `json:"show_status"`
+	// RuntimeMinutes is the typical episode length
+	RuntimeMinutes int64    `json:"runtime"`
+	Genres         []string `json:"genres"`
+	ImageURL       string   `json:"image_url,omitempty"`
+	ThumbnailURL   string   `json:"thumbnail_url,omitempty"`
+	// Rating is out of 10, from RatingCount votes
+	Rating      float64 `json:"rating"`
+	RatingCount int64   `json:"rating_count"`
+	URL         string  `json:"url,omitempty"`
+}
+
+// GetShowDetails gets the full details for the show with queryID
+func GetShowDetails(queryID int64) (ShowDetails, error) {
+	resp, err := httpGet(getShowDetailsURL(queryID))
+	if err != nil {
+		err = errors.Wrapf(err, "error calling httpGet wrapper in GetShowDetails()")
+		return ShowDetails{}, err
+	}
+
+	return parseShowDetails(resp, queryID)
+}
+
+// Unmarshals the show details response, ignoring the episodes
+func parseShowDetails(showData string, queryID int64) (ShowDetails, error) {
+	errMsg := fmt.Sprintf("invalid show details for queryID %d", queryID)
+
+	show := gjson.Get(showData, "tvShow")
+	if !show.Exists() {
+		err := errors.New(fmt.Sprintf("%s: no 'tvShow' in api response", errMsg))
+		return ShowDetails{}, err
+	}
+	if show.IsArray() {
+		// unknown IDs get an empty list rather than an error
+		return ShowDetails{}, errors.Wrapf(ErrShowNotFound, "queryID %d", queryID)
+	}
+	if !show.IsObject() {
+		err := errors.New(fmt.Sprintf("%s: invalid 'tvShow' type", errMsg))
+		return ShowDetails{}, err
+	}
+
+	id := show.Get("id")
+	name := show.Get("name")
+	if !id.Exists() || id.Int() == 0 {
+		err := errors.New(fmt.Sprintf("%s: Missing/invalid 'id' in api response", errMsg))
+		return ShowDetails{}, err
+	}
+	if !name.Exists() || name.Type.String() != gjsonString {
+		err := errors.New(fmt.Sprintf("%s: Missing/invalid 'name' in api response", errMsg))
+		return ShowDetails{}, err
+	}
+
+	details := ShowDetails{
+		ID:             id.Int(),
+		Name:           name.String(),
+		Description:    show.Get("description").String(),
+		Network:        show.Get("network").String(),
+		Country:        show.Get("country").String(),
+		StartDate:      show.Get("start_date").String(),
+		EndDate:        show.Get("end_date").String(),
+		RuntimeMinutes: show.Get("runtime").Int(),
+		Genres:         []string{},
+		ImageURL:       show.Get("image_path").String(),
+		ThumbnailURL:   show.Get("image_thumbnail_path").String(),
+		// both are sent as strings
+		Rating:      show.Get("rating").Float(),
+		RatingCount: show.Get("rating_count").Int(),
+		URL:         show.Get("url").String(),
+	}
+
+	details.Status = ShowStatusUnknown
+	if status := show.Get("status"); status.Exists() {
+		if err := details.Status.UnmarshalJSON([]byte(status.Raw)); err != nil {
+			return ShowDetails{}, errors.Wrap(err, errMsg)
+		}
+		details.StillRunning = Running{details.Status.IsRunning()}
+	}
+
+	genres := show.Get("genres")
+	if genres.Exists() && genres.Type.String() != gjsonNull {
+		if !genres.IsArray() {
+			err := errors.New(fmt.Sprintf("%s: invalid 'genres' type", errMsg))
+			return ShowDetails{}, err
+		}
+		for _, genre := range genres.Array() {
+			details.Genres = append(details.Genres, genre.String())
+		}
+	}
+
+	return details, nil
+}
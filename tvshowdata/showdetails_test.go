@@ -0,0 +1,95 @@
+package tvshowdata
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseShowDetails(t *testing.T) {
+	cases := []struct {
+		name         string
+		input        string
+		want         ShowDetails
+		wantErr      bool
+		wantNotFound bool
+	}{
+		{
+			name:  "full details",
+			input: "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"url\":\"https://www.episodate.com/tv-show/american-dad\",\"description\":\"<b>American Dad!</b> is\",\"start_date\":\"2005-02-06\",\"end_date\":null,\"country\":\"US\",\"status\":\"Running\",\"runtime\":30,\"network\":\"TBS\",\"image_path\":\"https://static.episodate.com/images/tv-show/full/2550.jpg\",\"image_thumbnail_path\":\"https://static.episodate.com/images/tv-show/thumbnail/2550.jpg\",\"rating\":\"9.0625\",\"rating_count\":\"16\",\"countdown\":null,\"genres\":[\"Comedy\",\"Animation\"],\"episodes\":[]}}",
+			want: ShowDetails{
+				ID:             2550,
+				Name:           "American Dad!",
+				Description:    "<b>American Dad!</b> is",
+				Network:        "TBS",
+				Country:        "US",
+				StartDate:      "2005-02-06",
+				StillRunning:   Running{true},
+				Status:         ShowStatusRunning,
+				RuntimeMinutes: 30,
+				Genres:         []string{"Comedy", "Animation"},
+				ImageURL:       "https://static.episodate.com/images/tv-show/full/2550.jpg",
+				ThumbnailURL:   "https://static.episodate.com/images/tv-show/thumbnail/2550.jpg",
+				Rating:         9.0625,
+				RatingCount:    16,
+				URL:            "https://www.episodate.com/tv-show/american-dad",
+			},
+		},
+		{
+			name:  "ended, sparse details",
+			input: "{\"tvShow\":{\"id\":3564,\"name\":\"Friends\",\"status\":\"Ended\",\"end_date\":\"2004-05-06\",\"genres\":null}}",
+			want: ShowDetails{
+				ID:      3564,
+				Name:    "Friends",
+				EndDate: "2004-05-06",
+				Status:  ShowStatusEnded,
+				Genres:  []string{},
+			},
+		},
+		{
+			name:         "unknown show",
+			input:        "{\"tvShow\":[]}",
+			wantErr:      true,
+			wantNotFound: true,
+		},
+		{
+			name:    "no show",
+			input:   "{\"total\":\"0\"}",
+			wantErr: true,
+		},
+		{
+			name:    "no name",
+			input:   "{\"tvShow\":{\"id\":2550,\"status\":\"Running\"}}",
+			wantErr: true,
+		},
+		{
+			name:    "no id",
+			input:   "{\"tvShow\":{\"name\":\"American Dad!\"}}",
+			wantErr: true,
+		},
+		{
+			name:    "bad genres",
+			input:   "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"genres\":\"Comedy\"}}",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		got, err := parseShowDetails(c.input, 1)
+		gotErr := (err != nil)
+
+		if gotErr != c.wantErr {
+			t.Errorf("incorrect output error for '%s': expected '%t', got '%t'",
+				c.name, c.wantErr, gotErr)
+		}
+		if gotNotFound := errors.Cause(err) == ErrShowNotFound; gotNotFound != c.wantNotFound {
+			t.Errorf("incorrect not found for '%s': expected '%t', got '%t'",
+				c.name, c.wantNotFound, gotNotFound)
+		}
+		if !c.wantErr && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("incorrect output for '%s': expected '%+v', got '%+v'",
+				c.name, c.want, got)
+		}
+	}
+}
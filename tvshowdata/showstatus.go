@@ -0,0 +1,84 @@
+// Show lifecycle states, from episodate's status strings
+
+package tvshowdata
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// ShowStatus is where a show is in its lifecycle. It marshals as one of
+// the values below
+type ShowStatus string
+
+// show statuses
+const (
+	ShowStatusRunning        ShowStatus = "running"
+	ShowStatusEnded          ShowStatus = "ended"
+	ShowStatusCanceled       ShowStatus = "canceled"
+	ShowStatusInDevelopment  ShowStatus = "in_development"
+	ShowStatusToBeDetermined ShowStatus = "to_be_determined"
+	ShowStatusNewSeries      ShowStatus = "new_series"
+	// ShowStatusHiatus isn't sent by episodate. NextEpisode uses it for
+	// running shows with nothing scheduled
+	ShowStatusHiatus ShowStatus = "hiatus"
+	// ShowStatusUnknown is for missing statuses, or ones episodate adds later
+	ShowStatusUnknown ShowStatus = "unknown"
+)
+
+var showStatuses = []ShowStatus{ShowStatusRunning, ShowStatusEnded, ShowStatusCanceled,
+	ShowStatusInDevelopment, ShowStatusToBeDetermined, ShowStatusNewSeries, ShowStatusHiatus,
+	ShowStatusUnknown}
+
+// ParseShowStatus reads an episodate status like "Canceled/Ended", or one
+// of the ShowStatus values
+func ParseShowStatus(s string) ShowStatus {
+	key := strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}), "_")
+
+	switch key {
+	case "canceled_ended", "cancelled_ended", "cancelled":
+		return ShowStatusCanceled
+	}
+	if status := ShowStatus(key); status.Valid() {
+		return status
+	}
+
+	return ShowStatusUnknown
+}
+
+// Valid reports if s is one of the ShowStatus values
+func (s ShowStatus) Valid() bool {
+	for _, status := range showStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsRunning reports if the show is on the air, even if between seasons
+func (s ShowStatus) IsRunning() bool {
+	return s == ShowStatusRunning || s == ShowStatusHiatus
+}
+
+// HasEnded reports if the show won't have any more episodes
+func (s ShowStatus) HasEnded() bool {
+	return s == ShowStatusEnded || s == ShowStatusCanceled
+}
+
+// UnmarshalJSON reads episodate's status strings as well as our own
+func (s *ShowStatus) UnmarshalJSON(data []byte) error {
+	var status string
+	if err := json.Unmarshal(data, &status); err != nil {
+		return errors.Wrapf(err, "Unable to unmarshal show status")
+	}
+
+	*s = ParseShowStatus(status)
+	return nil
+}
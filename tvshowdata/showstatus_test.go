@@ -0,0 +1,69 @@
+package tvshowdata
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseShowStatus(t *testing.T) {
+	cases := []struct {
+		input string
+		want  ShowStatus
+	}{
+		{"Running", ShowStatusRunning},
+		{"Ended", ShowStatusEnded},
+		{"Canceled/Ended", ShowStatusCanceled},
+		{"Cancelled", ShowStatusCanceled},
+		{"In Development", ShowStatusInDevelopment},
+		{"To Be Determined", ShowStatusToBeDetermined},
+		{"New Series", ShowStatusNewSeries},
+		{"to_be_determined", ShowStatusToBeDetermined},
+		{"hiatus", ShowStatusHiatus},
+		{"Pilot Ordered", ShowStatusUnknown},
+		{"", ShowStatusUnknown},
+	}
+
+	for _, c := range cases {
+		if got := ParseShowStatus(c.input); got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%s'", c.input, c.want, got)
+		}
+	}
+}
+
+func TestShowJSON(t *testing.T) {
+	cases := []struct {
+		name        string
+		input       string
+		wantStatus  ShowStatus
+		wantRunning bool
+		wantErr     bool
+	}{
+		{"episodate running", `{"id":1,"name":"A","status":"Running"}`, ShowStatusRunning, true, false},
+		{"episodate canceled", `{"id":1,"name":"A","status":"Canceled/Ended"}`, ShowStatusCanceled, false, false},
+		{"episodate new status", `{"id":1,"name":"A","status":"Rebooting"}`, ShowStatusUnknown, false, false},
+		{"our own output", `{"id":1,"name":"A","status":true,"show_status":"hiatus"}`, ShowStatusHiatus, true, false},
+		{"old clients", `{"id":1,"name":"A","status":false}`, "", false, false},
+		{"no status", `{"id":1,"name":"A"}`, "", false, false},
+		{"bad status", `{"id":1,"name":"A","status":3}`, "", false, true},
+	}
+
+	for _, c := range cases {
+		var show Show
+		err := json.Unmarshal([]byte(c.input), &show)
+		if (err != nil) != c.wantErr {
+			t.Errorf("incorrect error for '%s': expected '%t', got '%v'", c.name, c.wantErr, err)
+			continue
+		}
+		if show.Status != c.wantStatus || show.StillRunning.IsRunning() != c.wantRunning {
+			t.Errorf("incorrect output for '%s': expected '%s %t', got '%s %t'", c.name,
+				c.wantStatus, c.wantRunning, show.Status, show.StillRunning.IsRunning())
+		}
+	}
+
+	// the bool stays for existing clients, next to the full status
+	out, err := json.Marshal(Show{ID: 1, Name: "A", StillRunning: Running{true}, Status: ShowStatusRunning})
+	want := `{"name":"A","id":1,"status":true,"show_status":"running"}`
+	if err != nil || string(out) != want {
+		t.Errorf("incorrect output for marshal: expected '%s', got '%s'", want, out)
+	}
+}
@@ -0,0 +1,86 @@
+// Where to watch an episode: its network, or the streaming service it
+// drops on
+
+package tvshowdata
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Channel is where an episode airs. For streaming services the air date is
+// when the episode drops
+type Channel struct {
+	Name      string `json:"name"`
+	Streaming bool   `json:"streaming"`
+	// URL is the service's site, for known streaming services
+	URL string `json:"url,omitempty"`
+}
+
+// String describes the channel for calendar event locations
+func (c Channel) String() string {
+	if c.URL == "" {
+		return c.Name
+	}
+	return c.Name + " (" + c.URL + ")"
+}
+
+// streamingServices maps the names providers use for well known streaming
+// services, by channelKey, to how they're shown
+var streamingServices = map[string]Channel{}
+
+func init() {
+	services := []struct {
+		names   []string
+		channel Channel
+	}{
+		{[]string{"Netflix"}, Channel{Name: "Netflix", URL: "https://www.netflix.com"}},
+		{[]string{"Hulu"}, Channel{Name: "Hulu", URL: "https://www.hulu.com"}},
+		{[]string{"Prime Video", "Amazon Prime Video", "Amazon", "Amazon Prime"},
+			Channel{Name: "Prime Video", URL: "https://www.primevideo.com"}},
+		{[]string{"Disney+", "Disney Plus"}, Channel{Name: "Disney+", URL: "https://www.disneyplus.com"}},
+		{[]string{"Apple TV+", "Apple TV Plus", "Apple TV"},
+			Channel{Name: "Apple TV+", URL: "https://tv.apple.com"}},
+		{[]string{"Max", "HBO Max"}, Channel{Name: "Max", URL: "https://www.max.com"}},
+		{[]string{"Paramount+", "Paramount Plus", "CBS All Access"},
+			Channel{Name: "Paramount+", URL: "https://www.paramountplus.com"}},
+		{[]string{"Peacock"}, Channel{Name: "Peacock", URL: "https://www.peacocktv.com"}},
+		{[]string{"YouTube Premium", "YouTube Red"},
+			Channel{Name: "YouTube Premium", URL: "https://www.youtube.com/premium"}},
+		{[]string{"Crunchyroll"}, Channel{Name: "Crunchyroll", URL: "https://www.crunchyroll.com"}},
+	}
+
+	for _, service := range services {
+		service.channel.Streaming = true
+		for _, name := range service.names {
+			streamingServices[channelKey(name)] = service.channel
+		}
+	}
+}
+
+// ChannelFor returns the channel for a provider's network name. Known
+// streaming services get their usual name and site. Otherwise webChannel
+// says if the provider listed it as a web rather than TV channel
+func ChannelFor(name string, webChannel bool) Channel {
+	if service, ok := streamingServices[channelKey(name)]; ok {
+		return service
+	}
+	if strings.TrimSpace(name) == "" {
+		return Channel{}
+	}
+
+	return Channel{Name: strings.TrimSpace(name), Streaming: webChannel}
+}
+
+// channelKey lowercases name and drops everything but letters, numbers and
+// '+', so "Disney+" and "Disney +" match but "Disney" doesn't
+func channelKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || r == '+' {
+			key.WriteRune(r)
+		}
+	}
+
+	return key.String()
+}
@@ -0,0 +1,43 @@
+package tvshowdata
+
+import "testing"
+
+func TestChannelFor(t *testing.T) {
+	cases := []struct {
+		name       string
+		webChannel bool
+		want       Channel
+	}{
+		{"Netflix", false, Channel{Name: "Netflix", Streaming: true, URL: "https://www.netflix.com"}},
+		{"Amazon Prime Video", false, Channel{Name: "Prime Video", Streaming: true, URL: "https://www.primevideo.com"}},
+		{"disney +", false, Channel{Name: "Disney+", Streaming: true, URL: "https://www.disneyplus.com"}},
+		{"CBS All Access", false, Channel{Name: "Paramount+", Streaming: true, URL: "https://www.paramountplus.com"}},
+		{"Disney Channel", false, Channel{Name: "Disney Channel"}},
+		{" TBS ", false, Channel{Name: "TBS"}},
+		{"Some Web Service", true, Channel{Name: "Some Web Service", Streaming: true}},
+		{"", false, Channel{}},
+	}
+
+	for _, c := range cases {
+		if got := ChannelFor(c.name, c.webChannel); got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%+v', got '%+v'", c.name, c.want, got)
+		}
+	}
+}
+
+func TestChannelString(t *testing.T) {
+	cases := []struct {
+		input Channel
+		want  string
+	}{
+		{ChannelFor("Hulu", false), "Hulu (https://www.hulu.com)"},
+		{Channel{Name: "TBS"}, "TBS"},
+		{Channel{}, ""},
+	}
+
+	for _, c := range cases {
+		if got := c.input.String(); got != c.want {
+			t.Errorf("incorrect output for '%+v': expected '%s', got '%s'", c.input, c.want, got)
+		}
+	}
+}
@@ -7,7 +7,6 @@
 // TODO description {show} Season {season}, Episode {episode}
 // TODO validate Episodes, maybe also clean old ones from here
 // TODO propagate runtime, title from Show into Episode
-// TODO if status is 'running' but null countdown, have error or somethin
 // TODO get url fcns should error on blank input
 
 package tvshowdata
@@ -18,12 +17,13 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -36,18 +36,81 @@ const (
 	gjsonNull   = "Null"
 
 	// episodate unpopulated endpoints used
-	upShowSearch  = "https://www.episodate.com/api/search?q=%s"
-	upShowDetails = "https://episodate.com/api/show-details?q=%d"
+	upShowSearch   = "https://www.episodate.com/api/search?q=%s"
+	upShowDetails  = "https://episodate.com/api/show-details?q=%d"
+	upPopularShows = "https://www.episodate.com/api/most-popular?page=%d"
+
+	// ProviderEpisodate is the provider name for episodate IDs
+	ProviderEpisodate = "episodate"
 )
 
+// ErrNoUpcomingEpisodes is the cause of errors for shows with nothing
+// scheduled
+var ErrNoUpcomingEpisodes = errors.New("no upcoming episodes")
+
 // Episode represents an upcoming episode of a TV show
 type Episode struct {
-	Season         int64  `json:"season"`
-	Episode        int64  `json:"episode"`
-	Title          string `json:"name"`
-	AirDate        Time   `json:"air_date"`
+	Season  int64  `json:"season"`
+	Episode int64  `json:"episode"`
+	Title   string `json:"name"`
+	AirDate Time   `json:"air_date"`
+	// AirDatePrecision says if AirDate is the air time, only the day, or
+	// not known yet
+	AirDatePrecision AirDatePrecision `json:"air_date_precision"`
+	// SlotPart numbers episodes listed at the same time, like double
+	// episodes, from 1 of SlotParts. Both are 0 for a slot to itself
+	SlotPart       int    `json:"slot_part,omitempty"`
+	SlotParts      int    `json:"slot_parts,omitempty"`
 	RuntimeMinutes int64  `json:"runtime"`
 	ShowName       string `json:"show_name"`
+	Network        string `json:"network,omitempty"`
+	ShowURL        string `json:"show_url,omitempty"`
+	// WhereToWatch is Network, or the streaming service for web shows
+	WhereToWatch Channel `json:"where_to_watch"`
+	// ShowID is the provider's ID for the show
+	ShowID int64 `json:"show_id"`
+	// Provider is the TV data source the IDs belong to
+	Provider          string `json:"provider"`
+	ProviderEpisodeID string `json:"provider_episode_id"`
+	// the rest are filled in by EnrichEpisodes, where available
+	Synopsis     string       `json:"synopsis,omitempty"`
+	ImageURL     string       `json:"image_url,omitempty"`
+	ShowImageURL string       `json:"show_image_url,omitempty"`
+	Links        EpisodeLinks `json:"links"`
+}
+
+// UnmarshalJSON decodes an episode, filling in the provider and episode ID
+// for payloads from before episodes carried them, and the air date
+// precision for payloads without one
+func (e *Episode) UnmarshalJSON(data []byte) error {
+	// plain has no methods, so decoding it doesn't recurse
+	type plain Episode
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+
+	if e.ShowID != 0 && e.Provider == "" {
+		e.SetEpisodateIDs(e.ShowID)
+	}
+	if e.AirDatePrecision == "" {
+		var raw struct {
+			AirDate json.RawMessage `json:"air_date"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		e.AirDatePrecision = airDatePrecision(raw.AirDate)
+	}
+	return nil
+}
+
+// SetEpisodateIDs marks the episode as belonging to the episodate show with
+// showID. Episodate has no episode IDs, so one is made from the show, season
+// and episode
+func (e *Episode) SetEpisodateIDs(showID int64) {
+	e.ShowID = showID
+	e.Provider = ProviderEpisodate
+	e.ProviderEpisodeID = fmt.Sprintf("%d/S%02dE%02d", showID, e.Season, e.Episode)
 }
 
 // Episodes is the list of Episodes for the show
@@ -55,11 +118,44 @@ type Episodes struct {
 	Episodes []Episode `json:"episodes"`
 }
 
-// Show is the basic show details, and if it is still running
+// Show is the basic show details, and where it is in its lifecycle
 type Show struct {
-	Name         string  `json:"name"`
-	ID           int64   `json:"id"`
-	StillRunning Running `json:"status"`
+	Name string `json:"name"`
+	ID   int64  `json:"id"`
+	// StillRunning is Status as a bool, for clients from before Status
+	StillRunning Running    `json:"status"`
+	Status       ShowStatus `json:"show_status"`
+	Network      string     `json:"network,omitempty"`
+	Country      string     `json:"country,omitempty"`
+}
+
+// UnmarshalJSON decodes a show from episodate, where 'status' is a string,
+// or from this API, where it is a bool alongside 'show_status'
+func (s *Show) UnmarshalJSON(data []byte) error {
+	// plain has no methods, so decoding it doesn't recurse
+	type plain Show
+	var show struct {
+		plain
+		// shadows plain's 'status', which could be either type
+		RawStatus json.RawMessage `json:"status"`
+	}
+	if err := json.Unmarshal(data, &show); err != nil {
+		return err
+	}
+
+	*s = Show(show.plain)
+	if len(show.RawStatus) == 0 {
+		return nil
+	}
+	if err := s.StillRunning.UnmarshalJSON(show.RawStatus); err != nil {
+		return err
+	}
+	var upstream string
+	if json.Unmarshal(show.RawStatus, &upstream) == nil {
+		s.Status = ParseShowStatus(upstream)
+	}
+
+	return nil
 }
 
 // Shows is the list of candidate Shows for the query
@@ -67,18 +163,44 @@ type Shows struct {
 	Shows []Show `json:"shows"`
 }
 
+// EpisodeFilter narrows an episode listing. The zero value lists upcoming
+// episodes
+type EpisodeFilter struct {
+	// From and To bound the air date, inclusive, unless zero
+	From time.Time
+	To   time.Time
+	// Season limits the listing to one season, unless zero
+	Season int64
+	// SpecialsOnly limits the listing to specials (season 0)
+	SpecialsOnly bool
+	// FirstEpisode and LastEpisode bound the episode number, inclusive,
+	// unless zero. Usually used with Season
+	FirstEpisode int64
+	LastEpisode  int64
+	// Limit keeps only the earliest matching episodes, unless zero
+	Limit int
+	// IncludePast keeps episodes that have already aired
+	IncludePast bool
+}
+
 // Time is a custom time to properly unmarshal non-RFC 3339 time from API
 type Time struct {
 	time.Time
 }
 
-// UnmarshalJSON reformats API given time as RFC 3339, when Time struct used
+// UnmarshalJSON reformats API given time as RFC 3339, when Time struct used.
+// Dates without a time are midnight UTC, and null or "" is the zero time
+// for episodes without an air date yet
 func (t *Time) UnmarshalJSON(data []byte) error {
 	var s string
 
 	if err := json.Unmarshal(data, &s); err != nil {
 		return errors.Wrapf(err, "Unable to unmarshal time from API")
 	}
+	if s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
 
 	// first try parsing as RFC3339 in case it's in the proper format
 	var err error
@@ -89,6 +211,11 @@ func (t *Time) UnmarshalJSON(data []byte) error {
 	}
 
 	t.Time, err = time.Parse(timeStrFormat, s)
+	if err == nil {
+		return nil
+	}
+
+	t.Time, err = time.Parse(dateOnlyFormat, s)
 	if err != nil {
 		return errors.Wrapf(err, fmt.Sprintf("unable to reformat time: %s", s))
 	}
@@ -97,6 +224,7 @@ func (t *Time) UnmarshalJSON(data []byte) error {
 }
 
 // Running is used to convert string running status to bool (true if running)
+// for clients that only know the bool. ShowStatus has the full status
 type Running struct {
 	bool
 }
@@ -106,15 +234,23 @@ func (r Running) MarshalJSON() ([]byte, error) {
 	return json.Marshal(r.bool)
 }
 
-// UnmarshalJSON reformats string "show running" status to a bool
+// IsRunning reports if the show is still running
+func (r Running) IsRunning() bool {
+	return r.bool
+}
+
+// UnmarshalJSON reformats a status string to a bool, or reads back the bool
 func (r *Running) UnmarshalJSON(data []byte) error {
-	var running string
+	if err := json.Unmarshal(data, &r.bool); err == nil {
+		return nil
+	}
 
-	if err := json.Unmarshal(data, &running); err != nil {
+	var status ShowStatus
+	if err := json.Unmarshal(data, &status); err != nil {
 		return errors.Wrapf(err, "Unable to unmarshal show running status from API")
 	}
 
-	r.bool = (strings.ToLower(running) == "running")
+	r.bool = status.IsRunning()
 	return nil
 }
 
@@ -131,7 +267,7 @@ func GetCandidateShows(queryShow string) (bool, Shows) {
 
 // GetShowData gets the air times of upcoming episodes for the given queryID
 func GetShowData(queryID int64) (bool, Episodes) {
-	episodeList, err := getUpcomingEpisodes(queryID)
+	episodeList, err := getUpcomingEpisodes(context.Background(), queryID)
 	if err != nil {
 		fmt.Println("Error getting the show data:", err)
 		return false, Episodes{}
@@ -140,13 +276,57 @@ func GetShowData(queryID int64) (bool, Episodes) {
 	return (len(episodeList.Episodes) > 0), episodeList
 }
 
+// GetEpisodes gets the episodes for the given queryID that match filter,
+// which may include past ones
+func GetEpisodes(queryID int64, filter EpisodeFilter) (Episodes, error) {
+	return GetEpisodesContext(context.Background(), queryID, filter)
+}
+
+// GetEpisodesContext is GetEpisodes, giving up on the API once ctx is done
+func GetEpisodesContext(ctx context.Context, queryID int64, filter EpisodeFilter) (Episodes, error) {
+	resp, err := httpGetContext(ctx, getShowDetailsURL(queryID))
+	if err != nil {
+		err = errors.Wrapf(err, "error calling httpGet wrapper in GetEpisodes()")
+		return Episodes{}, err
+	}
+
+	return parseEpisodes(resp, filter, time.Now())
+}
+
+// FetchUpcomingEpisodes gets the upcoming episodes for the given queryID,
+// reporting any API error. A show with nothing scheduled has no episodes
+func FetchUpcomingEpisodes(queryID int64) (Episodes, error) {
+	return FetchUpcomingEpisodesContext(context.Background(), queryID)
+}
+
+// FetchUpcomingEpisodesContext is FetchUpcomingEpisodes, giving up on the
+// API once ctx is done
+func FetchUpcomingEpisodesContext(ctx context.Context, queryID int64) (Episodes, error) {
+	episodeList, err := getUpcomingEpisodes(ctx, queryID)
+	if errors.Cause(err) == ErrNoUpcomingEpisodes {
+		return Episodes{}, nil
+	}
+
+	return episodeList, err
+}
+
 // Simple HTTP Get that returns the response body as a string ("" if error)
 func httpGet(url string) (string, error) {
+	return httpGetContext(context.Background(), url)
+}
+
+// httpGetContext is httpGet, cancelling the request once ctx is done
+func httpGetContext(ctx context.Context, url string) (string, error) {
 	errMsg := fmt.Sprintf("error fetching data from episodate api for url: %s", url)
-	resp, err := http.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		err = errors.Wrap(err, errMsg)
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 
 	if err != nil {
-		err = errors.Wrapf(err, errMsg)
+		err = errors.Wrap(err, errMsg)
 		return "", err
 	}
 	defer resp.Body.Close()
@@ -158,7 +338,7 @@ func httpGet(url string) (string, error) {
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		err = errors.Wrapf(err, errMsg)
+		err = errors.Wrap(err, errMsg)
 		return "", err
 	}
 
@@ -244,6 +424,9 @@ func parseCandidateShows(queryData string) (Shows, error) {
 			err = errors.New(fmt.Sprintf("Couldn't parse show data for: '%s'", value.String()))
 			return false
 		}
+		if show.Status == "" {
+			show.Status = ShowStatusUnknown
+		}
 		candidateShows.Shows = append(candidateShows.Shows, show)
 
 		// keep iterating
@@ -255,10 +438,19 @@ func parseCandidateShows(queryData string) (Shows, error) {
 
 // Unmarshals any upcoming episodes to the appropriate format
 func parseUpcomingEpisodes(showData string) (Episodes, error) {
-	errMsg := fmt.Sprintf("invalid data given to parseUpcomingEpisodes: %s", showData)
+	return parseEpisodes(showData, EpisodeFilter{}, time.Now())
+}
 
+// Unmarshals the episodes matching filter to the appropriate format
+func parseEpisodes(showData string, filter EpisodeFilter, now time.Time) (Episodes, error) {
+	errMsg := fmt.Sprintf("invalid data given to parseEpisodes: %s", showData)
+
+	showID := gjson.Get(showData, "tvShow.id")
 	showName := gjson.Get(showData, "tvShow.name")
 	runtimeMin := gjson.Get(showData, "tvShow.runtime")
+	network := gjson.Get(showData, "tvShow.network")
+	showURL := gjson.Get(showData, "tvShow.url")
+	showImage := gjson.Get(showData, "tvShow.image_path")
 	allEpisodes := gjson.Get(showData, "tvShow.episodes")
 	if !allEpisodes.Exists() || !allEpisodes.IsArray() {
 		err := errors.New(fmt.Sprintf("%s: no episode list in api response", errMsg))
@@ -268,6 +460,10 @@ func parseUpcomingEpisodes(showData string) (Episodes, error) {
 		err := errors.New(fmt.Sprintf("%s: No 'name' in api response", errMsg))
 		return Episodes{}, err
 	}
+	if !showID.Exists() || showID.Int() == 0 {
+		err := errors.New(fmt.Sprintf("%s: Missing/invalid 'id' in API response", errMsg))
+		return Episodes{}, err
+	}
 	if !runtimeMin.Exists() || runtimeMin.Int() == 0 {
 		err := errors.New(fmt.Sprintf("%s: Missing/invalid 'runtime' in API response", errMsg))
 		return Episodes{}, err
@@ -275,10 +471,16 @@ func parseUpcomingEpisodes(showData string) (Episodes, error) {
 
 	// declare error here to preserve any error from the ForEach loop
 	var err error
-	now := time.Now()
 
-	upcomingEpisodes := Episodes{}
+	var parsed []Episode
 	allEpisodes.ForEach(func(key, value gjson.Result) bool {
+		// specials can be season 0 episode 0, so check the fields are there
+		// rather than for a zero episode
+		if !value.Get("season").Exists() || !value.Get("episode").Exists() {
+			err = errors.New(fmt.Sprintf("Couldn't parse episode data for: '%s'", value.String()))
+			return false
+		}
+
 		episode := Episode{}
 		err = json.Unmarshal([]byte(value.String()), &episode)
 		if err != nil {
@@ -287,22 +489,63 @@ func parseUpcomingEpisodes(showData string) (Episodes, error) {
 			// stop iterating
 			return false
 		}
-		if episode == (Episode{}) {
-			err = errors.New(fmt.Sprintf("Couldn't parse episode data for: '%s'", value.String()))
-			return false
-		}
 
 		episode.RuntimeMinutes = runtimeMin.Int()
 		episode.ShowName = showName.String()
+		episode.Network = network.String()
+		episode.WhereToWatch = ChannelFor(episode.Network, false)
+		episode.ShowURL = showURL.String()
+		episode.ShowImageURL = showImage.String()
+		episode.SetEpisodateIDs(showID.Int())
 
-		if episode.AirDate.After(now) {
-			upcomingEpisodes.Episodes = append(upcomingEpisodes.Episodes, episode)
-		}
-
+		parsed = append(parsed, episode)
 		return true // keep iterating
 	})
 
-	return upcomingEpisodes, err
+	// before filtering, as later parts of a slot move
+	staggerSharedSlots(parsed)
+	matchingEpisodes := Episodes{}
+	for _, episode := range parsed {
+		if filter.matches(episode, now) {
+			matchingEpisodes.Episodes = append(matchingEpisodes.Episodes, episode)
+		}
+	}
+
+	if filter.Limit > 0 && len(matchingEpisodes.Episodes) > filter.Limit {
+		sort.SliceStable(matchingEpisodes.Episodes, func(i, j int) bool {
+			return matchingEpisodes.Episodes[i].AirDate.Before(matchingEpisodes.Episodes[j].AirDate.Time)
+		})
+		matchingEpisodes.Episodes = matchingEpisodes.Episodes[:filter.Limit]
+	}
+
+	return matchingEpisodes, err
+}
+
+// matches reports if episode passes the filter, as of now
+func (f EpisodeFilter) matches(episode Episode, now time.Time) bool {
+	if !f.IncludePast && !episode.LatestAirTime().After(now) {
+		return false
+	}
+	if !f.From.IsZero() && episode.AirDate.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && episode.AirDate.After(f.To) {
+		return false
+	}
+	if f.Season != 0 && episode.Season != f.Season {
+		return false
+	}
+	if f.SpecialsOnly && !episode.IsSpecial() {
+		return false
+	}
+	if f.FirstEpisode != 0 && episode.Episode < f.FirstEpisode {
+		return false
+	}
+	if f.LastEpisode != 0 && episode.Episode > f.LastEpisode {
+		return false
+	}
+
+	return true
 }
 
 // Get a list of potential shows matching the query
@@ -335,9 +578,9 @@ func getUpcomingShows(query string) (Shows, error) {
 }
 
 // Get a list of upcoming shows for a particular Episodate query ID
-func getUpcomingEpisodes(queryID int64) (Episodes, error) {
+func getUpcomingEpisodes(ctx context.Context, queryID int64) (Episodes, error) {
 	url := getShowDetailsURL(queryID)
-	resp, err := httpGet(url)
+	resp, err := httpGetContext(ctx, url)
 	if err != nil {
 		msg := "error calling httpGet wrapper"
 		err = errors.Wrapf(err, msg)
@@ -351,7 +594,7 @@ func getUpcomingEpisodes(queryID int64) (Episodes, error) {
 		return Episodes{}, err
 	}
 	if !haveFutureEpisodes {
-		err := errors.New(fmt.Sprintf("No upcoming episodes found for queryID %d", queryID))
+		err := errors.Wrapf(ErrNoUpcomingEpisodes, "queryID %d", queryID)
 		return Episodes{}, err
 	}
 
@@ -4,6 +4,8 @@
 package tvshowdata
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 )
@@ -17,6 +19,9 @@ func TestTimeUnmarshalJSON(t *testing.T) {
 	}{
 		{[]byte("\"2019-09-03 02:00:00\""), false},
 		{[]byte("\"2019-09-03T02:00:00Z\""), false},
+		{[]byte("\"2019-09-03\""), false},
+		{[]byte("\"\""), false},
+		{[]byte("null"), false},
 		{[]byte("bad json"), true},
 		{[]byte("\"not a time\""), true},
 		{[]byte("\"03/09/2019, 02:00:00\""), true},
@@ -71,6 +76,7 @@ func TestRunningUnmarshalJSON(t *testing.T) {
 	}{
 		{[]byte("\"Running\""), false},
 		{[]byte("\"Ended\""), false},
+		{[]byte("true"), false},
 		{[]byte("bad json"), true},
 		{[]byte{}, true},
 	}
@@ -261,11 +267,17 @@ func TestParseCandidateShows(t *testing.T) {
 					Name:         "American Dad!",
 					ID:           2550,
 					StillRunning: Running{true},
+					Status:       ShowStatusRunning,
+					Network:      "TBS",
+					Country:      "US",
 				},
 				Show{
 					Name:         "American Dad1!",
 					ID:           25501,
 					StillRunning: Running{true},
+					Status:       ShowStatusRunning,
+					Network:      "TBS",
+					Country:      "US",
 				},
 			}},
 			expectErr: false,
@@ -326,20 +338,50 @@ func TestParseUpcomingEpisodes(t *testing.T) {
 			input: "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"description\":\"cut\",\"status\":\"Running\",\"runtime\":30,\"image_thumbnail_path\":\"https://static.episodate.com/images/tv-show/thumbnail/2550.jpg\",\"rating\":\"9.0625\",\"rating_count\":\"16\",\"countdown\":{\"season\":15,\"episode\":20,\"name\":\"The Hand that Rocks the Rogu\",\"air_date\":\"2019-08-27 02:00:00\"},\"episodes\":[{\"season\":15,\"episode\":21,\"name\":\"Downtown\",\"air_date\":\"2119-09-03 02:00:00\"},{\"season\":15,\"episode\":22,\"name\":\"Cheek to Cheek: A Stripper's Story\",\"air_date\":\"2119-09-10 02:00:00\"}]}}",
 			want: Episodes{[]Episode{
 				Episode{
-					Season:         15,
-					Episode:        21,
-					Title:          "Downtown",
-					AirDate:        timeParseNoErr(timeStrFormat, "2119-09-03 02:00:00"),
-					RuntimeMinutes: 30,
-					ShowName:       "American Dad!",
+					Season:            15,
+					Episode:           21,
+					Title:             "Downtown",
+					AirDate:           timeParseNoErr(timeStrFormat, "2119-09-03 02:00:00"),
+					AirDatePrecision:  PrecisionTime,
+					RuntimeMinutes:    30,
+					ShowName:          "American Dad!",
+					ShowID:            2550,
+					Provider:          ProviderEpisodate,
+					ProviderEpisodeID: "2550/S15E21",
 				},
 				Episode{
-					Season:         15,
-					Episode:        22,
-					Title:          "Cheek to Cheek: A Stripper's Story",
-					AirDate:        timeParseNoErr(timeStrFormat, "2119-09-10 02:00:00"),
-					RuntimeMinutes: 30,
-					ShowName:       "American Dad!",
+					Season:            15,
+					Episode:           22,
+					Title:             "Cheek to Cheek: A Stripper's Story",
+					AirDate:           timeParseNoErr(timeStrFormat, "2119-09-10 02:00:00"),
+					AirDatePrecision:  PrecisionTime,
+					RuntimeMinutes:    30,
+					ShowName:          "American Dad!",
+					ShowID:            2550,
+					Provider:          ProviderEpisodate,
+					ProviderEpisodeID: "2550/S15E22",
+				},
+			}},
+			wantErr: false,
+		},
+		{
+			name:  "network and show url",
+			input: "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"url\":\"https://www.episodate.com/tv-show/american-dad\",\"network\":\"TBS\",\"status\":\"Running\",\"runtime\":30,\"countdown\":null,\"episodes\":[{\"season\":15,\"episode\":21,\"name\":\"Downtown\",\"air_date\":\"2119-09-03 02:00:00\"}]}}",
+			want: Episodes{[]Episode{
+				Episode{
+					Season:            15,
+					Episode:           21,
+					Title:             "Downtown",
+					AirDate:           timeParseNoErr(timeStrFormat, "2119-09-03 02:00:00"),
+					AirDatePrecision:  PrecisionTime,
+					RuntimeMinutes:    30,
+					ShowName:          "American Dad!",
+					Network:           "TBS",
+					WhereToWatch:      Channel{Name: "TBS"},
+					ShowURL:           "https://www.episodate.com/tv-show/american-dad",
+					ShowID:            2550,
+					Provider:          ProviderEpisodate,
+					ProviderEpisodeID: "2550/S15E21",
 				},
 			}},
 			wantErr: false,
@@ -356,6 +398,12 @@ func TestParseUpcomingEpisodes(t *testing.T) {
 			want:    Episodes{},
 			wantErr: true,
 		},
+		{
+			name:    "no show id",
+			input:   "{\"tvShow\":{\"name\":\"American Dad!\",\"runtime\":30,\"episodes\":[{\"season\":15,\"episode\":21,\"name\":\"Downtown\",\"air_date\":\"2119-09-03 02:00:00\"}]}}",
+			want:    Episodes{},
+			wantErr: true,
+		},
 		{
 			name:    "no run time",
 			input:   "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"description\":\"cut\",\"status\":\"Running\",\"image_thumbnail_path\":\"https://static.episodate.com/images/tv-show/thumbnail/2550.jpg\",\"rating\":\"9.0625\",\"rating_count\":\"16\",\"countdown\":{\"season\":15,\"episode\":20,\"name\":\"The Hand that Rocks the Rogu\",\"air_date\":\"2019-08-27 02:00:00\"},\"episodes\":[{\"season\":15,\"episode\":21,\"name\":\"Downtown\",\"air_date\":\"2119-09-03 02:00:00\"},{\"season\":15,\"episode\":22,\"name\":\"Cheek to Cheek: A Stripper's Story\",\"air_date\":\"2119-09-10 02:00:00\"}]}}",
@@ -393,3 +441,86 @@ func TestParseUpcomingEpisodes(t *testing.T) {
 		}
 	}
 }
+
+func TestParseEpisodesFilter(t *testing.T) {
+	input := "{\"tvShow\":{\"id\":2550,\"name\":\"American Dad!\",\"runtime\":30,\"countdown\":null,\"episodes\":[{\"season\":1,\"episode\":1,\"name\":\"Pilot\",\"air_date\":\"2005-02-06 02:00:00\"},{\"season\":1,\"episode\":2,\"name\":\"Threat Levels\",\"air_date\":\"2005-05-01 02:00:00\"},{\"season\":2,\"episode\":1,\"name\":\"Camp Refoogee\",\"air_date\":\"2005-09-11 02:00:00\"},{\"season\":2,\"episode\":2,\"name\":\"Future\",\"air_date\":\"2119-09-03 02:00:00\"}]}}"
+	now := timeParseNoErr(timeStrFormat, "2019-09-01 00:00:00").Time
+
+	cases := []struct {
+		name   string
+		filter EpisodeFilter
+		want   []string
+	}{
+		{"upcoming by default", EpisodeFilter{}, []string{"Future"}},
+		{"all", EpisodeFilter{IncludePast: true}, []string{"Pilot", "Threat Levels", "Camp Refoogee", "Future"}},
+		{"season", EpisodeFilter{IncludePast: true, Season: 1}, []string{"Pilot", "Threat Levels"}},
+		{"upcoming in season", EpisodeFilter{Season: 2}, []string{"Future"}},
+		{"from", EpisodeFilter{IncludePast: true,
+			From: timeParseNoErr(timeStrFormat, "2005-05-01 02:00:00").Time},
+			[]string{"Threat Levels", "Camp Refoogee", "Future"}},
+		{"window", EpisodeFilter{IncludePast: true,
+			From: timeParseNoErr(timeStrFormat, "2005-03-01 00:00:00").Time,
+			To:   timeParseNoErr(timeStrFormat, "2005-09-11 02:00:00").Time},
+			[]string{"Threat Levels", "Camp Refoogee"}},
+		{"past window without include_past", EpisodeFilter{
+			From: timeParseNoErr(timeStrFormat, "2005-03-01 00:00:00").Time},
+			[]string{"Future"}},
+		{"episode range", EpisodeFilter{IncludePast: true, Season: 1, FirstEpisode: 2, LastEpisode: 5},
+			[]string{"Threat Levels"}},
+		{"rest of season", EpisodeFilter{IncludePast: true, Season: 2, FirstEpisode: 2}, []string{"Future"}},
+		{"next two", EpisodeFilter{IncludePast: true, Limit: 2}, []string{"Pilot", "Threat Levels"}},
+		{"nothing matches", EpisodeFilter{IncludePast: true, Season: 9}, nil},
+	}
+
+	for _, c := range cases {
+		got, err := parseEpisodes(input, c.filter, now)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", c.name, err)
+			continue
+		}
+
+		var titles []string
+		for _, episode := range got.Episodes {
+			titles = append(titles, episode.Title)
+		}
+		if strings.Join(titles, ",") != strings.Join(c.want, ",") {
+			t.Errorf("incorrect output for '%s': expected '%v', got '%v'", c.name, c.want, titles)
+		}
+	}
+}
+
+func TestEpisodeUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  Episode
+	}{
+		{"older payload", `{"season":1,"episode":2,"name":"B","show_name":"A"}`,
+			Episode{Season: 1, Episode: 2, Title: "B", ShowName: "A", AirDatePrecision: PrecisionUnknown}},
+		{"show id only", `{"season":1,"episode":2,"show_id":7}`,
+			Episode{Season: 1, Episode: 2, ShowID: 7, Provider: ProviderEpisodate, ProviderEpisodeID: "7/S01E02",
+				AirDatePrecision: PrecisionUnknown}},
+		{"date only", `{"season":1,"episode":2,"air_date":"2019-09-03"}`,
+			Episode{Season: 1, Episode: 2, AirDate: Time{time.Date(2019, 9, 3, 0, 0, 0, 0, time.UTC)},
+				AirDatePrecision: PrecisionDate}},
+		{"all ids", `{"season":1,"episode":2,"show_id":7,"provider":"tvmaze","provider_episode_id":"99"}`,
+			Episode{Season: 1, Episode: 2, ShowID: 7, Provider: "tvmaze", ProviderEpisodeID: "99",
+				AirDatePrecision: PrecisionUnknown}},
+	}
+
+	for _, c := range cases {
+		var got Episode
+		if err := json.Unmarshal([]byte(c.input), &got); err != nil {
+			t.Errorf("unexpected error for '%s': %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%+v', got '%+v'", c.name, c.want, got)
+		}
+	}
+
+	var bad Episode
+	if err := json.Unmarshal([]byte(`{"air_date":"soon"}`), &bad); err == nil {
+		t.Errorf("expected an error for an invalid air date")
+	}
+}
@@ -0,0 +1,154 @@
+// Checks on client-submitted data, reported per field so clients can show
+// each problem next to its input
+
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+// episode runtime bounds, in minutes
+const (
+	MinRuntimeMinutes = 1
+	MaxRuntimeMinutes = 180
+)
+
+// FieldError is a problem with one field, named by its JSON path
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Report collects the problems found in a request. It is an error, so it
+// can be returned as one when not valid
+type Report struct {
+	Errors []FieldError `json:"errors"`
+}
+
+// Add records a problem with field
+func (r *Report) Add(field, message string) {
+	r.Errors = append(r.Errors, FieldError{Field: field, Message: message})
+}
+
+// Addf records a problem with field, formatting the message
+func (r *Report) Addf(field, format string, args ...interface{}) {
+	r.Add(field, fmt.Sprintf(format, args...))
+}
+
+// Valid reports if no problems were found
+func (r Report) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Error lists every problem
+func (r Report) Error() string {
+	problems := make([]string, len(r.Errors))
+	for idx, fieldErr := range r.Errors {
+		problems[idx] = fieldErr.Field + ": " + fieldErr.Message
+	}
+
+	return "invalid request: " + strings.Join(problems, "; ")
+}
+
+// Episodes checks episodes submitted for a calendar have their required
+// fields, air in the future and have a sane runtime
+func Episodes(episodes []tvshowdata.Episode, now time.Time) Report {
+	var report Report
+	if len(episodes) == 0 {
+		report.Add("episodes", "at least one episode is required")
+		return report
+	}
+
+	seen := make(map[string]int)
+	for idx, episode := range episodes {
+		field := episodeField(idx)
+
+		if episode.ShowID < 1 {
+			report.Add(field+".show_id", "is required")
+		} else if episode.Provider != tvshowdata.ProviderEpisodate {
+			// the only provider episodes come from
+			report.Addf(field+".provider", "must be '%s'", tvshowdata.ProviderEpisodate)
+		}
+		if strings.TrimSpace(episode.ShowName) == "" {
+			report.Add(field+".show_name", "is required")
+		}
+		if episode.Season < 0 {
+			report.Add(field+".season", "must not be negative")
+		}
+		switch {
+		case episode.Episode < 0:
+			report.Add(field+".episode", "must not be negative")
+		case episode.Episode == 0 && !episode.IsSpecial():
+			// episodate numbers some specials from 0
+			report.Add(field+".episode", "must be at least 1")
+		}
+
+		switch {
+		case episode.AirDate.IsZero():
+			report.Add(field+".air_date", "is required")
+		case !episode.LatestAirTime().After(now):
+			report.Add(field+".air_date", "must be in the future")
+		}
+
+		if episode.RuntimeMinutes < MinRuntimeMinutes || episode.RuntimeMinutes > MaxRuntimeMinutes {
+			report.Addf(field+".runtime", "must be between %d and %d minutes",
+				MinRuntimeMinutes, MaxRuntimeMinutes)
+		}
+
+		id := fmt.Sprintf("%s/S%dE%d", episode.ShowName, episode.Season, episode.Episode)
+		if first, ok := seen[id]; ok {
+			report.Addf(field, "duplicates %s", episodeField(first))
+		} else {
+			seen[id] = idx
+		}
+	}
+
+	return report
+}
+
+// VerifyEpisodes checks each episode against the provider's listing for
+// its show, so clients can't put made up episodes or times in a calendar
+func VerifyEpisodes(episodes []tvshowdata.Episode, listing []tvshowdata.Episode) Report {
+	known := make(map[[2]int64]tvshowdata.Episode, len(listing))
+	for _, episode := range listing {
+		known[[2]int64{episode.Season, episode.Episode}] = episode
+	}
+
+	var report Report
+	for idx, episode := range episodes {
+		field := episodeField(idx)
+
+		actual, ok := known[[2]int64{episode.Season, episode.Episode}]
+		if !ok {
+			report.Addf(field, "no season %d episode %d for this show", episode.Season, episode.Episode)
+			continue
+		}
+		if episode.ShowID != actual.ShowID {
+			report.Addf(field+".show_id", "does not match the show (%d)", actual.ShowID)
+		}
+		if episode.ShowName != actual.ShowName {
+			report.Addf(field+".show_name", "does not match the show ('%s')", actual.ShowName)
+		}
+		if episode.Provider != actual.Provider {
+			report.Addf(field+".provider", "does not match the listing ('%s')", actual.Provider)
+		}
+		if episode.ProviderEpisodeID != actual.ProviderEpisodeID {
+			report.Addf(field+".provider_episode_id", "does not match the listing ('%s')",
+				actual.ProviderEpisodeID)
+		}
+		if !episode.AirDate.Equal(actual.AirDate.Time) {
+			report.Addf(field+".air_date", "does not match the listing (%s)",
+				actual.AirDate.Format(time.RFC3339))
+		}
+	}
+
+	return report
+}
+
+func episodeField(idx int) string {
+	return fmt.Sprintf("episodes[%d]", idx)
+}
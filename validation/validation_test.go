@@ -0,0 +1,109 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/tvshowdata"
+)
+
+var now = time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+
+func validEpisode() tvshowdata.Episode {
+	episode := tvshowdata.Episode{Season: 15, Episode: 21, Title: "Downtown", ShowName: "American Dad!",
+		AirDate: tvshowdata.Time{Time: now.Add(48 * time.Hour)}, RuntimeMinutes: 30}
+	episode.SetEpisodateIDs(2550)
+	return episode
+}
+
+// fields returns the fields with problems, in order
+func fields(report Report) string {
+	var names []string
+	for _, fieldErr := range report.Errors {
+		names = append(names, fieldErr.Field)
+	}
+
+	return strings.Join(names, ",")
+}
+
+func TestEpisodes(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(*tvshowdata.Episode)
+		want   string
+	}{
+		{"valid", func(e *tvshowdata.Episode) {}, ""},
+		{"no show id", func(e *tvshowdata.Episode) { e.ShowID = 0 }, "episodes[0].show_id"},
+		{"no show name", func(e *tvshowdata.Episode) { e.ShowName = " " }, "episodes[0].show_name"},
+		{"negative season", func(e *tvshowdata.Episode) { e.Season = -1 }, "episodes[0].season"},
+		{"no episode number", func(e *tvshowdata.Episode) { e.Episode = 0 }, "episodes[0].episode"},
+		{"special numbered 0", func(e *tvshowdata.Episode) { e.Season = 0; e.Episode = 0 }, ""},
+		{"negative special", func(e *tvshowdata.Episode) { e.Season = 0; e.Episode = -1 }, "episodes[0].episode"},
+		{"no air date", func(e *tvshowdata.Episode) { e.AirDate = tvshowdata.Time{} }, "episodes[0].air_date"},
+		{"already aired", func(e *tvshowdata.Episode) { e.AirDate.Time = now.Add(-time.Hour) }, "episodes[0].air_date"},
+		{"no runtime", func(e *tvshowdata.Episode) { e.RuntimeMinutes = 0 }, "episodes[0].runtime"},
+		{"other provider", func(e *tvshowdata.Episode) { e.Provider = "tvmaze" }, "episodes[0].provider"},
+		{"too long", func(e *tvshowdata.Episode) { e.RuntimeMinutes = 181 }, "episodes[0].runtime"},
+		{"several", func(e *tvshowdata.Episode) { e.ShowName = ""; e.RuntimeMinutes = -5 },
+			"episodes[0].show_name,episodes[0].runtime"},
+	}
+
+	for _, c := range cases {
+		episode := validEpisode()
+		c.modify(&episode)
+
+		report := Episodes([]tvshowdata.Episode{episode}, now)
+		if got := fields(report); got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%s'", c.name, c.want, got)
+		}
+		if report.Valid() != (c.want == "") {
+			t.Errorf("incorrect validity for '%s': expected '%t', got '%t'", c.name, c.want == "", report.Valid())
+		}
+	}
+}
+
+func TestEpisodesList(t *testing.T) {
+	if got := fields(Episodes(nil, now)); got != "episodes" {
+		t.Errorf("incorrect output for no episodes: expected 'episodes', got '%s'", got)
+	}
+
+	other := validEpisode()
+	other.Episode = 22
+	report := Episodes([]tvshowdata.Episode{validEpisode(), other, validEpisode()}, now)
+	if got := fields(report); got != "episodes[2]" {
+		t.Errorf("incorrect output for duplicate: expected 'episodes[2]', got '%s'", got)
+	}
+	if !strings.Contains(report.Error(), "episodes[2]: duplicates episodes[0]") {
+		t.Errorf("incorrect error: got '%s'", report.Error())
+	}
+}
+
+func TestVerifyEpisodes(t *testing.T) {
+	listing := []tvshowdata.Episode{validEpisode()}
+
+	cases := []struct {
+		name   string
+		modify func(*tvshowdata.Episode)
+		want   string
+	}{
+		{"matches", func(e *tvshowdata.Episode) {}, ""},
+		{"unknown episode", func(e *tvshowdata.Episode) { e.Episode = 99 }, "episodes[0]"},
+		{"wrong show", func(e *tvshowdata.Episode) { e.ShowName = "Friends" }, "episodes[0].show_name"},
+		{"wrong show id", func(e *tvshowdata.Episode) { e.ShowID = 3564 }, "episodes[0].show_id"},
+		{"other provider", func(e *tvshowdata.Episode) { e.Provider = "tvmaze" }, "episodes[0].provider"},
+		{"wrong episode id", func(e *tvshowdata.Episode) { e.ProviderEpisodeID = "3564/S15E21" },
+			"episodes[0].provider_episode_id"},
+		{"moved air date", func(e *tvshowdata.Episode) { e.AirDate.Time = e.AirDate.Add(time.Hour) },
+			"episodes[0].air_date"},
+	}
+
+	for _, c := range cases {
+		episode := validEpisode()
+		c.modify(&episode)
+
+		if got := fields(VerifyEpisodes([]tvshowdata.Episode{episode}, listing)); got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%s', got '%s'", c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Connecting to webhook receivers, refusing addresses that aren't public
+
+package webhooks
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// errPrivateAddress is the cause of errors for webhook hosts that are, or
+// resolve to, loopback, private, link-local or otherwise non-public addresses
+var errPrivateAddress = errors.New("webhook host is not a public address")
+
+// blocks the net.IP methods don't cover
+var reservedBlocks = mustParseCIDRs(
+	"0.0.0.0/8",       // this network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, and broadcast
+	"64:ff9b::/96",    // NAT64, which can reach private IPv4
+	"2001:db8::/32",   // documentation
+)
+
+var (
+	// allowAddress reports if webhooks may be registered for ip, swapped out
+	// in tests to reach local receivers
+	allowAddress = isPublicAddress
+
+	dialer = &net.Dialer{Timeout: 5 * time.Second}
+
+	// client sends deliveries, swapped out in tests
+	client = newClient(isPublicAddress)
+)
+
+// newClient returns a client that only connects to addresses allow accepts.
+// They are checked when connecting, after the host is resolved, so DNS
+// can't be changed to point at an internal address after registering
+func newClient(allow func(net.IP) bool) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialAllowed(allow),
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		// a redirect could point anywhere, so the redirect is the response
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isPublicAddress reports if ip is a public unicast address
+func isPublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, block := range reservedBlocks {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+
+	return ip.IsGlobalUnicast()
+}
+
+// checkTarget rejects webhook urls that obviously aren't public. Hostnames
+// are resolved and checked again on each delivery
+func checkTarget(target *url.URL) error {
+	host := strings.ToLower(target.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errPrivateAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && !allowAddress(ip) {
+		return errPrivateAddress
+	}
+
+	return nil
+}
+
+// dialAllowed returns a dial function that resolves addr and connects to
+// it, unless any address it resolves to isn't allowed
+func dialAllowed(allow func(net.IP) bool) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if !allow(ip.IP) {
+				return nil, errors.Wrapf(errPrivateAddress, "%s resolves to %s", host, ip.IP)
+			}
+		}
+
+		// dial the checked addresses, not the name, so it isn't resolved again
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		if err == nil {
+			err = errors.New("no addresses for " + host)
+		}
+
+		return nil, err
+	}
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	blocks := make([]*net.IPNet, len(cidrs))
+	for idx, cidr := range cidrs {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		blocks[idx] = block
+	}
+
+	return blocks
+}
@@ -0,0 +1,91 @@
+package webhooks
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, c := range cases {
+		if got := isPublicAddress(net.ParseIP(c.ip)); got != c.want {
+			t.Errorf("incorrect output for '%s': expected '%t', got '%t'", c.ip, c.want, got)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	// the resolved address is checked, whatever the url says
+	_, err := client.Get(server.URL)
+	if err == nil || errors.Cause(err) == nil {
+		t.Fatalf("expected the loopback receiver to be refused")
+	}
+	if !containsCause(err, errPrivateAddress) {
+		t.Errorf("incorrect error: expected '%v', got '%v'", errPrivateAddress, err)
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	allowLoopback(t)
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer internal.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirect.Close()
+
+	resp, err := client.Get(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("incorrect status: expected '%d', got '%d'", http.StatusFound, resp.StatusCode)
+	}
+}
+
+// containsCause reports if target is anywhere in err's chain, through the
+// url.Error and net.OpError the client wraps dial errors in
+func containsCause(err, target error) bool {
+	for err != nil {
+		if errors.Cause(err) == target {
+			return true
+		}
+		unwrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = unwrapper.Unwrap()
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+// Storage for webhooks and their delivery log
+
+package webhooks
+
+import (
+	"sort"
+	"sync"
+)
+
+// deliveries kept per webhook by the in-memory store
+const maxDeliveryLog = 100
+
+// Store persists webhooks and their deliveries
+type Store interface {
+	AddWebhook(webhook Webhook) error
+	// RemoveWebhook deletes the webhook and its deliveries, reporting if it
+	// existed
+	RemoveWebhook(userID, id string) (bool, error)
+	GetWebhook(userID, id string) (Webhook, bool, error)
+	GetWebhookByID(id string) (Webhook, bool, error)
+	// UserWebhooks returns the user's webhooks, oldest first
+	UserWebhooks(userID string) ([]Webhook, error)
+	// SaveDelivery adds or replaces a delivery
+	SaveDelivery(delivery Delivery) error
+	GetDelivery(id string) (Delivery, bool, error)
+	// Deliveries returns a webhook's recent deliveries, newest first
+	Deliveries(webhookID string) ([]Delivery, error)
+}
+
+// memoryStore is the default, process-local Store
+type memoryStore struct {
+	mu         sync.RWMutex
+	webhooks   map[string]Webhook
+	deliveries map[string]Delivery
+	// delivery ids per webhook, oldest first
+	logs map[string][]string
+}
+
+var store Store = newMemoryStore()
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		webhooks:   make(map[string]Webhook),
+		deliveries: make(map[string]Delivery),
+		logs:       make(map[string][]string),
+	}
+}
+
+// SetStore replaces the default in-memory webhook storage
+func SetStore(s Store) {
+	store = s
+}
+
+// AddWebhook saves webhook
+func (s *memoryStore) AddWebhook(webhook Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.webhooks[webhook.ID] = webhook
+	return nil
+}
+
+// RemoveWebhook deletes the user's webhook and its deliveries
+func (s *memoryStore) RemoveWebhook(userID, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok || webhook.UserID != userID {
+		return false, nil
+	}
+
+	delete(s.webhooks, id)
+	for _, deliveryID := range s.logs[id] {
+		delete(s.deliveries, deliveryID)
+	}
+	delete(s.logs, id)
+	return true, nil
+}
+
+// GetWebhook returns the user's webhook with id
+func (s *memoryStore) GetWebhook(userID, id string) (Webhook, bool, error) {
+	webhook, ok, err := s.GetWebhookByID(id)
+	if !ok || webhook.UserID != userID {
+		return Webhook{}, false, err
+	}
+
+	return webhook, true, nil
+}
+
+// GetWebhookByID returns the webhook with id, whoever it belongs to
+func (s *memoryStore) GetWebhookByID(id string) (Webhook, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[id]
+	return webhook, ok, nil
+}
+
+// UserWebhooks returns the user's webhooks, oldest first
+func (s *memoryStore) UserWebhooks(userID string) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := []Webhook{}
+	for _, webhook := range s.webhooks {
+		if webhook.UserID == userID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+// SaveDelivery adds or replaces a delivery, dropping the webhook's oldest
+// once the log is full
+func (s *memoryStore) SaveDelivery(delivery Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[delivery.WebhookID]; !ok {
+		// removed while sending
+		return nil
+	}
+	if _, ok := s.deliveries[delivery.ID]; !ok {
+		log := append(s.logs[delivery.WebhookID], delivery.ID)
+		if len(log) > maxDeliveryLog {
+			delete(s.deliveries, log[0])
+			log = log[1:]
+		}
+		s.logs[delivery.WebhookID] = log
+	}
+
+	s.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+// GetDelivery returns the delivery with id
+func (s *memoryStore) GetDelivery(id string) (Delivery, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	delivery, ok := s.deliveries[id]
+	return delivery, ok, nil
+}
+
+// Deliveries returns the webhook's logged deliveries, newest first
+func (s *memoryStore) Deliveries(webhookID string) ([]Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	log := s.logs[webhookID]
+	deliveries := make([]Delivery, 0, len(log))
+	for idx := len(log) - 1; idx >= 0; idx-- {
+		deliveries = append(deliveries, s.deliveries[log[idx]])
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,378 @@
+// User-registered webhooks, sent signed JSON when followed shows change
+
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/swayne275/showcal-backend-go/jobs"
+	"golang.org/x/net/context"
+)
+
+// event types
+const (
+	EpisodeAdded       = "episode.added"
+	EpisodeRescheduled = "episode.rescheduled"
+	EpisodeCancelled   = "episode.cancelled"
+	// Ping is only sent by the test endpoint
+	Ping = "ping"
+)
+
+// delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryRetrying failed, and will be tried again with backoff
+	DeliveryRetrying = "retrying"
+	DeliveryFailed   = "failed"
+)
+
+// request headers sent with each delivery
+const (
+	SignatureHeader = "X-Showcal-Signature"
+	// TimestampHeader is when the delivery was sent, in unix seconds. It is
+	// signed along with the body
+	TimestampHeader = "X-Showcal-Timestamp"
+	EventHeader     = "X-Showcal-Event"
+	DeliveryHeader  = "X-Showcal-Delivery"
+)
+
+const (
+	// DeliverJob is the job type that sends a delivery
+	DeliverJob = "webhooks.deliver"
+
+	// SignatureTolerance is how old a delivery's timestamp can be before
+	// Verify rejects it as a replay
+	SignatureTolerance = 5 * time.Minute
+)
+
+// EventTypes are the events a webhook can subscribe to
+var EventTypes = []string{EpisodeAdded, EpisodeRescheduled, EpisodeCancelled}
+
+// Webhook is a user's endpoint for events
+type Webhook struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	URL    string `json:"url"`
+	// Secret signs payloads, and is only shown when the webhook is created
+	Secret string `json:"secret,omitempty"`
+	// Events the webhook receives, all of EventTypes if empty
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is one event sent (or being sent) to a webhook
+type Delivery struct {
+	ID           string          `json:"id"`
+	WebhookID    string          `json:"webhook_id"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	// Response is the receiver's status line. Its body isn't kept
+	Response  string    `json:"response,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// payload is the JSON body sent to a webhook
+type payload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// deliverPayload identifies the delivery for a DeliverJob
+type deliverPayload struct {
+	DeliveryID string `json:"delivery_id"`
+}
+
+// Register adds a webhook for the user, returning it with its secret
+func Register(userID, rawURL string, events []string) (Webhook, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return Webhook{}, errors.New("Webhook url must be an absolute http(s) url")
+	}
+	if err := checkTarget(target); err != nil {
+		return Webhook{}, errors.New("Webhook url must be a public address")
+	}
+	for _, event := range events {
+		if !isEventType(event) {
+			return Webhook{}, errors.New(fmt.Sprintf("Unknown webhook event '%s'", event))
+		}
+	}
+	if len(events) == 0 {
+		events = EventTypes
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return Webhook{}, errors.Wrap(err, "Error in Register()")
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return Webhook{}, errors.Wrap(err, "Error in Register()")
+	}
+
+	webhook := Webhook{ID: id, UserID: userID, URL: target.String(), Secret: secret,
+		Events: events, CreatedAt: time.Now().UTC()}
+	if err := store.AddWebhook(webhook); err != nil {
+		return Webhook{}, errors.Wrap(err, "Error in Register()")
+	}
+
+	return webhook, nil
+}
+
+// Remove deletes the user's webhook, reporting false if there wasn't one
+func Remove(userID, id string) (bool, error) {
+	return store.RemoveWebhook(userID, id)
+}
+
+// UserWebhooks lists the user's webhooks, without their secrets
+func UserWebhooks(userID string) ([]Webhook, error) {
+	webhooks, err := store.UserWebhooks(userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error in UserWebhooks()")
+	}
+	for idx := range webhooks {
+		webhooks[idx].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+// Deliveries returns the recent deliveries for the user's webhook, newest
+// first. Reports false if the user has no such webhook
+func Deliveries(userID, webhookID string) ([]Delivery, bool, error) {
+	if _, ok, err := store.GetWebhook(userID, webhookID); err != nil || !ok {
+		return nil, false, err
+	}
+
+	deliveries, err := store.Deliveries(webhookID)
+	return deliveries, true, err
+}
+
+// Dispatch queues event with data for each of the user's webhooks that
+// receive it. Deliveries are retried with backoff by the job workers
+func Dispatch(userID, event string, data interface{}) {
+	webhooks, err := store.UserWebhooks(userID)
+	if err != nil {
+		fmt.Println("error in Dispatch()", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !webhook.receives(event) {
+			continue
+		}
+
+		delivery, err := newDelivery(webhook, event, data)
+		if err != nil {
+			fmt.Println("error in Dispatch()", err)
+			continue
+		}
+		if _, err := jobs.Enqueue(DeliverJob, deliverPayload{DeliveryID: delivery.ID}); err != nil {
+			fmt.Println("error queueing webhook delivery", err)
+		}
+	}
+}
+
+// SendPing sends a ping to the user's webhook right away, without retries,
+// and returns the logged delivery. Reports false if there is no such webhook
+func SendPing(userID, webhookID string) (Delivery, bool, error) {
+	webhook, ok, err := store.GetWebhook(userID, webhookID)
+	if err != nil || !ok {
+		return Delivery{}, false, err
+	}
+
+	delivery, err := newDelivery(webhook, Ping, map[string]string{"webhook_id": webhook.ID})
+	if err != nil {
+		return Delivery{}, false, err
+	}
+	delivery, _ = deliver(webhook, delivery, true)
+
+	return delivery, true, nil
+}
+
+// RegisterJobs sets up the background job that sends deliveries
+func RegisterJobs() {
+	jobs.Register(DeliverJob, func(ctx context.Context, job jobs.Job) error {
+		var p deliverPayload
+		if err := json.Unmarshal(job.Payload, &p); err != nil {
+			return errors.Wrap(err, "Invalid webhook delivery payload")
+		}
+
+		delivery, ok, err := store.GetDelivery(p.DeliveryID)
+		if err != nil {
+			return errors.Wrap(err, "Unable to load webhook delivery")
+		}
+		if !ok {
+			// the webhook was removed
+			return nil
+		}
+		webhook, ok, err := store.GetWebhookByID(delivery.WebhookID)
+		if err != nil {
+			return errors.Wrap(err, "Unable to load webhook")
+		}
+		if !ok {
+			return nil
+		}
+
+		_, err = deliver(webhook, delivery, job.Attempts >= job.MaxAttempts)
+		return err
+	})
+}
+
+// newDelivery logs a pending delivery of event to webhook
+func newDelivery(webhook Webhook, event string, data interface{}) (Delivery, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return Delivery{}, errors.Wrap(err, "Error in newDelivery()")
+	}
+
+	now := time.Now().UTC()
+	body, err := json.Marshal(payload{ID: id, Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return Delivery{}, errors.Wrap(err, "Unable to encode webhook payload")
+	}
+
+	delivery := Delivery{ID: id, WebhookID: webhook.ID, Event: event, Payload: body,
+		Status: DeliveryPending, CreatedAt: now, UpdatedAt: now}
+	if err := store.SaveDelivery(delivery); err != nil {
+		return Delivery{}, errors.Wrap(err, "Error in newDelivery()")
+	}
+
+	return delivery, nil
+}
+
+// deliver makes one attempt at sending delivery, logging the outcome.
+// lastAttempt marks a failure as final rather than retrying
+func deliver(webhook Webhook, delivery Delivery, lastAttempt bool) (Delivery, error) {
+	delivery.Attempts++
+	code, response, err := send(webhook, delivery)
+
+	delivery.ResponseCode = code
+	delivery.Response = response
+	delivery.UpdatedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+		delivery.Error = ""
+	case lastAttempt:
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Status = DeliveryRetrying
+		delivery.Error = err.Error()
+	}
+
+	if saveErr := store.SaveDelivery(delivery); saveErr != nil {
+		fmt.Println("error logging webhook delivery", saveErr)
+	}
+
+	return delivery, err
+}
+
+// send posts the signed delivery payload, returning the response status.
+// The body isn't read, so receivers can't be used to fetch other content
+func send(webhook Webhook, delivery Delivery) (int, string, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "showCal-Webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	timestamp := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, resp.Status,
+			errors.New(fmt.Sprintf("webhook returned HTTP StatusCode: %d", resp.StatusCode))
+	}
+
+	return resp.StatusCode, resp.Status, nil
+}
+
+// Sign returns the signature header value for body sent at timestamp (unix
+// seconds): "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a
+// '.' and the body, keyed with the webhook secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and timestamp headers against its
+// body, rejecting deliveries older than SignatureTolerance as of now
+func Verify(secret string, header http.Header, body []byte, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return errors.New("Missing or invalid webhook timestamp")
+	}
+	sent := time.Unix(timestamp, 0)
+	if now.Sub(sent) > SignatureTolerance || sent.Sub(now) > SignatureTolerance {
+		return errors.New("Webhook timestamp is outside the tolerance")
+	}
+
+	want := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(want)) {
+		return errors.New("Invalid webhook signature")
+	}
+
+	return nil
+}
+
+// receives reports if the webhook is subscribed to event
+func (w Webhook) receives(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isEventType(event string) bool {
+	for _, e := range EventTypes {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
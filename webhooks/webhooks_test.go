@@ -0,0 +1,263 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/swayne275/showcal-backend-go/jobs"
+)
+
+// receiver records the requests with valid signatures, answering status
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []payload
+	badSigs  int
+}
+
+func (f *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if Verify(f.secret, r.Header, body, time.Now()) != nil {
+		f.badSigs++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var p payload
+	_ = json.Unmarshal(body, &p)
+	f.received = append(f.received, p)
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+	}
+	w.Write([]byte("thanks"))
+}
+
+// swap in a fresh store for the duration of a test
+func useTestStore(t *testing.T) {
+	oldStore := store
+	store = newMemoryStore()
+	t.Cleanup(func() { store = oldStore })
+}
+
+// let deliveries reach httptest servers for the duration of a test
+func allowLoopback(t *testing.T) {
+	oldAllow, oldClient := allowAddress, client
+	allowAddress = func(ip net.IP) bool { return ip.IsLoopback() || isPublicAddress(ip) }
+	client = newClient(allowAddress)
+	t.Cleanup(func() { allowAddress, client = oldAllow, oldClient })
+}
+
+func TestRegister(t *testing.T) {
+	useTestStore(t)
+
+	cases := []struct {
+		name    string
+		url     string
+		events  []string
+		wantErr bool
+	}{
+		{"all events", "https://example.com/hook", nil, false},
+		{"some events", "http://example.com:9000/hook", []string{EpisodeCancelled}, false},
+		{"relative url", "/hook", nil, true},
+		{"localhost", "http://localhost:9000/hook", nil, true},
+		{"loopback", "http://127.0.0.1/hook", nil, true},
+		{"private", "http://10.0.0.1/hook", nil, true},
+		{"metadata", "http://169.254.169.254/latest/meta-data", nil, true},
+		{"ipv6 loopback", "http://[::1]/hook", nil, true},
+		{"bad scheme", "ftp://example.com/hook", nil, true},
+		{"unknown event", "https://example.com/hook", []string{"episode.exploded"}, true},
+	}
+
+	for _, c := range cases {
+		got, err := Register("u1", c.url, c.events)
+		gotErr := (err != nil)
+
+		if gotErr != c.wantErr {
+			t.Errorf("incorrect output error for '%s': expected '%t', got '%t' (%v)",
+				c.name, c.wantErr, gotErr, err)
+		}
+		if !c.wantErr && (got.Secret == "" || len(got.Events) == 0) {
+			t.Errorf("incorrect webhook for '%s': got '%+v'", c.name, got)
+		}
+	}
+
+	list, _ := UserWebhooks("u1")
+	if len(list) != 2 || list[0].Secret != "" {
+		t.Errorf("expected two webhooks without secrets, got '%+v'", list)
+	}
+}
+
+func TestSendPing(t *testing.T) {
+	useTestStore(t)
+	allowLoopback(t)
+
+	fake := &receiver{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	webhook, err := Register("u1", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.secret = webhook.Secret
+
+	cases := []struct {
+		name       string
+		status     int
+		wantStatus string
+	}{
+		{"delivered", 0, DeliveryDelivered},
+		{"receiver error", http.StatusInternalServerError, DeliveryFailed},
+	}
+
+	for _, c := range cases {
+		fake.status = c.status
+		got, ok, err := SendPing("u1", webhook.ID)
+		if err != nil || !ok {
+			t.Fatalf("unable to ping for '%s': %t (%v)", c.name, ok, err)
+		}
+
+		if got.Status != c.wantStatus || got.Attempts != 1 || got.Event != Ping {
+			t.Errorf("incorrect delivery for '%s': got '%+v'", c.name, got)
+		}
+		if strings.Contains(got.Response, "thanks") {
+			t.Errorf("incorrect response for '%s': expected no body, got '%s'", c.name, got.Response)
+		}
+	}
+
+	if _, ok, _ := SendPing("u2", webhook.ID); ok {
+		t.Errorf("did not expect another user to ping the webhook")
+	}
+
+	deliveries, _, _ := Deliveries("u1", webhook.ID)
+	if len(deliveries) != 2 || deliveries[0].Status != DeliveryFailed ||
+		deliveries[0].ResponseCode != http.StatusInternalServerError {
+		t.Errorf("incorrect delivery log: got '%+v'", deliveries)
+	}
+	if fake.badSigs != 0 {
+		t.Errorf("expected every payload to be signed correctly, got '%d' bad", fake.badSigs)
+	}
+}
+
+func TestDeliverRetriesUntilLastAttempt(t *testing.T) {
+	useTestStore(t)
+	allowLoopback(t)
+
+	fake := &receiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	webhook, _ := Register("u1", server.URL, nil)
+	fake.secret = webhook.Secret
+	delivery, err := newDelivery(webhook, EpisodeAdded, map[string]int{"show_id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivery, err = deliver(webhook, delivery, false)
+	if err == nil || delivery.Status != DeliveryRetrying {
+		t.Errorf("expected delivery to be retried, got '%+v'", delivery)
+	}
+	delivery, err = deliver(webhook, delivery, true)
+	if err == nil || delivery.Status != DeliveryFailed || delivery.Attempts != 2 {
+		t.Errorf("expected delivery to fail on the last attempt, got '%+v'", delivery)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	useTestStore(t)
+	allowLoopback(t)
+	RegisterJobs()
+
+	fake := &receiver{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	all, _ := Register("u1", server.URL, nil)
+	cancelled, _ := Register("u1", server.URL+"/cancelled", []string{EpisodeCancelled})
+	// both webhooks share a receiver, so share a secret too
+	fake.secret = all.Secret
+	if err := store.AddWebhook(Webhook{ID: cancelled.ID, UserID: "u1", URL: cancelled.URL,
+		Secret: all.Secret, Events: cancelled.Events}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop, err := jobs.Start(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	Dispatch("u1", EpisodeRescheduled, map[string]int{"show_id": 1})
+	Dispatch("u1", EpisodeCancelled, map[string]int{"show_id": 1})
+	Dispatch("u2", EpisodeCancelled, map[string]int{"show_id": 1})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mu.Lock()
+		count := len(fake.received)
+		fake.mu.Unlock()
+		if count == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("incorrect number of deliveries: expected '3', got '%d'", count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	deliveries, _, _ := Deliveries("u1", cancelled.ID)
+	if len(deliveries) != 1 || deliveries[0].Event != EpisodeCancelled {
+		t.Errorf("incorrect deliveries for cancelled-only webhook: got '%+v'", deliveries)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1567476000, 0)
+	body := []byte(`{"event":"ping"}`)
+	header := func(timestamp int64, signedBody []byte) http.Header {
+		h := http.Header{}
+		h.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		h.Set(SignatureHeader, Sign("secret", timestamp, signedBody))
+		return h
+	}
+
+	cases := []struct {
+		name    string
+		header  http.Header
+		wantErr bool
+	}{
+		{"valid", header(now.Unix(), body), false},
+		{"replayed", header(now.Add(-time.Hour).Unix(), body), true},
+		{"from the future", header(now.Add(time.Hour).Unix(), body), true},
+		{"tampered body", header(now.Unix(), []byte(`{"event":"other"}`)), true},
+		{"no timestamp", http.Header{SignatureHeader: []string{Sign("secret", now.Unix(), body)}}, true},
+	}
+
+	for _, c := range cases {
+		err := Verify("secret", c.header, body, now)
+		if gotErr := (err != nil); gotErr != c.wantErr {
+			t.Errorf("incorrect output error for '%s': expected '%t', got '%t' (%v)",
+				c.name, c.wantErr, gotErr, err)
+		}
+	}
+
+	// a timestamp moved forward doesn't match the signature
+	replayed := header(now.Unix()-60, body)
+	replayed.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	if Verify("secret", replayed, body, now) == nil {
+		t.Errorf("expected a changed timestamp to fail verification")
+	}
+}